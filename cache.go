@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"pic-sorter/pkg/geocode"
+)
+
+// Run the cache command: export, import or prune a geocode cache file
+func runCache(args []string) error {
+	fs := flag.NewFlagSet("cache", flag.ExitOnError)
+	var provider string
+	fs.StringVar(&provider, "provider", "nominatim", "geocoding provider whose cache file is used: "+providerNames)
+	fs.StringVar(&provider, "geocoder", "nominatim", "alias of -provider")
+	lang := fs.String("lang", geocode.DefaultLanguage, "language of the cached place names")
+	granularity := fs.String("granularity", strings.Join(geocode.Fields, ","), "location fields of the sort runs that filled the cache")
+	depth := fs.Int("depth", 0, "keep only the first N location levels (0 keeps all)")
+	cacheFile := fs.String("cache-file", "", "geocode cache file (default per provider in the user cache directory, as for sort)")
+	out := fs.String("out", "", "export: write to this file instead of stdout")
+	olderThan := fs.Duration("older-than", 0, "prune: remove entries fetched longer ago than this, e.g. 4320h")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: pic-sorter cache export|import|prune [flags] [file...]\n\n"+
+			"export writes the entries as JSON lines, import merges such files (or stdin)\n"+
+			"into the cache, and prune removes old entries.\n\n")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs.Usage()
+		return fmt.Errorf("cache needs export, import or prune")
+	}
+	action := args[0]
+	if err := parseWithConfig(fs, args[1:]); err != nil {
+		return err
+	}
+
+	if *cacheFile == "" {
+		levels, err := locationLevels(*granularity, *depth)
+		if err != nil {
+			return err
+		}
+		providers, err := providerChain(provider)
+		if err != nil {
+			return err
+		}
+		*cacheFile = defaultCacheFile(chainName(providers), *lang, geocode.ZoomFor(levels))
+	}
+
+	switch action {
+	case "export":
+		var w io.Writer = os.Stdout
+		if *out != "" {
+			file, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+		n, err := geocode.ExportCache(*cacheFile, w)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d entries of %s\n", n, *cacheFile)
+	case "import":
+		readers := []io.Reader{os.Stdin}
+		if fs.NArg() > 0 {
+			readers = nil
+			for _, path := range fs.Args() {
+				file, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				readers = append(readers, file)
+			}
+		}
+		n := 0
+		for _, r := range readers {
+			imported, err := geocode.ImportCache(*cacheFile, r)
+			if err != nil {
+				return err
+			}
+			n += imported
+		}
+		fmt.Fprintf(os.Stderr, "Imported %d new or newer entries into %s\n", n, *cacheFile)
+	case "prune":
+		if *olderThan <= 0 {
+			return fmt.Errorf("prune needs -older-than")
+		}
+		n, err := geocode.PruneCache(*cacheFile, *olderThan)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Removed %d entries older than %s from %s\n", n, *olderThan, *cacheFile)
+	default:
+		return fmt.Errorf("unknown cache action %q, want export, import or prune", action)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Settings read from a config file: flag values by flag name, per
+// section. Top-level settings are in section "" and apply to every
+// command that has the flag; a section named after a command applies to
+// that command only.
+type config map[string]map[string][]string
+
+// Flags that set the same value as another flag
+var flagAliases = map[string]string{"geocoder": "provider"}
+
+// Default path of the config file, or "" if there is no config directory
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pic-sorter", "config.yaml")
+}
+
+// Parse the command line of a command, then fill in the flags it did not
+// set from the config file given with -config or found at the default path
+func parseWithConfig(flags *flag.FlagSet, args []string) error {
+	path := flags.String("config", "", "YAML file with default flag values (default "+defaultConfigPath()+")")
+	flags.Parse(args)
+
+	explicit := *path != ""
+	if !explicit {
+		*path = defaultConfigPath()
+		if *path == "" {
+			return nil
+		}
+	}
+	cfg, err := readConfig(*path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return cfg.apply(flags)
+}
+
+// Set every flag the command line left alone to its config value, with
+// environment variables such as ${LOCATIONIQ_API_KEY} expanded. The
+// command's section comes first, so a list it sets replaces the
+// top-level one rather than adding to it.
+func (c config) apply(flags *flag.FlagSet) error {
+	set := make(map[string]bool)
+	markSet := func(name string) {
+		set[name] = true
+		for alias, target := range flagAliases {
+			if name == alias {
+				set[target] = true
+			} else if name == target {
+				set[alias] = true
+			}
+		}
+	}
+	flags.Visit(func(f *flag.Flag) { markSet(f.Name) })
+
+	command := flags.Name()
+	for _, section := range []string{command, ""} {
+		for alias, name := range flagAliases {
+			_, hasAlias := c[section][alias]
+			_, hasName := c[section][name]
+			if hasAlias && hasName {
+				return fmt.Errorf("config: set either %s or %s, not both", alias, name)
+			}
+		}
+		for name, values := range c[section] {
+			if flags.Lookup(name) == nil {
+				if section == "" {
+					continue // a setting of another command
+				}
+				return fmt.Errorf("config: %s has no flag -%s", command, name)
+			}
+			if set[name] {
+				continue
+			}
+			for _, value := range values {
+				if err := flags.Set(name, expandEnv(value)); err != nil {
+					return fmt.Errorf("config: %s: %w", name, err)
+				}
+			}
+			markSet(name)
+		}
+	}
+	return nil
+}
+
+// A ${NAME} reference to an environment variable
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Replace ${NAME} references with the environment variables they name,
+// leaving any other $ alone, e.g. in a regular expression
+func expandEnv(value string) string {
+	return envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		return os.Getenv(ref[2 : len(ref)-1])
+	})
+}
+
+// Read a config file written in a small subset of YAML: "key: value"
+// pairs, lists as "[a, b]" or indented "- item" lines, one level of
+// command sections, quoted strings and # comments
+func readConfig(path string) (config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cfg := config{"": {}}
+	section, listKey, pending := "", "", ""
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		fail := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s", path, n, fmt.Sprintf(format, args...))
+		}
+
+		line := strings.TrimRight(stripComment(scanner.Text()), " \t")
+		content := strings.TrimLeft(line, " ")
+		if content == "" {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fail("tabs are not allowed for indentation")
+		}
+		indent := len(line) - len(content)
+
+		// A top-level key without a value starts a section or a list,
+		// depending on what follows
+		if pending != "" {
+			if indent > 0 && !strings.HasPrefix(content, "- ") {
+				section, listKey = pending, ""
+				if cfg[section] == nil {
+					cfg[section] = map[string][]string{}
+				}
+			} else {
+				section, listKey = "", pending
+				cfg[""][pending] = nil
+			}
+			pending = ""
+		}
+
+		if item, ok := strings.CutPrefix(content, "- "); ok {
+			if listKey == "" {
+				return nil, fail("list item outside of a list")
+			}
+			value, err := unquote(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fail("%s", err)
+			}
+			cfg[section][listKey] = append(cfg[section][listKey], value)
+			continue
+		}
+
+		key, value, found := strings.Cut(content, ":")
+		if !found || strings.ContainsAny(key, " \"'") {
+			return nil, fail("expected key: value")
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case indent == 0 && value == "":
+			pending = key
+			continue
+		case indent == 0:
+			section = ""
+		case section == "":
+			return nil, fail("unexpected indentation")
+		}
+
+		listKey = ""
+		if value == "" {
+			listKey = key
+			cfg[section][key] = nil
+			continue
+		}
+		values, err := parseValue(value)
+		if err != nil {
+			return nil, fail("%s", err)
+		}
+		cfg[section][key] = values
+	}
+	if pending != "" {
+		cfg[""][pending] = nil
+	}
+	return cfg, scanner.Err()
+}
+
+// Remove a # comment that is not inside quotes
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Parse a scalar or an inline "[a, b]" list
+func parseValue(value string) ([]string, error) {
+	inner, ok := strings.CutPrefix(value, "[")
+	if !ok {
+		v, err := unquote(value)
+		return []string{v}, err
+	}
+	inner, ok = strings.CutSuffix(inner, "]")
+	if !ok {
+		return nil, errors.New("unterminated list")
+	}
+
+	var values []string
+	var quote rune
+	start := 0
+	for i, r := range inner + "," {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			item := strings.TrimSpace(inner[start:min(i, len(inner))])
+			start = i + 1
+			if item == "" {
+				continue
+			}
+			v, err := unquote(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// Remove YAML double or single quotes from a scalar
+func unquote(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		return strconv.Unquote(s)
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	return s, nil
+}
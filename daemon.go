@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"pic-sorter/pkg/sorter"
+)
+
+// Jobs that may wait in the daemon's queue before submissions are refused
+const maxQueuedJobs = 100
+
+// States of a daemon job
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// Totals of a finished job, the JSON form of a sorter.Summary
+type jobSummary struct {
+	Moved      int      `json:"moved"`
+	Sidecars   int      `json:"sidecars"`
+	Failed     int      `json:"failed"`
+	Duplicates int      `json:"duplicates"`
+	Skipped    int      `json:"skipped"`
+	NoGPS      int      `json:"no_gps"`
+	BytesMoved int64    `json:"bytes_moved"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+func newJobSummary(s sorter.Summary) *jobSummary {
+	js := &jobSummary{
+		Moved:      s.Moved,
+		Sidecars:   s.Sidecars,
+		Failed:     s.Failed,
+		Duplicates: s.Duplicates,
+		Skipped:    s.Skipped,
+		NoGPS:      s.NoGPS,
+		BytesMoved: s.BytesMoved,
+	}
+	for _, err := range s.Errors {
+		js.Errors = append(js.Errors, err.Error())
+	}
+	return js
+}
+
+// A sort or undo request handled by the daemon
+type daemonJob struct {
+	ID        string      `json:"id"`
+	Kind      string      `json:"kind"` // "sort" or "undo"
+	Src       string      `json:"src,omitempty"`
+	UndoOf    string      `json:"undo_of,omitempty"` // job whose moves are undone
+	Force     bool        `json:"force,omitempty"`   // undo files whose checksum changed
+	Status    string      `json:"status"`
+	Error     string      `json:"error,omitempty"`
+	Journal   string      `json:"journal,omitempty"`
+	Submitted time.Time   `json:"submitted"`
+	Started   *time.Time  `json:"started,omitempty"`
+	Finished  *time.Time  `json:"finished,omitempty"`
+	Summary   *jobSummary `json:"summary,omitempty"`
+
+	moves  []sorter.Move
+	upload bool // Src holds uploaded files and is removed once empty
+}
+
+// Runs sort and undo jobs one at a time on a shared sort session and
+// serves their status over HTTP
+type daemon struct {
+	session *sortSession
+	dest    string
+	host    string // host of -addr; requests must name it or a loopback name
+	token   string // required as a bearer token unless empty
+	logger  *slog.Logger
+	metrics *serviceMetrics
+
+	mu     sync.Mutex
+	jobs   map[string]*daemonJob
+	nextID int
+	queue  chan *daemonJob
+}
+
+// Add a job to the queue, or fail if the queue is full. Only submit
+// adds to the queue, so a job that finds room never blocks.
+func (d *daemon) submit(job *daemonJob) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.queue) == cap(d.queue) {
+		return errors.New("too many queued jobs")
+	}
+	d.nextID++
+	job.ID = strconv.Itoa(d.nextID)
+	job.Status = jobQueued
+	job.Submitted = time.Now()
+	d.jobs[job.ID] = job
+	d.queue <- job
+	return nil
+}
+
+// Run queued jobs until ctx is done
+func (d *daemon) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-d.queue:
+			d.run(job)
+		}
+	}
+}
+
+// Run one job and record its outcome
+func (d *daemon) run(job *daemonJob) {
+	d.mu.Lock()
+	started := time.Now()
+	job.Status, job.Started = jobRunning, &started
+	d.mu.Unlock()
+	d.logger.Info("starting job", "job", job.ID, "kind", job.Kind, "src", job.Src)
+
+	var summary sorter.Summary
+	var err error
+	if job.Kind == "undo" {
+		summary, err = d.undo(job)
+	} else {
+		summary, err = d.sort(job)
+	}
+	d.session.save()
+	if job.Kind != "undo" {
+		d.metrics.observe(summary, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	finished := time.Now()
+	job.Finished, job.Summary, job.moves = &finished, newJobSummary(summary), summary.Moves
+	job.Status = jobDone
+	if err != nil {
+		job.Status, job.Error = jobFailed, err.Error()
+		d.logger.Error("job failed", "job", job.ID, "reason", err)
+		return
+	}
+	d.logger.Info("job done", "job", job.ID, "moved", summary.Moved, "failed", summary.Failed)
+}
+
+// Sort the job's directory with a journal of its own, so it can be
+// undone separately
+func (d *daemon) sort(job *daemonJob) (sorter.Summary, error) {
+	s := d.session.sorter
+	if !s.Options.DryRun {
+		path := strings.TrimSuffix(sorter.DefaultJournalPath(d.dest, time.Now()), ".jsonl") + "-" + job.ID + ".jsonl"
+		journal, err := sorter.CreateJournal(path)
+		if err != nil {
+			return sorter.Summary{}, err
+		}
+		s.Journal = journal
+		defer func() {
+			journal.Close()
+			s.Journal = nil
+		}()
+		d.mu.Lock()
+		job.Journal = path
+		d.mu.Unlock()
+	}
+
+	summary, err := s.Run(job.Src)
+	if job.upload {
+		// Only removes the directory if every upload was sorted
+		os.Remove(job.Src)
+	}
+	return summary, err
+}
+
+// Move the files of an earlier job back
+func (d *daemon) undo(job *daemonJob) (sorter.Summary, error) {
+	d.mu.Lock()
+	path := d.jobs[job.UndoOf].Journal
+	d.mu.Unlock()
+
+	entries, err := sorter.ReadJournal(path)
+	if err != nil {
+		return sorter.Summary{}, err
+	}
+	summary := sorter.Undo(entries, job.Force, d.logger)
+	if summary.Failed > 0 {
+		return summary, fmt.Errorf("%d files could not be restored", summary.Failed)
+	}
+	return summary, nil
+}
+
+// Write v as the JSON response
+func writeJSON(rw http.ResponseWriter, status int, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
+
+func writeError(rw http.ResponseWriter, status int, err error) {
+	writeJSON(rw, status, map[string]string{"error": err.Error()})
+}
+
+// Return a copy of a job that is safe to encode while it runs
+func (d *daemon) snapshot(id string) (daemonJob, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	job, ok := d.jobs[id]
+	if !ok {
+		return daemonJob{}, false
+	}
+	return *job, true
+}
+
+// Route the API:
+//
+//	GET  /jobs                list jobs
+//	POST /jobs                sort {"src": dir}, or uploaded files as multipart "file" fields
+//	GET  /jobs/{id}           status and summary of a job
+//	GET  /jobs/{id}/manifest  moves of a finished job
+//	POST /jobs/{id}/undo      queue an undo of a job; ?force=1 ignores changed checksums
+//	GET  /metrics             totals of the sort jobs for Prometheus
+//	GET  /healthz             "ok" unless the last sort job failed
+//
+// Monitoring routes need no token, as they reveal no paths. Requests a
+// browser sends on behalf of another site are refused, and so are those
+// naming another host, so a web page cannot start jobs on a daemon
+// without a token, not even through a domain resolving to this machine.
+func (d *daemon) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && (r.URL.Path == "/metrics" || r.URL.Path == "/healthz") {
+		d.metrics.handler().ServeHTTP(rw, r)
+		return
+	}
+	if !d.knownHost(r.Host) {
+		writeError(rw, http.StatusForbidden, fmt.Errorf("unknown host %q", r.Host))
+		return
+	}
+	if crossSite(r) {
+		writeError(rw, http.StatusForbidden, errors.New("cross-site requests are not allowed"))
+		return
+	}
+	if d.token != "" {
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(d.token)) != 1 {
+			writeError(rw, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "jobs" && r.Method == http.MethodGet:
+		d.handleList(rw)
+	case len(parts) == 1 && parts[0] == "jobs" && r.Method == http.MethodPost:
+		d.handleSubmit(rw, r)
+	case len(parts) == 2 && parts[0] == "jobs" && r.Method == http.MethodGet:
+		d.handleJob(rw, parts[1], false)
+	case len(parts) == 3 && parts[0] == "jobs" && parts[2] == "manifest" && r.Method == http.MethodGet:
+		d.handleJob(rw, parts[1], true)
+	case len(parts) == 3 && parts[0] == "jobs" && parts[2] == "undo" && r.Method == http.MethodPost:
+		d.handleUndo(rw, r, parts[1])
+	default:
+		writeError(rw, http.StatusNotFound, fmt.Errorf("no route for %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// Report whether host, the Host header of a request, names the daemon:
+// the host of -addr or a loopback name. A page whose domain resolves to
+// this machine (DNS rebinding) sends its own domain instead. A daemon
+// listening on every interface has a token and takes any name.
+func (d *daemon) knownHost(host string) bool {
+	if wildcardHost(d.host) {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	return strings.EqualFold(host, d.host) || loopbackHost(host)
+}
+
+// Report whether host is "localhost" or a loopback IP address
+func loopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Report whether host listens on every interface, e.g. "0.0.0.0"
+func wildcardHost(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "" || (ip != nil && ip.IsUnspecified())
+}
+
+// Report whether a browser sent r from a page of another origin, per its
+// Sec-Fetch-Site or Origin header; other clients send neither
+func crossSite(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+func (d *daemon) handleList(rw http.ResponseWriter) {
+	d.mu.Lock()
+	jobs := make([]daemonJob, 0, len(d.jobs))
+	for _, job := range d.jobs {
+		jobs = append(jobs, *job)
+	}
+	d.mu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Submitted.Before(jobs[j].Submitted) })
+	writeJSON(rw, http.StatusOK, jobs)
+}
+
+func (d *daemon) handleJob(rw http.ResponseWriter, id string, manifest bool) {
+	job, ok := d.snapshot(id)
+	switch {
+	case !ok:
+		writeError(rw, http.StatusNotFound, fmt.Errorf("no job %q", id))
+	case !manifest:
+		writeJSON(rw, http.StatusOK, job)
+	case job.Finished == nil:
+		writeError(rw, http.StatusConflict, fmt.Errorf("job %s is %s", id, job.Status))
+	default:
+		moves := job.moves
+		if moves == nil {
+			moves = []sorter.Move{}
+		}
+		writeJSON(rw, http.StatusOK, moves)
+	}
+}
+
+func (d *daemon) handleSubmit(rw http.ResponseWriter, r *http.Request) {
+	job := &daemonJob{Kind: "sort"}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		src, err := d.saveUploads(r)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		job.Src, job.upload = src, true
+	case "application/json":
+		var body struct {
+			Src string `json:"src"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Src == "" {
+			writeError(rw, http.StatusBadRequest, errors.New(`expected {"src": "<directory>"} or a multipart upload`))
+			return
+		}
+		if info, err := os.Stat(body.Src); err != nil || !info.IsDir() {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("%s is not a directory", body.Src))
+			return
+		}
+		job.Src = body.Src
+	default:
+		writeError(rw, http.StatusUnsupportedMediaType, errors.New("expected Content-Type application/json or multipart/form-data"))
+		return
+	}
+
+	if err := d.submit(job); err != nil {
+		writeError(rw, http.StatusServiceUnavailable, err)
+		return
+	}
+	snapshot, _ := d.snapshot(job.ID)
+	writeJSON(rw, http.StatusAccepted, snapshot)
+}
+
+// Save the files of a multipart upload into a new directory under
+// -dest/.pic-sorter/uploads and return it
+func (d *daemon) saveUploads(r *http.Request) (string, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", err
+	}
+	uploads := filepath.Join(d.dest, ".pic-sorter", "uploads")
+	if err := os.MkdirAll(uploads, os.ModePerm); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(uploads, "")
+	if err != nil {
+		return "", err
+	}
+
+	saved := 0
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+		// Keep only the base name so uploads cannot escape the directory
+		name := filepath.Base(filepath.Clean("/" + filepath.FromSlash(part.FileName())))
+		if part.FormName() != "file" || name == string(filepath.Separator) {
+			continue
+		}
+		file, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_, err = io.Copy(file, part)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+		saved++
+	}
+	if saved == 0 {
+		os.RemoveAll(dir)
+		return "", errors.New(`no "file" fields in the upload`)
+	}
+	return dir, nil
+}
+
+func (d *daemon) handleUndo(rw http.ResponseWriter, r *http.Request, id string) {
+	target, ok := d.snapshot(id)
+	switch {
+	case !ok:
+		writeError(rw, http.StatusNotFound, fmt.Errorf("no job %q", id))
+		return
+	case target.Kind != "sort" || target.Journal == "":
+		writeError(rw, http.StatusConflict, fmt.Errorf("job %s has no journal to undo", id))
+		return
+	case target.Finished == nil:
+		writeError(rw, http.StatusConflict, fmt.Errorf("job %s is %s", id, target.Status))
+		return
+	}
+
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	job := &daemonJob{Kind: "undo", UndoOf: id, Force: force}
+	if err := d.submit(job); err != nil {
+		writeError(rw, http.StatusServiceUnavailable, err)
+		return
+	}
+	snapshot, _ := d.snapshot(job.ID)
+	writeJSON(rw, http.StatusAccepted, snapshot)
+}
+
+// Run the daemon command
+func runDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	flags := registerSortFlags(fs)
+	addr := fs.String("addr", "localhost:8081", "address the API listens on")
+	token := fs.String("api-token", os.Getenv("PIC_SORTER_API_TOKEN"), "bearer token required by the API (default $PIC_SORTER_API_TOKEN)")
+	var logs logFlags
+	logs.register(fs)
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(*addr)
+	if err != nil {
+		return fmt.Errorf("-addr: %w", err)
+	}
+	if *token == "" && !loopbackHost(host) {
+		return errors.New("-api-token is required unless -addr is a loopback address")
+	}
+
+	logger, closeLog, err := logs.setup()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	// Progress bars and prompts make no sense for a service
+	*flags.noProgress, *flags.interactive = true, false
+	session, err := flags.newSession(logger)
+	if err != nil {
+		return err
+	}
+	defer session.close()
+
+	d := &daemon{
+		session: session,
+		dest:    *flags.dest,
+		host:    host,
+		token:   *token,
+		logger:  logger,
+		metrics: newServiceMetrics(),
+		jobs:    make(map[string]*daemonJob),
+		queue:   make(chan *daemonJob, maxQueuedJobs),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	worker := make(chan struct{})
+	go func() {
+		defer close(worker)
+		d.work(ctx)
+	}()
+
+	server := &http.Server{Addr: *addr, Handler: d}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	logger.Info("daemon listening", "addr", *addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		stop()
+		<-worker
+		return err
+	}
+	// Let a running job finish so its journal and the state are complete
+	<-worker
+	return nil
+}
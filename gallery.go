@@ -0,0 +1,474 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pic-sorter/pkg/exifinfo"
+	"pic-sorter/pkg/sorter"
+	"pic-sorter/pkg/thumb"
+)
+
+//go:embed web/gallery/*.html
+var galleryFS embed.FS
+
+var galleryTemplates = template.Must(template.ParseFS(galleryFS, "web/gallery/*.html"))
+
+// Longer side of the previews on photo pages, in pixels
+const previewSize = 1280
+
+// Folders of a gallery below its output folder, each mirroring the
+// sorted tree
+const (
+	galleryFolders  = "folders"  // a page per folder
+	galleryPhotos   = "photos"   // a page per photo
+	galleryThumbs   = "thumbs"   // thumbnails
+	galleryPreviews = "previews" // larger previews for the photo pages
+	galleryFiles    = "files"    // the originals, with -originals
+)
+
+// A link in the trail of folders above a page
+type galleryCrumb struct {
+	Name string
+	URL  string
+}
+
+// A subfolder on a folder page
+type galleryFolderLink struct {
+	Name  string
+	URL   string
+	Count int // photos anywhere below it
+}
+
+// A photo on a folder page or on the map
+type galleryThumb struct {
+	Name  string  `json:"name"`
+	URL   string  `json:"url"` // of its page
+	Thumb string  `json:"thumb"`
+	Info  string  `json:"info"`
+	Lat   float64 `json:"lat,omitempty"`
+	Lon   float64 `json:"lon,omitempty"`
+}
+
+// Data of a folder page; the index page is the root folder with a map
+type galleryFolderPage struct {
+	Title   string
+	Crumbs  []galleryCrumb
+	Folders []galleryFolderLink
+	Photos  []galleryThumb
+	Map     []galleryThumb
+}
+
+// Data of a photo page
+type galleryPhotoPage struct {
+	Title    string
+	Crumbs   []galleryCrumb
+	Preview  string
+	Original string // empty unless the originals are copied
+	Prev     string
+	Next     string
+	MapURL   string
+	Fields   []exifinfo.Tag
+	EXIF     []exifinfo.Tag
+}
+
+// A folder of the sorted tree
+type galleryFolder struct {
+	photos []string       // relative paths, by capture time
+	counts map[string]int // photos below each subfolder, by name
+}
+
+// Writes a static site of the photos of a sorted tree
+type gallery struct {
+	root      string // absolute path of the sorted tree
+	out       string
+	title     string
+	thumbSize int
+	originals bool
+	logger    *slog.Logger
+
+	photos  map[string]sorter.CatalogEntry // by relative path
+	folders map[string]*galleryFolder      // by relative path, "" for the root
+	images  int                            // thumbnails, previews and originals written
+}
+
+// Return the folder at rel, adding it if needed
+func (g *gallery) folder(rel string) *galleryFolder {
+	folder, ok := g.folders[rel]
+	if !ok {
+		folder = &galleryFolder{counts: make(map[string]int)}
+		g.folders[rel] = folder
+	}
+	return folder
+}
+
+// Parent of a '/' separated relative path, "" for the root
+func parentFolder(rel string) string {
+	if parent := path.Dir(rel); parent != "." {
+		return parent
+	}
+	return ""
+}
+
+// Sort the photos into their folders and count them in every folder above
+func (g *gallery) index() {
+	for rel := range g.photos {
+		folder := parentFolder(rel)
+		g.folder(folder).photos = append(g.folder(folder).photos, rel)
+		for folder != "" {
+			parent := parentFolder(folder)
+			g.folder(parent).counts[path.Base(folder)]++
+			folder = parent
+		}
+	}
+	for _, folder := range g.folders {
+		sort.Slice(folder.photos, func(i, j int) bool {
+			a, b := g.photos[folder.photos[i]], g.photos[folder.photos[j]]
+			if !a.Time.Equal(b.Time) {
+				return a.Time.Before(b.Time)
+			}
+			return folder.photos[i] < folder.photos[j]
+		})
+	}
+}
+
+// Site paths of the pages and images of a photo or folder
+func folderPage(rel string) string {
+	if rel == "" {
+		return "index.html"
+	}
+	return path.Join(galleryFolders, rel, "index.html")
+}
+
+func photoPage(rel string) string    { return path.Join(galleryPhotos, rel+".html") }
+func thumbImage(rel string) string   { return path.Join(galleryThumbs, rel+".jpg") }
+func previewImage(rel string) string { return path.Join(galleryPreviews, rel+".jpg") }
+
+// Return the URL of the site path to relative to the page from, so the
+// site works from any folder or web server
+func siteLink(from, to string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir(from)), filepath.FromSlash(to))
+	if err != nil {
+		rel = to
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Return the links to the folders above a page, from the root down to
+// folder
+func (g *gallery) crumbs(page, folder string) []galleryCrumb {
+	crumbs := []galleryCrumb{{Name: g.title, URL: siteLink(page, folderPage(""))}}
+	if folder == "" {
+		return crumbs
+	}
+	sofar := ""
+	for _, part := range strings.Split(folder, "/") {
+		sofar = path.Join(sofar, part)
+		crumbs = append(crumbs, galleryCrumb{Name: part, URL: siteLink(page, folderPage(sofar))})
+	}
+	return crumbs
+}
+
+// Describe a photo in one line: place, date and camera
+func photoInfo(entry sorter.CatalogEntry) string {
+	photo := toWebPhoto("", entry)
+	var parts []string
+	for _, part := range []string{photo.Place, entry.Time.Format("2006-01-02 15:04"), photo.Camera} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " · ")
+}
+
+// Return a photo as shown on the page at page
+func (g *gallery) thumb(page, rel string) galleryThumb {
+	entry := g.photos[rel]
+	photo := galleryThumb{
+		Name:  path.Base(rel),
+		URL:   siteLink(page, photoPage(rel)),
+		Thumb: siteLink(page, thumbImage(rel)),
+		Info:  photoInfo(entry),
+	}
+	if entry.HasGPS {
+		photo.Lat, photo.Lon = entry.Lat, entry.Lon
+	}
+	return photo
+}
+
+// Write the page of a folder; the root one also gets the map of all
+// photos with coordinates
+func (g *gallery) writeFolder(rel string) error {
+	folder := g.folders[rel]
+	page := folderPage(rel)
+	data := galleryFolderPage{Title: g.title, Crumbs: g.crumbs(page, rel), Photos: []galleryThumb{}}
+	if rel != "" {
+		data.Title = path.Base(rel)
+	}
+
+	names := make([]string, 0, len(folder.counts))
+	for name := range folder.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sub := path.Join(rel, name)
+		data.Folders = append(data.Folders, galleryFolderLink{Name: name, URL: siteLink(page, folderPage(sub)), Count: folder.counts[name]})
+	}
+	for _, photo := range folder.photos {
+		data.Photos = append(data.Photos, g.thumb(page, photo))
+	}
+
+	name := "folder.html"
+	if rel == "" {
+		name = "index.html"
+		data.Map = []galleryThumb{}
+		for photo, entry := range g.photos {
+			if entry.HasGPS {
+				data.Map = append(data.Map, g.thumb(page, photo))
+			}
+		}
+		sort.Slice(data.Map, func(i, j int) bool { return data.Map[i].URL < data.Map[j].URL })
+	}
+	return g.writePage(page, name, data)
+}
+
+// Write the page of a photo with its previews, linked to its neighbours
+// in the folder
+func (g *gallery) writePhoto(rel string, neighbours []string, i int) error {
+	entry := g.photos[rel]
+	folder := parentFolder(rel)
+	page := photoPage(rel)
+	data := galleryPhotoPage{
+		Title:   path.Base(rel),
+		Crumbs:  g.crumbs(page, folder),
+		Preview: siteLink(page, previewImage(rel)),
+	}
+	if i > 0 {
+		data.Prev = siteLink(page, photoPage(neighbours[i-1]))
+	}
+	if i < len(neighbours)-1 {
+		data.Next = siteLink(page, photoPage(neighbours[i+1]))
+	}
+
+	taken := entry.Time.Format("2006-01-02 15:04:05")
+	if !entry.ExactTime {
+		taken += " (file date)"
+	}
+	photo := toWebPhoto(rel, entry)
+	data.Fields = append(data.Fields, exifinfo.Tag{Name: "Taken", Value: taken})
+	if photo.Place != "" {
+		data.Fields = append(data.Fields, exifinfo.Tag{Name: "Place", Value: photo.Place})
+	}
+	if photo.Camera != "" {
+		data.Fields = append(data.Fields, exifinfo.Tag{Name: "Camera", Value: photo.Camera})
+	}
+	if entry.HasGPS {
+		data.Fields = append(data.Fields, exifinfo.Tag{Name: "Coordinates", Value: fmt.Sprintf("%.6f, %.6f", entry.Lat, entry.Lon)})
+		data.MapURL = fmt.Sprintf("https://www.openstreetmap.org/?mlat=%.6f&mlon=%.6f#map=15/%.6f/%.6f", entry.Lat, entry.Lon, entry.Lat, entry.Lon)
+	}
+	if tags, err := exifinfo.Tags(entry.Path); err == nil {
+		data.EXIF = tags
+	}
+
+	if err := g.writeImage(thumbImage(rel), entry.Path, g.thumbSize); err != nil {
+		g.logger.Warn("cannot make thumbnail", "file", entry.Path, "reason", err)
+	}
+	if err := g.writeImage(previewImage(rel), entry.Path, previewSize); err != nil {
+		g.logger.Warn("cannot make preview", "file", entry.Path, "reason", err)
+	}
+	if g.originals {
+		original := path.Join(galleryFiles, rel)
+		if err := g.copyOriginal(original, entry.Path); err != nil {
+			return err
+		}
+		data.Original = siteLink(page, original)
+	}
+	return g.writePage(page, "photo.html", data)
+}
+
+// Run a template into the site file at page
+func (g *gallery) writePage(page, name string, data any) error {
+	dst := filepath.Join(g.out, filepath.FromSlash(page))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := galleryTemplates.ExecuteTemplate(file, name, data); err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %w", page, err)
+	}
+	return file.Close()
+}
+
+// Report whether the site file at dst is at least as new as src, so a
+// gallery written again only redoes the images of changed photos
+func upToDate(dst, src string) bool {
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return false
+	}
+	srcInfo, err := os.Stat(src)
+	return err == nil && !dstInfo.ModTime().Before(srcInfo.ModTime())
+}
+
+// Read the thumbnail of size pixels sort -thumbs wrote for the photo at
+// src in the tree under root, if it is not older than the photo
+func sortedThumb(root, src string, size int) ([]byte, bool) {
+	path := sorter.ThumbPath(root, src, size)
+	if !upToDate(path, src) {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	return data, err == nil
+}
+
+// Write a JPEG preview of src to the site path image
+func (g *gallery) writeImage(image, src string, size int) error {
+	dst := filepath.Join(g.out, filepath.FromSlash(image))
+	if upToDate(dst, src) {
+		return nil
+	}
+	data, found := sortedThumb(g.root, src, size)
+	if !found {
+		var err error
+		if data, err = thumb.Generate(src, size); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	g.images++
+	return os.WriteFile(dst, data, 0o644)
+}
+
+// Copy an original photo to the site path original
+func (g *gallery) copyOriginal(original, src string) error {
+	dst := filepath.Join(g.out, filepath.FromSlash(original))
+	if upToDate(dst, src) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	g.images++
+	return out.Close()
+}
+
+// Write the whole site
+func (g *gallery) write() error {
+	g.index()
+	if len(g.folders) == 0 {
+		g.folder("")
+	}
+	for rel := range g.folders {
+		if err := g.writeFolder(rel); err != nil {
+			return err
+		}
+	}
+	for _, folder := range g.folders {
+		for i, photo := range folder.photos {
+			if err := g.writePhoto(photo, folder.photos, i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Run the gallery command
+func runGallery(args []string) error {
+	fs := flag.NewFlagSet("gallery", flag.ExitOnError)
+	dest := fs.String("dest", sorter.DefaultDestRoot, "root directory of the sorted tree")
+	catalogPath := fs.String("catalog", "", "catalog file (default under -dest/.pic-sorter)")
+	out := fs.String("out", "site", "folder to write the site to")
+	title := fs.String("title", "Photos", "title of the index page")
+	thumbSize := fs.Int("thumb-size", thumb.DefaultSize, "longer side of thumbnails in pixels")
+	originals := fs.Bool("originals", false, "copy the original photos into the site and link them from the photo pages")
+	var logs logFlags
+	logs.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: pic-sorter gallery [flags] [key=value...]\n\n"+
+			"Query terms narrow the gallery like in 'pic-sorter query'.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+
+	logger, closeLog, err := logs.setup()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	query, err := sorter.ParseQuery(fs.Args())
+	if err != nil {
+		return err
+	}
+	if *catalogPath == "" {
+		*catalogPath = sorter.DefaultCatalogPath(*dest)
+	}
+	if _, err := os.Stat(*catalogPath); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no catalog at %s; sort some photos into %s first", *catalogPath, *dest)
+	}
+	catalog, err := sorter.OpenCatalog(*catalogPath)
+	if err != nil {
+		return err
+	}
+	root, err := filepath.Abs(*dest)
+	if err != nil {
+		return err
+	}
+	if site := absPath(*out); site == root || strings.HasPrefix(site, root+string(filepath.Separator)) {
+		return fmt.Errorf("-out %s is inside the sorted tree; write the site elsewhere", *out)
+	}
+
+	g := &gallery{
+		root:      root,
+		out:       *out,
+		title:     *title,
+		thumbSize: *thumbSize,
+		originals: *originals,
+		logger:    logger,
+		photos:    treePhotos(root, catalog.Query(query)),
+		folders:   make(map[string]*galleryFolder),
+	}
+	if err := g.write(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote a gallery of %d photos in %d folders to %s (%d images updated)\n", len(g.photos), len(g.folders), filepath.Join(*out, "index.html"), g.images)
+	return nil
+}
@@ -1,5 +1,5 @@
 module pic-sorter
 
-go 1.20
+go 1.21
 
 require github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
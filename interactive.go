@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"pic-sorter/pkg/exifinfo"
+	"pic-sorter/pkg/thumb"
+)
+
+// Width in characters of the previews shown by -interactive
+const previewWidth = 48
+
+// Asks the user where images without GPS data should go. Answers can be
+// remembered for the other such images of the same source folder.
+type prompter struct {
+	mu         sync.Mutex
+	in         *bufio.Reader
+	out        io.Writer
+	dest       string
+	preview    bool                // draw the image in the terminal
+	remembered map[string][]string // levels by source folder
+	previous   [][]string          // destinations entered so far, offered by number
+	eof        bool                // input ended; nothing more is asked
+}
+
+func newPrompter(in io.Reader, out io.Writer, dest string, preview bool) *prompter {
+	return &prompter{
+		in:         bufio.NewReader(in),
+		out:        out,
+		dest:       dest,
+		preview:    preview,
+		remembered: make(map[string][]string),
+	}
+}
+
+// Read one trimmed line of input
+func (p *prompter) readLine() (string, bool) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		p.eof = true
+		return "", false
+	}
+	return strings.TrimSpace(line), true
+}
+
+// Split a destination typed by the user into folder levels, dropping
+// empty, "." and ".." parts so the answer stays inside the tree
+func parseLevels(answer string) []string {
+	var levels []string
+	for _, level := range strings.Split(filepath.ToSlash(answer), "/") {
+		if level = strings.TrimSpace(level); level != "" && level != "." && level != ".." {
+			levels = append(levels, level)
+		}
+	}
+	return levels
+}
+
+// Ask for the folder levels of an image; nil leaves it in place
+func (p *prompter) ask(path string, info exifinfo.Info) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dir := filepath.Dir(path)
+	if levels, ok := p.remembered[dir]; ok {
+		return levels
+	}
+	if p.eof {
+		return nil
+	}
+
+	if p.preview {
+		if text, err := thumb.Terminal(path, previewWidth); err == nil {
+			fmt.Fprint(p.out, text)
+		}
+	}
+	when := "file time"
+	if info.ExactTime {
+		when = "EXIF time"
+	}
+	fmt.Fprintf(p.out, "%s has no GPS data (taken %s, %s)\n", path, info.Time.Format("2006-01-02 15:04"), when)
+	for i, levels := range p.previous {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, strings.Join(levels, "/"))
+	}
+	fmt.Fprintf(p.out, "Folder under %s, a number from the list, or empty to skip: ", p.dest)
+
+	answer, ok := p.readLine()
+	if !ok {
+		fmt.Fprintln(p.out)
+		return nil
+	}
+	var levels []string
+	if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(p.previous) {
+		levels = p.previous[n-1]
+	} else {
+		levels = parseLevels(answer)
+	}
+
+	fmt.Fprintf(p.out, "Do the same for the other images without GPS data in %s? [y/N] ", dir)
+	if again, ok := p.readLine(); ok && strings.HasPrefix(strings.ToLower(again), "y") {
+		p.remembered[dir] = levels
+	}
+	if len(levels) > 0 && !p.offered(levels) {
+		p.previous = append(p.previous, levels)
+	}
+	return levels
+}
+
+// Report whether levels are already in the numbered list
+func (p *prompter) offered(levels []string) bool {
+	joined := strings.Join(levels, "/")
+	for _, prev := range p.previous {
+		if strings.Join(prev, "/") == joined {
+			return true
+		}
+	}
+	return false
+}
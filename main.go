@@ -1,154 +1,793 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/rwcarlsen/goexif/exif"
+	"pic-sorter/pkg/geocode"
+	"pic-sorter/pkg/sorter"
+	"pic-sorter/pkg/storage"
 )
 
-// Extract GPS coordinates from an image
-func getGeoInfo(imagePath string) (float64, float64, error) {
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return 0, 0, err
+// A pic-sorter subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// Subcommands in the order they are listed in the usage text
+var commands = []command{
+	{"sort", "sort images into folders by location", runSort},
+	{"watch", "keep sorting new images as they appear in a folder", runWatch},
+	{"plan", "write the moves a sort would make to a file for review", runPlan},
+	{"apply", "carry out the moves of a reviewed plan", runApply},
+	{"review", "approve, reject or redirect planned moves folder by folder", runReview},
+	{"retry", "sort the images of the unsorted folder again", runRetry},
+	{"undo", "move the files of a sort run back using its journal", runUndo},
+	{"verify", "check that sorted photos are intact and in the right folders", runVerify},
+	{"query", "list sorted photos matching place, date or camera", runQuery},
+	{"serve", "browse the sorted photos on a map in the web browser", runServe},
+	{"export", "write the photo locations as GeoJSON or KML", runExport},
+	{"stats", "count the sorted photos per place, year, camera and folder", runStats},
+	{"gallery", "write a static HTML site of the sorted photos with a map", runGallery},
+	{"cache", "export, import or prune the geocode cache", runCache},
+	{"daemon", "run sort and undo jobs submitted over an HTTP API", runDaemon},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: pic-sorter <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'pic-sorter <command> -h' for the flags of a command.\n")
+}
+
+// Query parameters given as repeated -param key=value flags
+type queryParams url.Values
+
+func (p queryParams) String() string {
+	return url.Values(p).Encode()
+}
+
+func (p queryParams) Set(value string) error {
+	key, val, found := strings.Cut(value, "=")
+	if !found || key == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	if geocode.IsReserved(key) {
+		return fmt.Errorf("parameter %q cannot be overridden", key)
+	}
+	url.Values(p).Set(key, val)
+	return nil
+}
+
+// Logging flags shared by the commands
+type logFlags struct {
+	level  string
+	format string
+	file   string
+}
+
+func (l *logFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&l.level, "log-level", "info", "minimum level of log messages: debug, info, warn or error")
+	fs.StringVar(&l.format, "log-format", "text", "log format: text or json")
+	fs.StringVar(&l.file, "log-file", "", "append log messages to this file instead of stderr")
+}
+
+// Create the logger the flags describe and make it the default. The
+// returned function closes the log file, if any.
+func (l *logFlags) setup() (*slog.Logger, func(), error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.level)); err != nil {
+		return nil, nil, fmt.Errorf("invalid -log-level %q", l.level)
 	}
-	defer file.Close()
 
-	x, err := exif.Decode(file)
+	var w io.Writer = os.Stderr
+	closeLog := func() {}
+	if l.file != "" {
+		f, err := os.OpenFile(l.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, closeLog = f, func() { f.Close() }
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch l.format {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		closeLog()
+		return nil, nil, fmt.Errorf("unknown -log-format %q, want text or json", l.format)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	return logger, closeLog, nil
+}
+
+// Glob patterns given as repeated flags
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globList) Set(value string) error {
+	if _, err := filepath.Match(value, ""); err != nil {
+		return err
+	}
+	*g = append(*g, value)
+	return nil
+}
+
+// Source directories given as repeated flags; the first one given
+// replaces the default
+type sourceList struct {
+	dirs    []string
+	changed bool
+}
+
+func (l *sourceList) String() string {
+	return strings.Join(l.dirs, ",")
+}
+
+func (l *sourceList) Set(value string) error {
+	if !l.changed {
+		l.dirs, l.changed = nil, true
+	}
+	l.dirs = append(l.dirs, value)
+	return nil
+}
+
+// Report whether one of the directories is a storage URL
+func (l *sourceList) remote() bool {
+	for _, dir := range l.dirs {
+		if storage.IsURL(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Time shifts by camera name given as repeated name=shift flags
+type cameraShifts map[string]time.Duration
+
+func (c cameraShifts) String() string {
+	var shifts []string
+	for name, shift := range c {
+		shifts = append(shifts, name+"="+shift.String())
+	}
+	sort.Strings(shifts)
+	return strings.Join(shifts, ",")
+}
+
+func (c cameraShifts) Set(value string) error {
+	i := strings.LastIndex(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected camera=shift, got %q", value)
+	}
+	shift, err := time.ParseDuration(strings.TrimSpace(value[i+1:]))
 	if err != nil {
-		return 0, 0, err
+		return err
+	}
+	c[strings.TrimSpace(value[:i])] = shift
+	return nil
+}
+
+// Geofences given as repeated flags
+type fenceList []geocode.Fence
+
+func (l *fenceList) String() string {
+	names := make([]string, len(*l))
+	for i, fence := range *l {
+		names[i] = fence.Name
 	}
+	return strings.Join(names, ",")
+}
 
-	lat, lon, err := x.LatLong()
+func (l *fenceList) Set(value string) error {
+	fence, err := geocode.ParseFence(value)
 	if err != nil {
-		return 0, 0, err
+		return err
 	}
+	*l = append(*l, fence)
+	return nil
+}
+
+// Repeatable -bbox flag of south,west,north,east bounding boxes
+type boxList []geocode.Fence
 
-	return lat, lon, nil
+func (l *boxList) String() string {
+	boxes := make([]string, len(*l))
+	for i, box := range *l {
+		south, west, north, east := box.Polygon[0][0], box.Polygon[0][1], box.Polygon[2][0], box.Polygon[2][1]
+		boxes[i] = fmt.Sprintf("%g,%g,%g,%g", south, west, north, east)
+	}
+	return strings.Join(boxes, " ")
 }
 
-// Fetch location details (country, state, state district, county)
-func getLocationDetails(lat, lon float64) (map[string]string, error) {
-	url := fmt.Sprintf("https://nominatim.openstreetmap.org/reverse?format=json&lat=%f&lon=%f&zoom=10", lat, lon)
+func (l *boxList) Set(value string) error {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return fmt.Errorf("bounding box %q: want south,west,north,east", value)
+	}
+	var corners [4]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return fmt.Errorf("bounding box %q: %w", value, err)
+		}
+		corners[i] = v
+	}
+	south, west, north, east := corners[0], corners[1], corners[2], corners[3]
+	if south >= north || west >= east || south < -90 || north > 90 || west < -180 || east > 180 {
+		return fmt.Errorf("bounding box %q: want south < north and west < east in degrees", value)
+	}
+	*l = append(*l, geocode.Fence{
+		Name:    value,
+		Polygon: [][2]float64{{south, west}, {south, east}, {north, east}, {north, west}},
+	})
+	return nil
+}
 
-	// Create a new request with the accept-language header set to "en" (English)
-	req, err := http.NewRequest("GET", url, nil)
+// Run the sort command
+func runSort(args []string) error {
+	fs := flag.NewFlagSet("sort", flag.ExitOnError)
+	flags := registerSortFlags(fs)
+	var logs logFlags
+	logs.register(fs)
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+	return sortOnce(flags, logs)
+}
+
+// Run the retry command: sort the unsorted folder of -dest again, e.g.
+// after adding a GPX track or once the network is back. Images that still
+// cannot be sorted stay in, or move between, its subfolders.
+func runRetry(args []string) error {
+	fs := flag.NewFlagSet("retry", flag.ExitOnError)
+	flags := registerSortFlags(fs)
+	var logs logFlags
+	logs.register(fs)
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+
+	if *flags.unsorted == "" {
+		*flags.unsorted = sorter.DefaultUnsortedDir
+	}
+	// The unsorted folder is pic-sorter's own: its images are moved out
+	// whatever -mode says, and the state of earlier runs does not apply
+	flags.src.dirs = []string{filepath.Join(*flags.dest, *flags.unsorted)}
+	*flags.recursive, *flags.force, *flags.mode = true, true, sorter.ModeMove
+	if _, err := os.Stat(flags.src.dirs[0]); err != nil {
+		return fmt.Errorf("nothing to retry: %w", err)
+	}
+	return sortOnce(flags, logs)
+}
+
+// Sort -src once as the flags describe
+func sortOnce(flags *sortFlags, logs logFlags) error {
+	logger, closeLog, err := logs.setup()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	req.Header.Set("Accept-Language", "en")
+	defer closeLog()
 
-	// Perform the request
-	resp, err := http.DefaultClient.Do(req)
+	session, err := flags.newSession(logger)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer session.close()
+	if err := flags.openJournal(session); err != nil {
+		return err
+	}
+	s, out := session.sorter, session.out
+
+	var summary sorter.Summary
+	if flags.src.remote() || storage.IsURL(*flags.dest) {
+		if len(flags.src.dirs) > 1 {
+			return errors.New("only one -src can be sorted into or from storage")
+		}
+		summary, err = s.RunStorage(flags.src.dirs[0])
+	} else {
+		summary, err = s.Run(flags.src.dirs...)
+	}
+	summary.Print(out)
+	if s.Journal != nil && summary.Moved > 0 {
+		fmt.Fprintf(out, "Journal written to %s; undo with: pic-sorter undo %s\n", s.Journal.Path(), s.Journal.Path())
+	}
+	if *flags.planOut != "" {
+		if planErr := sorter.WritePlan(*flags.planOut, summary.Moves); planErr != nil && err == nil {
+			err = planErr
+		}
+	}
+	return finishRun(flags, summary, err)
+}
+
+// Write the errors report of a run if -errors-report asks for one and
+// turn failed files into a partialError
+func finishRun(flags *sortFlags, summary sorter.Summary, err error) error {
+	if *flags.errorsReport != "" {
+		if reportErr := sorter.WriteErrorReport(*flags.errorsReport, summary, err); reportErr != nil && err == nil {
+			err = reportErr
+		}
+	}
+	if err == nil && summary.Failed > 0 {
+		err = &partialError{count: summary.Failed, what: "files could not be sorted"}
 	}
-	defer resp.Body.Close()
+	return err
+}
+
+// Exit status of a command that finished but failed on some files, so
+// that cron jobs and scripts can tell it from a run that did not finish
+// (1) and a usage error (2)
+const exitPartial = 3
+
+// Returned by commands that processed everything they could but failed
+// on some files
+type partialError struct {
+	count int
+	what  string // e.g. "files could not be sorted"
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("%d %s", e.count, e.what)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error: %d", resp.StatusCode)
+// Run the plan command: a dry-run sort whose moves are written to a file
+// that apply can carry out after review
+func runPlan(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	flags := registerSortFlags(fs)
+	out := fs.String("out", "plan.json", "write the plan to this .json or .csv file")
+	var logs logFlags
+	logs.register(fs)
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
 	}
+	*flags.dryRun = true
 
-	body, err := io.ReadAll(resp.Body)
+	logger, closeLog, err := logs.setup()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer closeLog()
 
-	var data map[string]interface{}
-	if err := json.Unmarshal(body, &data); err != nil {
-		return nil, err
+	session, err := flags.newSession(logger)
+	if err != nil {
+		return err
 	}
+	defer session.close()
 
-	address, ok := data["address"].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid address data")
+	summary, err := session.sorter.Run(flags.src.dirs...)
+	summary.Print(session.out)
+	if err != nil {
+		return finishRun(flags, summary, err)
 	}
 
-	location := map[string]string{
-		"country":        getString(address, "country"),
-		"state":          getString(address, "state"),
-		"state_district": getString(address, "state_district"),
-		"county":         getString(address, "county"),
+	// Absolute paths let the plan be applied from any working directory
+	moves := make([]sorter.Move, len(summary.Moves))
+	for i, move := range summary.Moves {
+		moves[i] = sorter.Move{Src: absPath(move.Src), Dst: absPath(move.Dst)}
+	}
+	if err := sorter.WritePlan(*out, moves); err != nil {
+		return err
 	}
+	fmt.Fprintf(session.out, "Plan written to %s; carry it out with: pic-sorter apply %s\n", *out, *out)
+	return finishRun(flags, summary, nil)
+}
 
-	return location, nil
+// Return the absolute form of path, or path itself if that fails
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
 }
 
-// Helper function to get string from map
-func getString(data map[string]interface{}, key string) string {
-	if value, found := data[key]; found {
-		return fmt.Sprintf("%v", value)
+// Run the apply command
+func runApply(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	dest := fs.String("dest", sorter.DefaultDestRoot, "root directory of the sorted tree, where the journal goes")
+	mode := fs.String("mode", sorter.ModeMove, "how files are placed: move, copy, link or hardlink")
+	journalPath := fs.String("journal", "", "journal file for undo (default under -dest/.pic-sorter)")
+	var logs logFlags
+	logs.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: pic-sorter apply [flags] <plan>\n")
+		fs.PrintDefaults()
+	}
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	logger, closeLog, err := logs.setup()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	moves, err := sorter.ReadPlan(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *journalPath == "" {
+		*journalPath = sorter.DefaultJournalPath(*dest, time.Now())
 	}
-	return "Unknown"
+	journal, err := sorter.CreateJournal(*journalPath)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	summary, err := sorter.Apply(moves, *mode, journal, logger)
+	if err != nil {
+		return err
+	}
+	summary.Print(os.Stdout)
+	if summary.Moved > 0 {
+		fmt.Printf("Journal written to %s; undo with: pic-sorter undo %s\n", journal.Path(), journal.Path())
+	}
+	if summary.Failed > 0 {
+		return &partialError{count: summary.Failed, what: "moves could not be applied"}
+	}
+	return nil
+}
+
+// Run the watch command
+func runWatch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	flags := registerSortFlags(fs)
+	interval := fs.Duration("interval", sorter.DefaultWatchInterval, "time between scans of -src")
+	settle := fs.Duration("settle", sorter.DefaultWatchSettle, "how long -src must be unchanged before new files are sorted")
+	metricsAddr := fs.String("metrics-addr", "", "serve /metrics and /healthz on this address, e.g. localhost:9090")
+	var logs logFlags
+	logs.register(fs)
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+	if len(flags.src.dirs) > 1 {
+		return errors.New("watch takes a single -src")
+	}
+	src := flags.src.dirs[0]
+
+	logger, closeLog, err := logs.setup()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	session, err := flags.newSession(logger)
+	if err != nil {
+		return err
+	}
+	defer session.close()
+	if err := flags.openJournal(session); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	metrics := newServiceMetrics()
+	if *metricsAddr != "" {
+		server := &http.Server{Addr: *metricsAddr, Handler: metrics.handler()}
+		go func() {
+			<-ctx.Done()
+			server.Shutdown(context.Background())
+		}()
+		go func() {
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("cannot serve metrics", "addr", *metricsAddr, "reason", err)
+			}
+		}()
+		logger.Info("serving metrics", "addr", *metricsAddr)
+	}
+
+	logger.Info("watching for new images", "src", src, "interval", *interval, "settle", *settle)
+	return session.sorter.Watch(ctx, src, sorter.WatchOptions{
+		Interval: *interval,
+		Settle:   *settle,
+	}, func(summary sorter.Summary, err error) {
+		summary.Print(session.out)
+		if err != nil {
+			logger.Error("sort pass failed", "reason", err)
+		}
+		metrics.observe(summary, err)
+		session.save()
+	})
 }
 
-// Move image to the correct folder based on location
-func moveImage(imagePath string, location map[string]string) error {
-	// Create folder structure: country/state/state_district/county/
-	folderPath := filepath.Join(
-		"sorted_images",
-		sanitize(location["country"]),
-		sanitize(location["state"]),
-		sanitize(location["state_district"]),
-		sanitize(location["county"]),
-	)
+// Run the verify command: check that the photos of -dest are in the
+// folders the sort flags put them in and match the checksums of the
+// catalog, or of -manifest
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags := registerSortFlags(fs)
+	manifest := fs.String("manifest", "", "catalog (.json) or journal (.jsonl) whose checksums to check (default the catalog of -dest, if any)")
+	reportPath := fs.String("report", "", "also write the problems found to this JSON file")
+	var logs logFlags
+	logs.register(fs)
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+	// Verifying reads the tree and leaves no trace in it
+	*flags.dryRun, *flags.noState = true, true
+
+	logger, closeLog, err := logs.setup()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
 
-	if err := os.MkdirAll(folderPath, os.ModePerm); err != nil {
+	session, err := flags.newSession(logger)
+	if err != nil {
 		return err
 	}
+	defer session.close()
 
-	newImagePath := filepath.Join(folderPath, filepath.Base(imagePath))
-	return os.Rename(imagePath, newImagePath)
+	var sums map[string]string
+	path := *manifest
+	if path == "" {
+		path = sorter.DefaultCatalogPath(*flags.dest)
+		if _, err := os.Stat(path); err != nil {
+			path = ""
+		}
+	}
+	if path != "" {
+		if sums, err = sorter.ReadManifest(path); err != nil {
+			return err
+		}
+	}
+
+	report, err := session.sorter.Verify(sums)
+	if err != nil {
+		return err
+	}
+	report.Print(session.out)
+	if *reportPath != "" {
+		if err := report.Write(*reportPath); err != nil {
+			return err
+		}
+	}
+	if len(report.Issues) > 0 {
+		return &partialError{count: len(report.Issues), what: "problems found"}
+	}
+	return nil
 }
 
-// Sanitize folder names to remove special characters
-func sanitize(name string) string {
-	return strings.ReplaceAll(name, " ", "_")
+// Report whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
-// Process all images in a directory
-func processImages(directory string) {
-	files, err := os.ReadDir(directory)
+// Run the undo command
+func runUndo(args []string) error {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	force := fs.Bool("force", false, "restore files even if their checksum no longer matches the journal")
+	var logs logFlags
+	logs.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: pic-sorter undo [flags] <journal>\n")
+		fs.PrintDefaults()
+	}
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	logger, closeLog, err := logs.setup()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer closeLog()
 
-	for _, file := range files {
-		if !file.IsDir() && (strings.HasSuffix(file.Name(), ".jpg") || strings.HasSuffix(file.Name(), ".jpeg") || strings.HasSuffix(file.Name(), ".png")) {
-			imagePath := filepath.Join(directory, file.Name())
+	entries, err := sorter.ReadJournal(fs.Arg(0))
+	if err != nil {
+		return err
+	}
 
-			lat, lon, err := getGeoInfo(imagePath)
-			if err != nil {
-				fmt.Printf("No GPS data found for %s\n", file.Name())
-				continue
-			}
+	summary := sorter.Undo(entries, *force, logger)
+	fmt.Printf("Restored %d files, %d skipped\n", summary.Moved, summary.Failed)
+	if summary.Failed > 0 {
+		return &partialError{count: summary.Failed, what: "files could not be restored"}
+	}
+	return nil
+}
 
-			location, err := getLocationDetails(lat, lon)
-			if err != nil {
-				fmt.Printf("Error getting location for %s: %s\n", file.Name(), err)
-				continue
-			}
+// Run the query command
+func runQuery(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	dest := fs.String("dest", sorter.DefaultDestRoot, "root directory of the sorted tree")
+	catalogPath := fs.String("catalog", "", "catalog file (default under -dest/.pic-sorter)")
+	format := fs.String("format", "text", "output format: text (one path per line) or json (one entry per line)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: pic-sorter query [flags] [key=value...]\n\n"+
+			"Keys: country, state, state_district, county, city, place, make, model,\n"+
+			"year (2021), month (2021-07), day (2021-07-14), from, to (dates) and gps (yes/no).\n\n")
+		fs.PrintDefaults()
+	}
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown -format %q, want text or json", *format)
+	}
 
-			fmt.Printf("Moving %s to %s/%s/%s/%s\n",
-				file.Name(),
-				location["country"], location["state"], location["state_district"], location["county"],
-			)
+	query, err := sorter.ParseQuery(fs.Args())
+	if err != nil {
+		return err
+	}
+	if *catalogPath == "" {
+		*catalogPath = sorter.DefaultCatalogPath(*dest)
+	}
+	catalog, err := sorter.OpenCatalog(*catalogPath)
+	if err != nil {
+		return err
+	}
 
-			if err := moveImage(imagePath, location); err != nil {
-				fmt.Printf("Error moving file: %s\n", err)
-			}
+	enc := json.NewEncoder(os.Stdout)
+	for _, entry := range catalog.Query(query) {
+		// Photos moved away since, e.g. by undo, are no longer in the tree
+		if _, err := os.Stat(entry.Path); err != nil {
+			continue
+		}
+		if *format == "json" {
+			enc.Encode(entry)
+			continue
+		}
+		fmt.Println(entry.Path)
+	}
+	return nil
+}
+
+// Run the export command
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dest := fs.String("dest", sorter.DefaultDestRoot, "root directory of the sorted tree")
+	catalogPath := fs.String("catalog", "", "catalog file (default under -dest/.pic-sorter)")
+	format := fs.String("format", sorter.ExportGeoJSON, "output format: geojson or kml")
+	out := fs.String("out", "", "write to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: pic-sorter export [flags] [key=value...]\n\n"+
+			"Query terms narrow the export like in 'pic-sorter query'.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+
+	query, err := sorter.ParseQuery(fs.Args())
+	if err != nil {
+		return err
+	}
+	if *catalogPath == "" {
+		*catalogPath = sorter.DefaultCatalogPath(*dest)
+	}
+	catalog, err := sorter.OpenCatalog(*catalogPath)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
 		}
+		defer file.Close()
+		w = file
 	}
+	return sorter.ExportLocations(w, *format, catalog.Query(query))
+}
+
+// Run the stats command: count the photos of the sorted tree per place,
+// time, camera and folder
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	dest := fs.String("dest", sorter.DefaultDestRoot, "root directory of the sorted tree")
+	catalogPath := fs.String("catalog", "", "catalog file (default under -dest/.pic-sorter)")
+	format := fs.String("format", sorter.StatsTable, "output format: table, json or csv")
+	depth := fs.Int("folder-depth", 1, "count folders down to this many levels below -dest")
+	out := fs.String("out", "", "write to this file instead of stdout")
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+	switch *format {
+	case sorter.StatsTable, sorter.StatsJSON, sorter.StatsCSV:
+	default:
+		return fmt.Errorf("unknown -format %q, want table, json or csv", *format)
+	}
+
+	if *catalogPath == "" {
+		*catalogPath = sorter.DefaultCatalogPath(*dest)
+	}
+	catalog, err := sorter.OpenCatalog(*catalogPath)
+	if err != nil {
+		return err
+	}
+	stats, err := sorter.TreeStats(*dest, catalog.Query(sorter.CatalogQuery{}), *depth)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return sorter.WriteStats(w, *format, stats)
 }
 
 func main() {
-	imageDirectory := "images" // Change this to your folder containing images
-	processImages(imageDirectory)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name, args := os.Args[1], os.Args[2:]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(args); err != nil {
+			if errors.Is(err, geocode.ErrBanned) {
+				slog.Error("banned by the geocoding service; stopping to avoid a longer ban. "+
+					"Make sure requests carry a descriptive User-Agent, slow down, and wait before running again.",
+					"reason", err)
+				os.Exit(1)
+			}
+			var partial *partialError
+			if errors.As(err, &partial) {
+				slog.Warn(cmd.name+" finished with errors", "reason", err)
+				os.Exit(exitPartial)
+			}
+			slog.Error(cmd.name+" failed", "reason", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "pic-sorter: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
 }
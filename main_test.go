@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"image"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pic-sorter/pkg/geocode"
+	"pic-sorter/pkg/sorter"
+)
+
+// Answers every position with one location and counts the lookups
+type fakeGeocoder struct {
+	location geocode.Location
+	mu       sync.Mutex
+	calls    int
+}
+
+func (g *fakeGeocoder) ReverseGeocode(lat, lon float64) (geocode.Location, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls++
+	return g.location, nil
+}
+
+// Write a small JPEG to path, with EXIF GPS data if gps is set
+func writeJPEG(t *testing.T, path string, gps *[2]float64) {
+	t.Helper()
+	var tiff []byte
+	if gps != nil {
+		tiff = gpsTIFF(gps[0], gps[1])
+	}
+	writeJPEGWithEXIF(t, path, tiff)
+}
+
+// Write a small JPEG to path, with tiff as its EXIF block if not nil
+func writeJPEGWithEXIF(t *testing.T, path string, tiff []byte) {
+	t.Helper()
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := img.Bytes()
+	if tiff != nil {
+		app1 := append([]byte("Exif\x00\x00"), tiff...)
+		segment := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(len(app1)+2))
+		data = append(append(append([]byte{0xff, 0xd8}, segment...), app1...), data[2:]...)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Build a big-endian TIFF structure whose IFD0 points to a GPS IFD
+func gpsTIFF(lat, lon float64) []byte {
+	be := binary.BigEndian
+	const ifd0, gpsIFD = 8, 8 + 2 + 12 + 4
+	const values = gpsIFD + 2 + 4*12 + 4
+	entry := func(b []byte, tag, typ uint16, count, value uint32) []byte {
+		b = be.AppendUint16(b, tag)
+		b = be.AppendUint16(b, typ)
+		b = be.AppendUint32(b, count)
+		return be.AppendUint32(b, value)
+	}
+	// Degrees, minutes and seconds as rationals
+	dms := func(b []byte, v float64) []byte {
+		v = math.Abs(v)
+		deg, min := math.Floor(v), math.Floor(math.Mod(v*60, 60))
+		sec := (v*3600 - deg*3600 - min*60) * 1000
+		for _, n := range []uint32{uint32(deg), 1, uint32(min), 1, uint32(math.Round(sec)), 1000} {
+			b = be.AppendUint32(b, n)
+		}
+		return b
+	}
+	latRef, lonRef := uint32('N')<<24, uint32('E')<<24
+	if lat < 0 {
+		latRef = uint32('S') << 24
+	}
+	if lon < 0 {
+		lonRef = uint32('W') << 24
+	}
+
+	b := []byte("MM\x00\x2a")
+	b = be.AppendUint32(b, ifd0)
+	b = be.AppendUint16(b, 1)
+	b = entry(b, 0x8825, 4, 1, gpsIFD)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint16(b, 4)
+	b = entry(b, 0x0001, 2, 2, latRef)
+	b = entry(b, 0x0002, 5, 3, values)
+	b = entry(b, 0x0003, 2, 2, lonRef)
+	b = entry(b, 0x0004, 5, 3, values+24)
+	b = be.AppendUint32(b, 0)
+	b = dms(b, lat)
+	return dms(b, lon)
+}
+
+// Sort src into dest with the sort flags args and geocoder, the way the
+// sort command does
+func sortWithFlags(t *testing.T, geocoder geocode.Geocoder, args ...string) sorter.Summary {
+	t.Helper()
+	fs := flag.NewFlagSet("sort", flag.ContinueOnError)
+	flags := registerSortFlags(fs)
+	if err := fs.Parse(append([]string{"-no-cache", "-no-progress"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	session, err := flags.newSession(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.close()
+	if err := flags.openJournal(session); err != nil {
+		t.Fatal(err)
+	}
+	session.sorter.Geocoder = geocoder
+
+	summary, err := session.sorter.Run(flags.src.dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+// List the files under root, relative and '/' separated, leaving out
+// pic-sorter's state
+func treeFiles(t *testing.T, root string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".pic-sorter" {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestSortEndToEnd(t *testing.T) {
+	paris := geocode.Location{
+		"country":        "France",
+		"state":          "Ile-de-France",
+		"state_district": "Paris",
+		"county":         "Paris",
+	}
+
+	tests := []struct {
+		name           string
+		args           []string
+		wantDest       []string
+		wantSrc        []string
+		wantMoved      int
+		wantNoGPS      int
+		wantUnresolved int
+	}{
+		{
+			name:      "no-GPS images stay in place",
+			wantDest:  []string{"France/Ile-de-France/Paris/Paris/gps.jpg"},
+			wantSrc:   []string{"nogps.jpg"},
+			wantMoved: 1,
+			wantNoGPS: 1,
+		},
+		{
+			name:           "no-GPS images go to the unsorted folder",
+			args:           []string{"-unsorted", "unsorted"},
+			wantDest:       []string{"France/Ile-de-France/Paris/Paris/gps.jpg", "unsorted/no-gps/nogps.jpg"},
+			wantMoved:      2,
+			wantUnresolved: 1,
+		},
+		{
+			name:      "custom levels",
+			args:      []string{"-granularity", "country,city"},
+			wantDest:  []string{"France/Unknown/gps.jpg"},
+			wantSrc:   []string{"nogps.jpg"},
+			wantMoved: 1,
+			wantNoGPS: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src, dest := t.TempDir(), t.TempDir()
+			writeJPEG(t, filepath.Join(src, "gps.jpg"), &[2]float64{48.8566, 2.3522})
+			writeJPEG(t, filepath.Join(src, "nogps.jpg"), nil)
+			geocoder := &fakeGeocoder{location: paris}
+
+			summary := sortWithFlags(t, geocoder, append([]string{"-src", src, "-dest", dest}, test.args...)...)
+
+			if got := treeFiles(t, dest); strings.Join(got, ",") != strings.Join(test.wantDest, ",") {
+				t.Errorf("destination holds %q, want %q", got, test.wantDest)
+			}
+			if got := treeFiles(t, src); strings.Join(got, ",") != strings.Join(test.wantSrc, ",") {
+				t.Errorf("source holds %q, want %q", got, test.wantSrc)
+			}
+			if summary.Moved != test.wantMoved || summary.NoGPS != test.wantNoGPS || summary.Unsorted != test.wantUnresolved {
+				t.Errorf("moved %d, no GPS %d, unsorted %d; want %d, %d, %d",
+					summary.Moved, summary.NoGPS, summary.Unsorted, test.wantMoved, test.wantNoGPS, test.wantUnresolved)
+			}
+			if geocoder.calls != 1 {
+				t.Errorf("geocoded %d times, want once for the image with GPS", geocoder.calls)
+			}
+		})
+	}
+}
+
+func TestSortSkipsSortedImages(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	writeJPEG(t, filepath.Join(src, "nogps.jpg"), nil)
+	geocoder := &fakeGeocoder{location: geocode.Location{"country": "France"}}
+
+	// An image left in place without GPS data is remembered and not read
+	// again by the next run
+	first := sortWithFlags(t, geocoder, "-src", src, "-dest", dest)
+	second := sortWithFlags(t, geocoder, "-src", src, "-dest", dest)
+	if first.NoGPS != 1 || second.NoGPS != 0 {
+		t.Errorf("no GPS in the first run %d, in the second %d; want 1 and 0", first.NoGPS, second.NoGPS)
+	}
+	if got := treeFiles(t, src); len(got) != 1 {
+		t.Errorf("source holds %q, want the image left in place", got)
+	}
+}
+
+func TestSortCorruptEXIF(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	writeJPEGWithEXIF(t, filepath.Join(src, "corrupt.jpg"), []byte("XX\x00\x2a\x00\x00\x00\x08"))
+	writeJPEG(t, filepath.Join(src, "nogps.jpg"), nil)
+
+	sortWithFlags(t, &fakeGeocoder{}, "-src", src, "-dest", dest, "-unsorted", "unsorted")
+
+	want := []string{"unsorted/corrupt-exif/corrupt.jpg", "unsorted/no-gps/nogps.jpg"}
+	if got := treeFiles(t, dest); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("destination holds %q, want %q", got, want)
+	}
+}
+
+func TestConfigExpandsBracedVariables(t *testing.T) {
+	t.Setenv("PIC_SORTER_TEST_KEY", "secret")
+	fs := flag.NewFlagSet("sort", flag.ContinueOnError)
+	key := fs.String("api-key", "", "")
+	pattern := fs.String("ban-pattern", "", "")
+
+	cfg := config{"": {"api-key": {"${PIC_SORTER_TEST_KEY}"}, "ban-pattern": {"blocked$|$PIC_SORTER_TEST_KEY"}}}
+	if err := cfg.apply(fs); err != nil {
+		t.Fatal(err)
+	}
+	if *key != "secret" {
+		t.Errorf("api-key = %q, want %q", *key, "secret")
+	}
+	if want := "blocked$|$PIC_SORTER_TEST_KEY"; *pattern != want {
+		t.Errorf("ban-pattern = %q, want %q", *pattern, want)
+	}
+}
+
+func TestConfigCommandSectionWins(t *testing.T) {
+	fs := flag.NewFlagSet("sort", flag.ContinueOnError)
+	flags := registerSortFlags(fs)
+	cfg := config{
+		"":     {"exclude": {".thumbnails", "*.tmp"}, "geocoder": {"photon"}},
+		"sort": {"exclude": {"*.bak"}, "provider": {"locationiq"}},
+	}
+	if err := cfg.apply(fs); err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.Lookup("exclude").Value.String(); got != "*.bak" {
+		t.Errorf("exclude = %q, want only the sort section's list", got)
+	}
+	if flags.provider != "locationiq" {
+		t.Errorf("provider = %q, want the sort section's %q", flags.provider, "locationiq")
+	}
+
+	cfg = config{"": {"geocoder": {"photon"}, "provider": {"locationiq"}}}
+	if err := cfg.apply(flag.NewFlagSet("sort", flag.ContinueOnError)); err == nil {
+		t.Error("geocoder and provider in one section were accepted")
+	}
+}
+
+func TestDaemonRefusesOtherHosts(t *testing.T) {
+	tests := []struct {
+		addrHost, host string
+		want           bool
+	}{
+		{"localhost", "localhost:8081", true},
+		{"localhost", "127.0.0.1:8081", true},
+		{"localhost", "[::1]:8081", true},
+		{"127.0.0.1", "LOCALHOST.:8081", true},
+		{"localhost", "evil.example:8081", false},
+		{"localhost", "evil.example", false},
+		{"nas.lan", "nas.lan:8081", true},
+		{"nas.lan", "evil.example:8081", false},
+		{"0.0.0.0", "evil.example:8081", true},
+	}
+	for _, test := range tests {
+		d := &daemon{host: test.addrHost}
+		if got := d.knownHost(test.host); got != test.want {
+			t.Errorf("daemon on %s accepts Host %q: %v, want %v", test.addrHost, test.host, got, test.want)
+		}
+	}
+}
+
+func TestCopyRunRemembersRewrittenSource(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	image := filepath.Join(src, "gps.jpg")
+	writeJPEG(t, image, &[2]float64{48.8566, 2.3522})
+	geocoder := &fakeGeocoder{location: geocode.Location{"country": "France"}}
+	args := []string{"-src", src, "-dest", dest, "-mode", "copy", "-write-metadata", "-granularity", "country"}
+
+	if first := sortWithFlags(t, geocoder, args...); first.Moved != 1 {
+		t.Fatalf("first run copied %d files, want 1", first.Moved)
+	}
+	// A new modification time makes the state compare checksums
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(image, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if second := sortWithFlags(t, geocoder, args...); second.Moved != 0 {
+		t.Errorf("second run copied %d files, want the unchanged source skipped", second.Moved)
+	}
+	if got := treeFiles(t, dest); len(got) != 1 {
+		t.Errorf("destination holds %q, want a single copy", got)
+	}
+}
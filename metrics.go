@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"pic-sorter/pkg/sorter"
+)
+
+// Totals of the sort runs of a long-lived process, served in the
+// Prometheus text format on /metrics, and its health on /healthz
+type serviceMetrics struct {
+	started time.Time
+
+	mu         sync.Mutex
+	runs       int
+	runErrors  int
+	moved      int
+	sidecars   int
+	failed     int
+	duplicates int
+	skipped    int
+	noGPS      int
+	bytesMoved int64
+	requests   int
+	apiErrors  int
+	cacheHits  int
+	geocodeSec float64
+	lastRun    time.Time
+	lastErr    error
+}
+
+func newServiceMetrics() *serviceMetrics {
+	return &serviceMetrics{started: time.Now()}
+}
+
+// Add the outcome of a run
+func (m *serviceMetrics) observe(summary sorter.Summary, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs++
+	if err != nil {
+		m.runErrors++
+	}
+	m.moved += summary.Moved
+	m.sidecars += summary.Sidecars
+	m.failed += summary.Failed
+	m.duplicates += summary.Duplicates
+	m.skipped += summary.Skipped
+	m.noGPS += summary.NoGPS
+	m.bytesMoved += summary.BytesMoved
+	m.requests += summary.GeocodeRequests
+	m.apiErrors += summary.GeocodeErrors
+	m.cacheHits += summary.CacheHits
+	m.geocodeSec += summary.GeocodeTime.Seconds()
+	m.lastRun, m.lastErr = time.Now(), err
+}
+
+// Write the totals in the Prometheus text exposition format
+func (m *serviceMetrics) serveMetrics(rw http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name, kind, help string, value any) {
+		fmt.Fprintf(rw, "# HELP pic_sorter_%s %s\n# TYPE pic_sorter_%s %s\npic_sorter_%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("runs_total", "counter", "Sort runs finished.", m.runs)
+	metric("run_errors_total", "counter", "Sort runs that failed.", m.runErrors)
+	metric("files_moved_total", "counter", "Files placed in the sorted tree.", m.moved)
+	metric("sidecars_moved_total", "counter", "Sidecar files placed along with them.", m.sidecars)
+	metric("files_failed_total", "counter", "Files that could not be sorted or moved.", m.failed)
+	metric("duplicates_total", "counter", "Images whose content was already sorted.", m.duplicates)
+	metric("files_skipped_total", "counter", "Images left in place because their name was taken.", m.skipped)
+	metric("files_no_gps_total", "counter", "Images left in place because they have no GPS data.", m.noGPS)
+	metric("bytes_moved_total", "counter", "Total size of the placed files.", m.bytesMoved)
+	metric("geocode_requests_total", "counter", "Reverse-geocode requests sent, the cache misses.", m.requests)
+	metric("geocode_errors_total", "counter", "Reverse-geocode requests that failed.", m.apiErrors)
+	metric("geocode_cache_hits_total", "counter", "Lookups answered from the geocode cache.", m.cacheHits)
+	metric("geocode_seconds_total", "counter", "Time spent waiting on reverse-geocode requests.", m.geocodeSec)
+	var last int64
+	if !m.lastRun.IsZero() {
+		last = m.lastRun.Unix()
+	}
+	metric("last_run_timestamp_seconds", "gauge", "When the last sort run finished, 0 before the first.", last)
+	metric("start_timestamp_seconds", "gauge", "When the process started.", m.started.Unix())
+}
+
+// Report the process as healthy unless its last run failed
+func (m *serviceMetrics) serveHealth(rw http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	health := map[string]any{"status": "ok", "runs": m.runs}
+	if !m.lastRun.IsZero() {
+		health["last_run"] = m.lastRun
+	}
+	status := http.StatusOK
+	if m.lastErr != nil {
+		health["status"], health["error"] = "failing", m.lastErr.Error()
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(rw, status, health)
+}
+
+// Return a handler serving /metrics and /healthz
+func (m *serviceMetrics) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.serveMetrics)
+	mux.HandleFunc("/healthz", m.serveHealth)
+	return mux
+}
@@ -0,0 +1,58 @@
+package exifinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Start of the maker notes that iPhones write
+var appleMakerNote = []byte("Apple iOS\x00")
+
+// Tag of the Live Photo content identifier in Apple's maker notes
+const appleContentIDTag = 0x0011
+
+// Metadata key of the content identifier in the video of a Live Photo
+const videoContentIDKey = "com.apple.quicktime.content.identifier"
+
+// Read the content identifier that pairs the still of a Live Photo with
+// its video from Apple's maker notes, or "". The notes are a header, a
+// version and a byte order mark followed by a big-endian IFD whose
+// offsets count from the start of the notes.
+func appleContentID(x *exif.Exif) string {
+	tag, err := x.Get(exif.MakerNote)
+	if err != nil {
+		return ""
+	}
+	note := tag.Val
+	if !bytes.HasPrefix(note, appleMakerNote) || len(note) < 16 {
+		return ""
+	}
+	order := binary.ByteOrder(binary.BigEndian)
+	if string(note[12:14]) == "II" {
+		order = binary.LittleEndian
+	}
+	count := int(order.Uint16(note[14:]))
+	for i := 0; i < count; i++ {
+		entry := 16 + 12*i
+		if entry+12 > len(note) {
+			break
+		}
+		// An ASCII value longer than four bytes is stored at an offset
+		if order.Uint16(note[entry:]) != appleContentIDTag || order.Uint16(note[entry+2:]) != 2 {
+			continue
+		}
+		n := int(order.Uint32(note[entry+4:]))
+		start := entry + 8
+		if n > 4 {
+			start = int(order.Uint32(note[entry+8:]))
+		}
+		if start < 0 || n < 0 || start+n > len(note) {
+			return ""
+		}
+		return strings.TrimSpace(strings.Trim(string(note[start:start+n]), "\x00"))
+	}
+	return ""
+}
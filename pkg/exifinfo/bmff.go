@@ -0,0 +1,97 @@
+package exifinfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Returned for boxes that extend past their parent or the file
+var errTruncated = errors.New("bmff: truncated box")
+
+// A box of an ISO base media file (ISO/IEC 14496-12), the structure shared
+// by HEIF images and MP4/QuickTime videos: its type and payload
+type bmffBox struct {
+	typ  string
+	data []byte
+}
+
+// Split a byte slice into consecutive boxes
+func parseBoxes(b []byte) ([]bmffBox, error) {
+	var boxes []bmffBox
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b[0:4]))
+		typ := string(b[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return nil, errTruncated
+			}
+			size = binary.BigEndian.Uint64(b[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return nil, errTruncated
+		}
+		boxes = append(boxes, bmffBox{typ: typ, data: b[header:size]})
+		b = b[size:]
+	}
+	return boxes, nil
+}
+
+// Read the top-level box of the given type from a file without loading
+// the (large) media data boxes
+func readTopBox(f io.ReaderAt, typ string) ([]byte, error) {
+	var offset int64
+	header := make([]byte, 16)
+	for {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("bmff: no %s box", typ)
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerLen := int64(8)
+		if size == 1 {
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, errTruncated
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if string(header[4:8]) == typ {
+			if size == 0 || size < headerLen || size > 64<<20 {
+				return nil, errTruncated
+			}
+			data := make([]byte, size-headerLen)
+			if _, err := f.ReadAt(data, offset+headerLen); err != nil {
+				return nil, errTruncated
+			}
+			return data, nil
+		}
+		if size < headerLen {
+			return nil, fmt.Errorf("bmff: no %s box", typ)
+		}
+		offset += size
+	}
+}
+
+// Read an unsigned big-endian integer of n bytes (0, 4 or 8)
+func readUint(b []byte, n int) (uint64, []byte, error) {
+	if len(b) < n {
+		return 0, nil, errTruncated
+	}
+	switch n {
+	case 0:
+		return 0, b, nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), b[2:], nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), b[4:], nil
+	case 8:
+		return binary.BigEndian.Uint64(b), b[8:], nil
+	}
+	return 0, nil, fmt.Errorf("bmff: unsupported field size %d", n)
+}
@@ -0,0 +1,65 @@
+package exifinfo
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Reported by Check for files whose metadata or image data is damaged
+var ErrCorrupt = errors.New("corrupt file")
+
+// Formats the standard library can decode in full, so Check can tell a
+// truncated image from a whole one
+var decodableExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// Report whether an image is damaged: its EXIF block cannot be parsed or,
+// for JPEG, PNG and GIF, its image data does not decode to the end.
+// Images without EXIF data are fine; videos are not checked. Errors wrap
+// ErrCorrupt unless the file cannot be read at all.
+func Check(imagePath string) error {
+	ext := strings.ToLower(filepath.Ext(imagePath))
+	if videoExts[ext] {
+		return nil
+	}
+	if _, err := decode(imagePath); err != nil && malformedExif(err) {
+		return fmt.Errorf("%w: EXIF: %v", ErrCorrupt, err)
+	}
+	if !decodableExts[ext] {
+		return nil
+	}
+
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, _, err := image.Decode(file); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return nil
+}
+
+// Report whether an error decoding EXIF data means the data is damaged
+// rather than missing or only partly readable
+func malformedExif(err error) bool {
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, errNoExifItem), errors.Is(err, errNoExifChunk),
+		strings.Contains(err.Error(), "failed to find exif intro marker"):
+		// No EXIF block at all
+		return false
+	case !exif.IsCriticalError(err):
+		// A sub-IFD failed; the rest is usable
+		return false
+	}
+	return true
+}
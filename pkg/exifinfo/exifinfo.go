@@ -0,0 +1,77 @@
+// Package exifinfo extracts capture metadata, such as GPS coordinates,
+// from image files.
+package exifinfo
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Extensions of HEIF containers: the HEIC photos of iPhones and AVIF images
+var heifExts = map[string]bool{".heic": true, ".heif": true, ".hif": true, ".avif": true}
+
+// Decode the EXIF data of an image, locating it inside HEIF/AVIF, PNG
+// and WebP containers and RAW formats the EXIF decoder does not
+// recognize by itself
+func decode(imagePath string) (*exif.Exif, error) {
+	var extract func(string) ([]byte, error)
+	ext := strings.ToLower(filepath.Ext(imagePath))
+	switch {
+	case heifExts[ext]:
+		extract = heifExif
+	case tiffVariantExts[ext]:
+		extract = tiffVariantExif
+	case ext == ".raf":
+		extract = rafExif
+	case ext == ".png":
+		extract = pngExif
+	case ext == ".webp":
+		extract = webpExif
+	}
+	if extract != nil {
+		data, err := extract(imagePath)
+		if err != nil {
+			return nil, err
+		}
+		return exif.Decode(bytes.NewReader(data))
+	}
+
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return exif.Decode(file)
+}
+
+// Extract GPS coordinates from the EXIF data embedded in an image
+func GPS(imagePath string) (float64, float64, error) {
+	x, err := decode(imagePath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	lat, lon, err := x.LatLong()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return lat, lon, nil
+}
+
+// Extract GPS coordinates from an image, falling back to its Google
+// Takeout sidecar when the embedded metadata has none
+func Coordinates(imagePath string) (float64, float64, error) {
+	lat, lon, err := GPS(imagePath)
+	if err == nil {
+		return lat, lon, nil
+	}
+	if lat, lon, takeoutErr := TakeoutGPS(imagePath); takeoutErr == nil {
+		return lat, lon, nil
+	}
+	return 0, 0, err
+}
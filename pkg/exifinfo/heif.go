@@ -0,0 +1,204 @@
+package exifinfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Returned when a HEIF file carries no EXIF metadata
+var errNoExifItem = errors.New("heif: no Exif item")
+
+// Find the ID of the first item of type "Exif" in an iinf box
+func exifItemID(iinf []byte) (uint32, error) {
+	if len(iinf) < 4 {
+		return 0, errTruncated
+	}
+	// The entry count is 16 bits wide in version 0 and 32 bits after
+	countSize := 4
+	if iinf[0] == 0 {
+		countSize = 2
+	}
+	_, rest, err := readUint(iinf[4:], countSize)
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := parseBoxes(rest)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if entry.typ != "infe" || len(entry.data) < 4 {
+			continue
+		}
+		// Only item info entries of version 2 and 3 carry an item type
+		v := entry.data[0]
+		b := entry.data[4:]
+		var id uint64
+		switch v {
+		case 2:
+			id, b, err = readUint(b, 2)
+		case 3:
+			id, b, err = readUint(b, 4)
+		default:
+			continue
+		}
+		if err != nil || len(b) < 6 {
+			continue
+		}
+		if string(b[2:6]) == "Exif" {
+			return uint32(id), nil
+		}
+	}
+	return 0, errNoExifItem
+}
+
+// A byte range of an item
+type extent struct {
+	offset, length uint64
+}
+
+// Find the file extents of an item in an iloc box
+func itemExtents(iloc []byte, itemID uint32) ([]extent, error) {
+	if len(iloc) < 6 {
+		return nil, errTruncated
+	}
+	version := iloc[0]
+	offsetSize := int(iloc[4] >> 4)
+	lengthSize := int(iloc[4] & 0x0f)
+	baseOffsetSize := int(iloc[5] >> 4)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(iloc[5] & 0x0f)
+	}
+	b := iloc[6:]
+
+	var count uint64
+	var err error
+	if version < 2 {
+		count, b, err = readUint(b, 2)
+	} else {
+		count, b, err = readUint(b, 4)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint64(0); i < count; i++ {
+		var id, method, baseOffset, extentCount uint64
+		if version < 2 {
+			id, b, err = readUint(b, 2)
+		} else {
+			id, b, err = readUint(b, 4)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if version == 1 || version == 2 {
+			if method, b, err = readUint(b, 2); err != nil {
+				return nil, err
+			}
+		}
+		if _, b, err = readUint(b, 2); err != nil { // data_reference_index
+			return nil, err
+		}
+		if baseOffset, b, err = readUint(b, baseOffsetSize); err != nil {
+			return nil, err
+		}
+		if extentCount, b, err = readUint(b, 2); err != nil {
+			return nil, err
+		}
+
+		var extents []extent
+		for j := uint64(0); j < extentCount; j++ {
+			var e extent
+			if _, b, err = readUint(b, indexSize); err != nil {
+				return nil, err
+			}
+			if e.offset, b, err = readUint(b, offsetSize); err != nil {
+				return nil, err
+			}
+			if e.length, b, err = readUint(b, lengthSize); err != nil {
+				return nil, err
+			}
+			e.offset += baseOffset
+			extents = append(extents, e)
+		}
+
+		if uint32(id) == itemID {
+			if method&0x0f != 0 {
+				return nil, fmt.Errorf("heif: unsupported construction method %d", method&0x0f)
+			}
+			return extents, nil
+		}
+	}
+	return nil, errNoExifItem
+}
+
+// Extract the TIFF-formatted EXIF block of a HEIF/HEIC file
+func heifExif(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	meta, err := readTopBox(f, "meta")
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) < 4 {
+		return nil, errTruncated
+	}
+	boxes, err := parseBoxes(meta[4:]) // skip version and flags
+	if err != nil {
+		return nil, err
+	}
+
+	var iinf, iloc []byte
+	for _, box := range boxes {
+		switch box.typ {
+		case "iinf":
+			iinf = box.data
+		case "iloc":
+			iloc = box.data
+		}
+	}
+	if iinf == nil || iloc == nil {
+		return nil, errNoExifItem
+	}
+
+	id, err := exifItemID(iinf)
+	if err != nil {
+		return nil, err
+	}
+	extents, err := itemExtents(iloc, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for _, e := range extents {
+		if e.length > 16<<20 {
+			return nil, errTruncated
+		}
+		chunk := make([]byte, e.length)
+		if _, err := f.ReadAt(chunk, int64(e.offset)); err != nil {
+			return nil, errTruncated
+		}
+		data = append(data, chunk...)
+	}
+
+	// The item starts with the offset of the TIFF header, usually
+	// skipping an "Exif\0\0" prefix
+	if len(data) < 4 {
+		return nil, errTruncated
+	}
+	start := 4 + uint64(binary.BigEndian.Uint32(data))
+	if start > uint64(len(data)) {
+		return nil, errTruncated
+	}
+	return data[start:], nil
+}
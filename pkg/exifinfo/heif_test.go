@@ -0,0 +1,49 @@
+package exifinfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Build an ISO BMFF box of the given type around data
+func box(typ string, data ...[]byte) []byte {
+	var payload []byte
+	for _, d := range data {
+		payload = append(payload, d...)
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(b, typ...), payload...)
+}
+
+func TestTruncatedHEIC(t *testing.T) {
+	tests := []struct {
+		name string
+		iinf []byte // payload of the iinf box: version, flags and entry count
+	}{
+		{name: "version 0 without count", iinf: []byte{0, 0, 0, 0, 0}},
+		{name: "version 1 with 6 bytes", iinf: []byte{1, 0, 0, 0, 0, 0}},
+		{name: "version 1 with 7 bytes", iinf: []byte{1, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := exifItemID(test.iinf); !errors.Is(err, errTruncated) {
+				t.Errorf("exifItemID error = %v, want %v", err, errTruncated)
+			}
+
+			path := filepath.Join(t.TempDir(), "img.heic")
+			meta := box("meta", []byte{0, 0, 0, 0}, box("iinf", test.iinf), box("iloc", []byte{0, 0, 0, 0, 0, 0, 0, 0}))
+			if err := os.WriteFile(path, append(box("ftyp", []byte("heic")), meta...), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := Check(path); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("Check error = %v, want %v", err, ErrCorrupt)
+			}
+			if _, err := Read(path); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("Read error = %v, want %v", err, ErrCorrupt)
+			}
+		})
+	}
+}
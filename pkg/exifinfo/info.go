@@ -0,0 +1,100 @@
+package exifinfo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Metadata of an image used for sorting
+type Info struct {
+	HasGPS   bool
+	Lat, Lon float64
+
+	Time      time.Time // capture time, or modification time if not ExactTime
+	ExactTime bool      // Time comes from the EXIF data or a Takeout sidecar
+	GPSTime   time.Time // UTC time of the GPS fix, zero if unknown
+
+	Make  string // camera maker, e.g. "Canon"
+	Model string // camera model, e.g. "Canon EOS R5"
+
+	Screenshot bool // a screen capture rather than a photo, going by its metadata, name or size
+
+	ContentID string // Apple's identifier shared by the still and the video of a Live Photo
+}
+
+// Read all sorting metadata of an image with a single EXIF decode, or of
+// a video from its MP4/QuickTime metadata. GPS and time fall back to a
+// Google Takeout sidecar, and the time then to the file's modification
+// time; missing values are left zero rather than failing. A damaged
+// EXIF block is reported as an error wrapping ErrCorrupt.
+func Read(imagePath string) (Info, error) {
+	var info Info
+
+	stat, err := os.Stat(imagePath)
+	if err != nil {
+		return info, err
+	}
+	info.Time = stat.ModTime()
+
+	if videoExts[strings.ToLower(filepath.Ext(imagePath))] {
+		if v, err := videoInfo(imagePath); err == nil {
+			if v.ExactTime {
+				info.Time, info.ExactTime = v.Time, true
+			}
+			info.HasGPS, info.Lat, info.Lon = v.HasGPS, v.Lat, v.Lon
+			info.Make, info.Model = v.Make, v.Model
+			info.ContentID = v.ContentID
+		}
+	} else if x, err := decode(imagePath); err != nil && malformedExif(err) {
+		return info, fmt.Errorf("%w: EXIF: %v", ErrCorrupt, err)
+	} else if err == nil {
+		if lat, lon, err := x.LatLong(); err == nil {
+			info.HasGPS, info.Lat, info.Lon = true, lat, lon
+		}
+		if t, err := x.DateTime(); err == nil {
+			info.Time, info.ExactTime = t, true
+		}
+		if t, ok := gpsTime(x); ok {
+			info.GPSTime = t
+		}
+		info.Make = tagString(x, exif.Make)
+		info.Model = tagString(x, exif.Model)
+		info.Screenshot = exifScreenshot(x)
+		info.ContentID = appleContentID(x)
+	}
+	// Photos from a camera are never mistaken for screenshots
+	if !info.Screenshot && info.Make == "" && info.Model == "" {
+		info.Screenshot = looksLikeScreenshot(imagePath)
+	}
+
+	if !info.HasGPS || !info.ExactTime {
+		if meta, err := ReadTakeout(imagePath); err == nil {
+			if !info.HasGPS && meta.HasGPS {
+				info.HasGPS, info.Lat, info.Lon = true, meta.Lat, meta.Lon
+			}
+			if !info.ExactTime && !meta.Time.IsZero() {
+				info.Time, info.ExactTime = meta.Time, true
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// Return a string tag with surrounding spaces and NULs removed, or ""
+func tagString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.Trim(value, "\x00"))
+}
@@ -0,0 +1,93 @@
+package exifinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// Returned when a PNG or WebP file carries no EXIF chunk
+var errNoExifChunk = errors.New("no EXIF chunk")
+
+// Signature at the start of every PNG file
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// Extract the TIFF-formatted EXIF block of a PNG file from its eXIf chunk
+func pngExif(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(f, header); err != nil || string(header) != pngSignature {
+		return nil, errors.New("png: not a PNG file")
+	}
+	for {
+		if _, err := io.ReadFull(f, header); err != nil {
+			return nil, errNoExifChunk
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		switch string(header[4:8]) {
+		case "eXIf":
+			if length > 16<<20 {
+				return nil, errTruncated
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(f, data); err != nil {
+				return nil, errTruncated
+			}
+			return trimExifPrefix(data), nil
+		case "IEND":
+			return nil, errNoExifChunk
+		}
+		// Skip the data and its CRC
+		if _, err := f.Seek(length+4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Extract the TIFF-formatted EXIF block of a WebP file from its EXIF
+// chunk
+func webpExif(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[0:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return nil, errors.New("webp: not a WebP file")
+	}
+	for {
+		if _, err := io.ReadFull(f, header[:8]); err != nil {
+			return nil, errNoExifChunk
+		}
+		length := int64(binary.LittleEndian.Uint32(header[4:8]))
+		if string(header[0:4]) == "EXIF" {
+			if length > 16<<20 {
+				return nil, errTruncated
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(f, data); err != nil {
+				return nil, errTruncated
+			}
+			return trimExifPrefix(data), nil
+		}
+		// Chunks are padded to an even length
+		if _, err := f.Seek(length+length%2, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Drop the "Exif\0\0" prefix some writers put before the TIFF header,
+// as in JPEG APP1 segments
+func trimExifPrefix(data []byte) []byte {
+	return bytes.TrimPrefix(data, []byte("Exif\x00\x00"))
+}
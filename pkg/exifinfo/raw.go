@@ -0,0 +1,64 @@
+package exifinfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// RAW formats that are TIFF files with a vendor-specific magic number.
+// CR2, NEF, ARW and DNG are plain TIFF and need no special handling.
+var tiffVariantExts = map[string]bool{".orf": true, ".rw2": true}
+
+// Read a TIFF-based RAW file with its magic number replaced by the
+// standard one, so that the TIFF decoder accepts it
+func tiffVariantExif(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, errors.New("raw: file too short")
+	}
+
+	// Olympus uses "IIRO", "IIRS" or "MMOR" and Panasonic "IIU\0"
+	switch string(data[0:2]) {
+	case "II":
+		binary.LittleEndian.PutUint16(data[2:4], 42)
+	case "MM":
+		binary.BigEndian.PutUint16(data[2:4], 42)
+	default:
+		return nil, errors.New("raw: unknown byte order")
+	}
+	return data, nil
+}
+
+// Extract the JPEG preview of a Fujifilm RAF file, which carries the
+// camera's EXIF data
+func rafExif(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 92)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, errors.New("raf: truncated header")
+	}
+	if string(header[0:16]) != "FUJIFILMCCD-RAW " {
+		return nil, errors.New("raf: not a RAF file")
+	}
+
+	offset := binary.BigEndian.Uint32(header[84:88])
+	length := binary.BigEndian.Uint32(header[88:92])
+	if length > 64<<20 {
+		return nil, errors.New("raf: preview too large")
+	}
+	preview := make([]byte, length)
+	if _, err := f.ReadAt(preview, int64(offset)); err != nil {
+		return nil, errors.New("raf: truncated preview")
+	}
+	return preview, nil
+}
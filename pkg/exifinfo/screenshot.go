@@ -0,0 +1,69 @@
+package exifinfo
+
+import (
+	"image"
+	_ "image/png" // DecodeConfig of PNG screenshots
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Name prefixes that phones and desktops give screenshots
+var screenshotPrefixes = []string{"screenshot", "screen shot", "screen_shot", "scrnli_"}
+
+// Display resolutions that PNG screenshots come in, in either orientation
+var screenSizes = map[[2]int]bool{
+	{1280, 720}: true, {1366, 768}: true, {1440, 900}: true, {1536, 864}: true,
+	{1680, 1050}: true, {1920, 1080}: true, {1920, 1200}: true, {2560, 1440}: true,
+	{2560, 1600}: true, {2880, 1800}: true, {3024, 1964}: true, {3456, 2234}: true,
+	{3840, 2160}: true, {2048, 1536}: true, {2732, 2048}: true,
+	{1334, 750}: true, {1792, 828}: true, {2208, 1242}: true, {2340, 1080}: true,
+	{2400, 1080}: true, {2436, 1125}: true, {2532, 1170}: true, {2556, 1179}: true,
+	{2688, 1242}: true, {2778, 1284}: true, {2796, 1290}: true, {3120, 1440}: true,
+	{3200, 1440}: true,
+}
+
+// Report whether x marks an image as a screen capture: iOS writes
+// "Screenshot" into the user comment, desktop tools name themselves in
+// the software tag
+func exifScreenshot(x *exif.Exif) bool {
+	if strings.Contains(strings.ToLower(tagString(x, exif.Software)), "screenshot") {
+		return true
+	}
+	tag, err := x.Get(exif.UserComment)
+	if err != nil {
+		return false
+	}
+	// The comment starts with an 8-byte character code
+	comment := tag.Val
+	if len(comment) > 8 {
+		comment = comment[8:]
+	}
+	return strings.Contains(strings.ToLower(string(comment)), "screenshot")
+}
+
+// Report whether an image without camera metadata looks like a
+// screenshot from its name or, for PNGs, its size matching a display
+func looksLikeScreenshot(imagePath string) bool {
+	name := strings.ToLower(filepath.Base(imagePath))
+	for _, prefix := range screenshotPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	if strings.ToLower(filepath.Ext(imagePath)) != ".png" {
+		return false
+	}
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return false
+	}
+	return screenSizes[[2]int{config.Width, config.Height}] || screenSizes[[2]int{config.Height, config.Width}]
+}
@@ -0,0 +1,78 @@
+package exifinfo
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Longest undefined value shown as text; longer ones, such as maker
+// notes, are binary blobs
+const maxUndefLen = 32
+
+// An EXIF field of an image with its value as text
+type Tag struct {
+	Name  string
+	Value string
+}
+
+type tagWalker func(name exif.FieldName, tag *tiff.Tag) error
+
+func (w tagWalker) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	return w(name, tag)
+}
+
+// Read the EXIF fields of an image, sorted by name. Binary fields such as
+// maker notes are left out.
+func Tags(imagePath string) ([]Tag, error) {
+	x, err := decode(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []Tag
+	x.Walk(tagWalker(func(name exif.FieldName, tag *tiff.Tag) error {
+		if strings.HasSuffix(string(name), "Pointer") {
+			// Offsets of the sub-IFDs, not data
+			return nil
+		}
+		var value string
+		switch tag.Format() {
+		case tiff.StringVal:
+			value, _ = tag.StringVal()
+			value = strings.TrimSpace(strings.Trim(value, "\x00"))
+		case tiff.UndefVal:
+			if tag.Count > maxUndefLen {
+				return nil
+			}
+			value = tag.String()
+		case tiff.RatVal:
+			value = ratString(tag)
+		default:
+			value = tag.String()
+		}
+		if value != "" {
+			tags = append(tags, Tag{Name: string(name), Value: value})
+		}
+		return nil
+	}))
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+// Format the rationals of a tag as decimals, e.g. "41 0 0" for the
+// degrees, minutes and seconds of a latitude
+func ratString(tag *tiff.Tag) string {
+	values := make([]string, 0, tag.Count)
+	for i := 0; i < int(tag.Count); i++ {
+		r, err := tag.Rat(i)
+		if err != nil {
+			return tag.String()
+		}
+		value := strings.TrimRight(r.FloatString(4), "0")
+		values = append(values, strings.TrimSuffix(value, "."))
+	}
+	return strings.Join(values, " ")
+}
@@ -0,0 +1,125 @@
+package exifinfo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Coordinates block of a Google Takeout metadata sidecar
+type takeoutGeo struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Timestamp block of a Google Takeout metadata sidecar
+type takeoutTime struct {
+	Timestamp string `json:"timestamp"` // Unix seconds, as a string
+}
+
+// The parts of a Google Takeout metadata sidecar we use
+type takeoutSidecar struct {
+	GeoData        takeoutGeo  `json:"geoData"`
+	GeoDataExif    takeoutGeo  `json:"geoDataExif"`
+	PhotoTakenTime takeoutTime `json:"photoTakenTime"`
+}
+
+// Metadata of an image read from its Google Takeout sidecar
+type Takeout struct {
+	HasGPS   bool
+	Lat, Lon float64
+	Time     time.Time // capture time, zero if the sidecar has none
+}
+
+// Longest name Takeout gives a sidecar, without ".json"
+const takeoutNameLimit = 46
+
+// Number Takeout appends to the second and later images of one name:
+// "IMG_1234(1).jpg", whose sidecar is "IMG_1234.jpg(1).json"
+var takeoutCopyNumber = regexp.MustCompile(`^(.*)(\(\d+\))$`)
+
+// Candidate sidecar paths for an image, in the naming schemes Takeout
+// uses. Edited copies ("IMG_1234-edited.jpg") share the original's
+// sidecar, and long names are cut short.
+func takeoutSidecarPaths(imagePath string) []string {
+	dir, name := filepath.Split(imagePath)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if edited := strings.TrimSuffix(stem, "-edited"); edited != stem {
+		stem, name = edited, edited+ext
+	}
+	number := ""
+	if m := takeoutCopyNumber.FindStringSubmatch(stem); m != nil {
+		stem, number = m[1], m[2]
+	}
+
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(base string) {
+		if len(base) > takeoutNameLimit {
+			base = base[:takeoutNameLimit]
+		}
+		if path := dir + base + ".json"; !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	if number == "" {
+		add(name)
+		add(name + ".supplemental-metadata")
+		add(stem)
+	} else {
+		add(stem + ext + number)
+		add(stem + ext + ".supplemental-metadata" + number)
+		add(stem + number)
+	}
+	return paths
+}
+
+// Read the Google Takeout sidecar of an image. Takeout writes 0,0 when
+// it has no location, so zero coordinates are ignored.
+func ReadTakeout(imagePath string) (Takeout, error) {
+	for _, sidecarPath := range takeoutSidecarPaths(imagePath) {
+		data, err := os.ReadFile(sidecarPath)
+		if err != nil {
+			continue
+		}
+
+		var sidecar takeoutSidecar
+		if err := json.Unmarshal(data, &sidecar); err != nil {
+			return Takeout{}, fmt.Errorf("%s: %w", filepath.Base(sidecarPath), err)
+		}
+
+		var meta Takeout
+		for _, geo := range []takeoutGeo{sidecar.GeoData, sidecar.GeoDataExif} {
+			if geo.Latitude != 0 || geo.Longitude != 0 {
+				meta.HasGPS, meta.Lat, meta.Lon = true, geo.Latitude, geo.Longitude
+				break
+			}
+		}
+		if seconds, err := strconv.ParseInt(sidecar.PhotoTakenTime.Timestamp, 10, 64); err == nil && seconds > 0 {
+			meta.Time = time.Unix(seconds, 0)
+		}
+		return meta, nil
+	}
+
+	return Takeout{}, errors.New("no Takeout sidecar found")
+}
+
+// Read GPS coordinates from the Google Takeout sidecar of an image
+func TakeoutGPS(imagePath string) (float64, float64, error) {
+	meta, err := ReadTakeout(imagePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !meta.HasGPS {
+		return 0, 0, errors.New("no location in Takeout sidecar")
+	}
+	return meta.Lat, meta.Lon, nil
+}
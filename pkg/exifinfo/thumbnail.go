@@ -0,0 +1,12 @@
+package exifinfo
+
+// Return the JPEG preview stored in an image's EXIF data, if it has one.
+// Cameras and phones usually embed one of about 160x120 pixels, which
+// saves decoding RAW and HEIC files just to show a preview.
+func Thumbnail(imagePath string) ([]byte, error) {
+	x, err := decode(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	return x.JpegThumbnail()
+}
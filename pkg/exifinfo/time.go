@@ -0,0 +1,57 @@
+package exifinfo
+
+import (
+	"os"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// Read the capture time from an image's EXIF DateTimeOriginal, falling
+// back to DateTime. EXIF has no timezone, so the time is in time.Local.
+func CaptureTime(imagePath string) (time.Time, error) {
+	x, err := decode(imagePath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return x.DateTime()
+}
+
+// Return when an image was taken: its EXIF capture time if it has one,
+// or that of its Google Takeout sidecar, otherwise the file's
+// modification time. exact reports which it was.
+func DateTaken(imagePath string) (t time.Time, exact bool, err error) {
+	if t, err := CaptureTime(imagePath); err == nil {
+		return t, true, nil
+	}
+	if meta, err := ReadTakeout(imagePath); err == nil && !meta.Time.IsZero() {
+		return meta.Time, true, nil
+	}
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return info.ModTime(), false, nil
+}
+
+// Read the UTC time of the GPS fix from GPSDateStamp and GPSTimeStamp
+func gpsTime(x *exif.Exif) (time.Time, bool) {
+	date := tagString(x, exif.GPSDateStamp)
+	stamp, err := x.Get(exif.GPSTimeStamp)
+	if date == "" || err != nil || stamp.Count < 3 {
+		return time.Time{}, false
+	}
+	day, err := time.Parse("2006:01:02", date)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var seconds float64
+	for i, unit := range []float64{3600, 60, 1} {
+		num, den, err := stamp.Rat2(i)
+		if err != nil || den == 0 {
+			return time.Time{}, false
+		}
+		seconds += float64(num) / float64(den) * unit
+	}
+	return day.Add(time.Duration(seconds * float64(time.Second))), true
+}
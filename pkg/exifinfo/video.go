@@ -0,0 +1,192 @@
+package exifinfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extensions of MP4 and QuickTime videos
+var videoExts = map[string]bool{".mp4": true, ".mov": true, ".m4v": true, ".3gp": true}
+
+// Leading latitude and longitude of an ISO 6709 location such as
+// "+48.8584+002.2945+035.000/"
+var iso6709 = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)`)
+
+// Start of the QuickTime/MP4 epoch
+var epoch1904 = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Read the GPS position, recording time and camera of a video from its
+// moov box: Apple's mdta metadata keys, the udta ©xyz atom used by
+// Android, and the movie header's creation time
+func videoInfo(path string) (Info, error) {
+	var info Info
+
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+
+	moov, err := readTopBox(f, "moov")
+	if err != nil {
+		return info, err
+	}
+	boxes, err := parseBoxes(moov)
+	if err != nil {
+		return info, err
+	}
+
+	for _, box := range boxes {
+		switch box.typ {
+		case "mvhd":
+			if t, ok := movieCreationTime(box.data); ok && !info.ExactTime {
+				info.Time, info.ExactTime = t, true
+			}
+		case "udta":
+			children, _ := parseBoxes(box.data)
+			for _, child := range children {
+				switch child.typ {
+				case "\xa9xyz":
+					if lat, lon, ok := parseISO6709(userDataString(child.data)); ok && !info.HasGPS {
+						info.HasGPS, info.Lat, info.Lon = true, lat, lon
+					}
+				case "\xa9mak":
+					if info.Make == "" {
+						info.Make = userDataString(child.data)
+					}
+				case "\xa9mod":
+					if info.Model == "" {
+						info.Model = userDataString(child.data)
+					}
+				}
+			}
+		case "meta":
+			// Apple's metadata takes precedence: its creation date
+			// carries the local timezone
+			items := metadataItems(box.data)
+			if lat, lon, ok := parseISO6709(items["com.apple.quicktime.location.ISO6709"]); ok {
+				info.HasGPS, info.Lat, info.Lon = true, lat, lon
+			}
+			if t, err := time.Parse("2006-01-02T15:04:05-0700", items["com.apple.quicktime.creationdate"]); err == nil {
+				info.Time, info.ExactTime = t, true
+			}
+			if v := items["com.apple.quicktime.make"]; v != "" {
+				info.Make = v
+			}
+			if v := items["com.apple.quicktime.model"]; v != "" {
+				info.Model = v
+			}
+			info.ContentID = items[videoContentIDKey]
+		}
+	}
+
+	if !info.HasGPS && !info.ExactTime && info.Make == "" && info.Model == "" {
+		return info, errors.New("video: no metadata")
+	}
+	return info, nil
+}
+
+// Read the creation time of a movie header box, converted to local time
+// like EXIF times. Zero means the time is unknown.
+func movieCreationTime(mvhd []byte) (time.Time, bool) {
+	var seconds uint64
+	switch {
+	case len(mvhd) >= 8 && mvhd[0] == 0:
+		seconds = uint64(binary.BigEndian.Uint32(mvhd[4:8]))
+	case len(mvhd) >= 12 && mvhd[0] == 1:
+		seconds = binary.BigEndian.Uint64(mvhd[4:12])
+	default:
+		return time.Time{}, false
+	}
+	if seconds == 0 {
+		return time.Time{}, false
+	}
+	return epoch1904.Add(time.Duration(seconds) * time.Second).Local(), true
+}
+
+// Read the text of a QuickTime user data atom: a 16-bit length, a
+// 16-bit language code, then the string
+func userDataString(b []byte) string {
+	if len(b) < 4 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(b[0:2]))
+	b = b[4:]
+	if n > len(b) {
+		n = len(b)
+	}
+	return strings.TrimSpace(strings.Trim(string(b[:n]), "\x00"))
+}
+
+// Collect the string values of a QuickTime meta box by key name
+func metadataItems(meta []byte) map[string]string {
+	items := make(map[string]string)
+
+	// In MP4 files meta is a full box; in QuickTime files it is not
+	if len(meta) >= 8 && string(meta[4:8]) != "hdlr" {
+		meta = meta[4:]
+	}
+	boxes, err := parseBoxes(meta)
+	if err != nil {
+		return items
+	}
+
+	var keys []string
+	var ilst []byte
+	for _, box := range boxes {
+		switch box.typ {
+		case "keys":
+			// Full box header and entry count, then size, namespace and name
+			if len(box.data) < 8 {
+				continue
+			}
+			b := box.data[8:]
+			for len(b) >= 8 {
+				size := int(binary.BigEndian.Uint32(b[0:4]))
+				if size < 8 || size > len(b) {
+					break
+				}
+				keys = append(keys, string(b[8:size]))
+				b = b[size:]
+			}
+		case "ilst":
+			ilst = box.data
+		}
+	}
+
+	// Items are typed by the 1-based index of their key and hold a data box
+	entries, _ := parseBoxes(ilst)
+	for _, entry := range entries {
+		index := int(binary.BigEndian.Uint32([]byte(entry.typ)))
+		if index < 1 || index > len(keys) {
+			continue
+		}
+		values, _ := parseBoxes(entry.data)
+		for _, value := range values {
+			// Type indicator 1 is UTF-8 text; skip it and the locale
+			if value.typ == "data" && len(value.data) >= 8 && binary.BigEndian.Uint32(value.data[0:4]) == 1 {
+				items[keys[index-1]] = strings.TrimSpace(string(value.data[8:]))
+			}
+		}
+	}
+	return items
+}
+
+// Parse the latitude and longitude of an ISO 6709 location string
+func parseISO6709(s string) (float64, float64, bool) {
+	m := iso6709.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, false
+	}
+	lat, err1 := strconv.ParseFloat(m[1], 64)
+	lon, err2 := strconv.ParseFloat(m[2], 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
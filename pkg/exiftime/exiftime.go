@@ -0,0 +1,148 @@
+// Package exiftime corrects the capture times stored in the EXIF data of
+// JPEG photos, for cameras whose clock was set wrong.
+package exiftime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"time"
+)
+
+// Layout of EXIF date and time values
+const layout = "2006:01:02 15:04:05"
+
+// Tags holding a date and time: DateTime in IFD0, DateTimeOriginal and
+// DateTimeDigitized in the Exif IFD
+const (
+	tagDateTime          = 0x0132
+	tagExifIFD           = 0x8769
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+)
+
+// Add d to the EXIF date and time values of the JPEG at path. Values have
+// a fixed length, so they are rewritten in place and nothing else in the
+// file changes; its modification time is kept. Returns false if the file
+// has no EXIF time to shift.
+func Shift(path string, d time.Duration) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	offsets, err := timeOffsets(data)
+	if err != nil || len(offsets) == 0 {
+		return false, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return false, err
+	}
+	shifted := false
+	for _, offset := range offsets {
+		t, err := time.Parse(layout, string(data[offset:offset+len(layout)]))
+		if err != nil {
+			continue // unset, e.g. "    :  :     :  :  "
+		}
+		if _, err := file.WriteAt([]byte(t.Add(d).Format(layout)), int64(offset)); err != nil {
+			file.Close()
+			return shifted, err
+		}
+		shifted = true
+	}
+	if err := file.Close(); err != nil {
+		return shifted, err
+	}
+	return shifted, os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+// Find the file offsets of the date and time values in the EXIF segment
+// of a JPEG
+func timeOffsets(data []byte) ([]int, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("not a JPEG file")
+	}
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xff; {
+		marker := data[pos+1]
+		if marker == 0xff {
+			pos++ // fill byte
+			continue
+		}
+		if marker == 0xda { // start of scan: no EXIF segment
+			break
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) {
+			return nil, errors.New("corrupt JPEG header")
+		}
+		body := data[pos+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(body, []byte("Exif\x00\x00")) {
+			return tiffTimeOffsets(data, pos+4+6, end), nil
+		}
+		pos = end
+	}
+	return nil, nil
+}
+
+// Walk IFD0 and the Exif IFD of the TIFF structure starting at tiff and
+// ending at end for their date and time values
+func tiffTimeOffsets(data []byte, tiff, end int) []int {
+	if tiff+8 > end {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(data[tiff : tiff+2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	var offsets []int
+	// Call fn with the tag, type, count and value field of every entry
+	walk := func(ifd int, fn func(tag, typ uint16, count uint32, value int)) {
+		if ifd < tiff || ifd+2 > end {
+			return
+		}
+		for i := 0; i < int(order.Uint16(data[ifd:])); i++ {
+			entry := ifd + 2 + 12*i
+			if entry+12 > end {
+				return
+			}
+			fn(order.Uint16(data[entry:]), order.Uint16(data[entry+2:]), order.Uint32(data[entry+4:]), entry+8)
+		}
+	}
+	// An ASCII value of 20 bytes sits at an offset from the TIFF header
+	timeValue := func(typ uint16, count uint32, value int) {
+		if typ != 2 || count < uint32(len(layout)) {
+			return
+		}
+		if offset := tiff + int(order.Uint32(data[value:])); offset+len(layout) <= end {
+			offsets = append(offsets, offset)
+		}
+	}
+
+	exifIFD := -1
+	walk(tiff+int(order.Uint32(data[tiff+4:])), func(tag, typ uint16, count uint32, value int) {
+		switch tag {
+		case tagDateTime:
+			timeValue(typ, count, value)
+		case tagExifIFD:
+			exifIFD = tiff + int(order.Uint32(data[value:]))
+		}
+	})
+	walk(exifIFD, func(tag, typ uint16, count uint32, value int) {
+		if tag == tagDateTimeOriginal || tag == tagDateTimeDigitized {
+			timeValue(typ, count, value)
+		}
+	})
+	return offsets
+}
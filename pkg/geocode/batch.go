@@ -0,0 +1,41 @@
+package geocode
+
+import "sync"
+
+// Geocoder wrapper that looks up one position per geohash cell, so that
+// photos taken close together share a single request
+type batched struct {
+	next      Geocoder
+	precision int
+
+	mu    sync.Mutex
+	cells map[string]*sync.Mutex // held while a cell is being looked up
+}
+
+// Wrap next so that every position is replaced by the center of its
+// geohash cell of the given length, e.g. 5 for cells of about 5 km.
+// Lookups of one cell wait for each other, so with a Cache as next only
+// the first of them reaches the provider. A precision of 0 or less
+// returns next unchanged.
+func Batch(next Geocoder, precision int) Geocoder {
+	if precision <= 0 {
+		return next
+	}
+	return &batched{next: next, precision: precision, cells: make(map[string]*sync.Mutex)}
+}
+
+func (b *batched) ReverseGeocode(lat, lon float64) (Location, error) {
+	cell := Geohash(lat, lon, b.precision)
+
+	b.mu.Lock()
+	lock, found := b.cells[cell]
+	if !found {
+		lock = &sync.Mutex{}
+		b.cells[cell] = lock
+	}
+	b.mu.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+	return b.next.ReverseGeocode(GeohashCenter(cell))
+}
@@ -0,0 +1,298 @@
+package geocode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Request and cache counters of a Cache
+type Stats struct {
+	Requests int           // lookups passed on to the wrapped geocoder
+	Errors   int           // those of them that failed
+	Elapsed  time.Duration // time spent waiting on those lookups
+	Hits     int           // lookups answered from the cache
+}
+
+// Settings of a Cache
+type CacheOptions struct {
+	Path      string        // file the entries are persisted to; empty keeps them in memory
+	TTL       time.Duration // entries older than this are looked up again; 0 keeps them forever
+	Precision int           // geohash length of the cache key; 0 keys by exact coordinates
+}
+
+// Default geohash length of persistent cache keys, cells of about 150 m
+const DefaultCachePrecision = 7
+
+// A cached lookup result
+type cacheEntry struct {
+	Location Location  `json:"location"`
+	Fetched  time.Time `json:"fetched"`
+}
+
+// On-disk layout of a persisted cache
+type cacheFile struct {
+	Version int                   `json:"version"`
+	Entries map[string]cacheEntry `json:"entries"`
+}
+
+// Version of the cache file layout. Files without one were written when
+// providers still filled missing fields with DefaultPlaceholder.
+const cacheVersion = 1
+
+// Geocoder wrapper that remembers results, optionally on disk, and
+// records request timings. It is safe for concurrent use.
+type Cache struct {
+	next Geocoder
+	opts CacheOptions
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	dirty   bool
+	stats   Stats
+	limited bool // misses beyond budget fail with ErrQuota
+	budget  int
+	slots   chan struct{} // bounds the lookups of next in flight, if not nil
+}
+
+// Returned for lookups beyond the limit set with LimitRequests
+var ErrQuota = errors.New("geocode request limit reached")
+
+// Allow at most n more requests to the wrapped geocoder; lookups that
+// would need another fail with ErrQuota. Cache hits do not count. An n
+// of 0 or less lifts the limit.
+func (c *Cache) LimitRequests(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limited, c.budget = n > 0, n
+}
+
+// Allow at most n lookups of the wrapped geocoder in flight at once, so
+// that only misses wait for one another while hits are answered right
+// away. An n below 1 is treated as 1.
+func (c *Cache) LimitConcurrency(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slots = make(chan struct{}, max(n, 1))
+}
+
+// Wrap next in a cache loaded from opts.Path, if set and present
+func NewCache(next Geocoder, opts CacheOptions) (*Cache, error) {
+	c := &Cache{next: next, opts: opts, entries: make(map[string]cacheEntry)}
+	if opts.Path == "" {
+		return c, nil
+	}
+
+	entries, err := readCacheFile(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	c.entries = entries
+	return c, nil
+}
+
+// Wrap next in a cache keyed by exact coordinates that lives only in memory
+func NewMemoryCache(next Geocoder) *Cache {
+	c, _ := NewCache(next, CacheOptions{})
+	return c
+}
+
+// Default location of the persistent cache file of a provider. Each
+// provider gets its own file so their answers never mix.
+func DefaultCachePath(provider string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "pic-sorter", "geocode-"+provider+".json")
+}
+
+func (c *Cache) key(lat, lon float64) string {
+	if c.opts.Precision > 0 {
+		return Geohash(lat, lon, c.opts.Precision)
+	}
+	return fmt.Sprintf("%f,%f", lat, lon)
+}
+
+func (c *Cache) ReverseGeocode(lat, lon float64) (Location, error) {
+	key := c.key(lat, lon)
+
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	if found && (c.opts.TTL == 0 || time.Since(entry.Fetched) < c.opts.TTL) {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return entry.Location, nil
+	}
+	if c.limited {
+		if c.budget == 0 {
+			c.mu.Unlock()
+			return nil, ErrQuota
+		}
+		c.budget--
+	}
+	slots := c.slots
+	c.mu.Unlock()
+
+	if slots != nil {
+		slots <- struct{}{}
+		defer func() { <-slots }()
+	}
+	start := time.Now()
+	location, err := c.next.ReverseGeocode(lat, lon)
+	elapsed := time.Since(start)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Requests++
+	c.stats.Elapsed += elapsed
+	if err != nil {
+		c.stats.Errors++
+		return location, err
+	}
+	c.entries[key] = cacheEntry{Location: location, Fetched: time.Now()}
+	c.dirty = true
+	return location, nil
+}
+
+// Return the counters collected so far
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Write new entries to the cache file, if there is one. Entries other
+// processes saved in the meantime are merged in rather than overwritten,
+// the newer answer winning, so runs on several machines can share a file.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.opts.Path == "" || !c.dirty {
+		return nil
+	}
+
+	err := updateCacheFile(c.opts.Path, func(entries map[string]cacheEntry) (bool, error) {
+		mergeEntries(entries, c.entries)
+		mergeEntries(c.entries, entries)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Copy the entries of from into to where to has none or an older one;
+// return how many were copied
+func mergeEntries(to, from map[string]cacheEntry) int {
+	n := 0
+	for key, entry := range from {
+		if old, found := to[key]; found && !entry.Fetched.After(old.Fetched) {
+			continue
+		}
+		to[key] = entry
+		n++
+	}
+	return n
+}
+
+// Read the entries of a cache file; a missing file has none
+func readCacheFile(path string) (map[string]cacheEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(map[string]cacheEntry), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file cacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("geocode cache %s: %w", path, err)
+	}
+	if file.Entries == nil {
+		file.Entries = make(map[string]cacheEntry)
+	}
+	if file.Version < cacheVersion {
+		for _, entry := range file.Entries {
+			for field, value := range entry.Location {
+				if value == DefaultPlaceholder {
+					delete(entry.Location, field)
+				}
+			}
+		}
+	}
+	return file.Entries, nil
+}
+
+// Read a cache file, let update change its entries and write them back
+// if it reports a change, all while holding the lock of the file
+func updateCacheFile(path string, update func(map[string]cacheEntry) (bool, error)) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readCacheFile(path)
+	if err != nil {
+		return err
+	}
+	changed, err := update(entries)
+	if err != nil || !changed {
+		return err
+	}
+	data, err := json.Marshal(cacheFile{Version: cacheVersion, Entries: entries})
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn cache
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// How long to wait for the lock of a cache file, and the age at which a
+// lock left behind by a crashed process is broken
+const (
+	lockWait  = 30 * time.Second
+	lockStale = 2 * time.Minute
+)
+
+// Take a lock by creating path, which works across processes and on
+// network file systems, and return the function that releases it
+func lockFile(path string) (func(), error) {
+	deadline := time.Now().Add(lockWait)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("geocode cache is locked by %s", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,28 @@
+package geocode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheDropsOldPlaceholders(t *testing.T) {
+	// Written before missing fields were left empty
+	path := filepath.Join(t.TempDir(), "geocode.json")
+	old := `{"entries": {"48.850000,2.350000": {"location": {"country": "France", "state": "Unknown"}, "fetched": "2024-01-01T00:00:00Z"}}}`
+	if err := os.WriteFile(path, []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewCache(&stubGeocoder{}, CacheOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, err := cache.ReverseGeocode(48.85, 2.35)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location["country"] != "France" || location["state"] != "" {
+		t.Errorf("cached location = %v, want France without a state", location)
+	}
+}
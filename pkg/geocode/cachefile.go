@@ -0,0 +1,88 @@
+package geocode
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// One entry of an exported cache, a line of JSON
+type cacheRecord struct {
+	Key      string    `json:"key"`
+	Location Location  `json:"location"`
+	Fetched  time.Time `json:"fetched"`
+}
+
+// Write the entries of the cache file at path to w as JSON lines, sorted
+// by key, and return how many were written
+func ExportCache(path string, w io.Writer) (int, error) {
+	entries, err := readCacheFile(path)
+	if err != nil {
+		return 0, err
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	enc := json.NewEncoder(w)
+	for _, key := range keys {
+		entry := entries[key]
+		if err := enc.Encode(cacheRecord{Key: key, Location: entry.Location, Fetched: entry.Fetched}); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// Merge the entries ExportCache wrote to r into the cache file at path,
+// keeping the newer answer where both have one, and return how many were
+// added or updated
+func ImportCache(path string, r io.Reader) (int, error) {
+	imported := make(map[string]cacheEntry)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record cacheRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		if record.Key == "" || record.Location == nil {
+			return 0, fmt.Errorf("line %d: not a cache entry", line)
+		}
+		mergeEntries(imported, map[string]cacheEntry{record.Key: {Location: record.Location, Fetched: record.Fetched}})
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	err := updateCacheFile(path, func(entries map[string]cacheEntry) (bool, error) {
+		n = mergeEntries(entries, imported)
+		return n > 0, nil
+	})
+	return n, err
+}
+
+// Remove the entries of the cache file at path fetched more than maxAge
+// ago and return how many were removed
+func PruneCache(path string, maxAge time.Duration) (int, error) {
+	n := 0
+	err := updateCacheFile(path, func(entries map[string]cacheEntry) (bool, error) {
+		for key, entry := range entries {
+			if time.Since(entry.Fetched) > maxAge {
+				delete(entries, key)
+				n++
+			}
+		}
+		return n > 0, nil
+	})
+	return n, err
+}
@@ -0,0 +1,109 @@
+package geocode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default time a provider that failed is passed over in a Failover
+const DefaultFailoverCooldown = time.Minute
+
+// Geocoder trying a chain of providers in order until one answers. A
+// provider that fails is passed over for Cooldown while the others
+// answer, and one that bans the client for good. Finding no place,
+// ErrNoResult, is an answer and not a failure. ErrBanned is only
+// returned once every provider has banned the client.
+type Failover struct {
+	Cooldown time.Duration // 0 means DefaultFailoverCooldown
+
+	// Called when a provider fails and the next one is tried; may be nil
+	OnFailover func(provider string, err error)
+
+	mu        sync.Mutex
+	providers []*failoverProvider
+}
+
+type failoverProvider struct {
+	name     string
+	geocoder Geocoder
+	until    time.Time // passed over until then after failing
+	banned   bool
+}
+
+// Create an empty failover chain; add its providers with Add
+func NewFailover() *Failover {
+	return &Failover{Cooldown: DefaultFailoverCooldown}
+}
+
+// Append a provider to the chain
+func (f *Failover) Add(name string, geocoder Geocoder) {
+	f.providers = append(f.providers, &failoverProvider{name: name, geocoder: geocoder})
+}
+
+// Return the providers to try: those not cooling down in chain order,
+// then those cooling down, leaving out the banned ones
+func (f *Failover) order() []*failoverProvider {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	var ready, cooling []*failoverProvider
+	for _, p := range f.providers {
+		switch {
+		case p.banned:
+		case now.Before(p.until):
+			cooling = append(cooling, p)
+		default:
+			ready = append(ready, p)
+		}
+	}
+	return append(ready, cooling...)
+}
+
+// Record the outcome of a lookup by p
+func (f *Failover) result(p *failoverProvider, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case err == nil:
+		p.until = time.Time{}
+	case errors.Is(err, ErrBanned):
+		p.banned = true
+	case errors.Is(err, ErrNoResult):
+		// A valid answer; the provider is working
+	default:
+		cooldown := f.Cooldown
+		if cooldown <= 0 {
+			cooldown = DefaultFailoverCooldown
+		}
+		p.until = time.Now().Add(cooldown)
+	}
+}
+
+// Look up a position with the first provider that answers, trying the
+// ones cooling down last
+func (f *Failover) ReverseGeocode(lat, lon float64) (Location, error) {
+	providers := f.order()
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("%w: every provider has blocked this client", ErrBanned)
+	}
+
+	var failures []string
+	for i, p := range providers {
+		location, err := p.geocoder.ReverseGeocode(lat, lon)
+		f.result(p, err)
+		if err == nil {
+			return location, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", p.name, err))
+		if i < len(providers)-1 && f.OnFailover != nil {
+			f.OnFailover(p.name, err)
+		}
+	}
+	if len(f.order()) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrBanned, strings.Join(failures, "; "))
+	}
+	return nil, fmt.Errorf("every provider failed: %s", strings.Join(failures, "; "))
+}
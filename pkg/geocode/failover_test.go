@@ -0,0 +1,51 @@
+package geocode
+
+import (
+	"errors"
+	"testing"
+)
+
+// Geocoder answering every lookup with err, or a location if err is nil
+type stubGeocoder struct {
+	err   error
+	calls int
+}
+
+func (g *stubGeocoder) ReverseGeocode(lat, lon float64) (Location, error) {
+	g.calls++
+	if g.err != nil {
+		return nil, g.err
+	}
+	return Location{"country": "France"}, nil
+}
+
+func TestFailoverCooldown(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error // of the primary provider
+		wantPrimary  int   // lookups by the primary over two positions
+		wantFallback int
+	}{
+		{name: "no place is an answer", err: ErrNoResult, wantPrimary: 2, wantFallback: 2},
+		{name: "failure cools down", err: errors.New("API error: 502"), wantPrimary: 1, wantFallback: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			primary, fallback := &stubGeocoder{err: test.err}, &stubGeocoder{}
+			f := NewFailover()
+			f.Add("primary", primary)
+			f.Add("fallback", fallback)
+
+			for i := 0; i < 2; i++ {
+				if _, err := f.ReverseGeocode(0, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// A provider cooling down is tried after the others, which answer
+			if primary.calls != test.wantPrimary || fallback.calls != test.wantFallback {
+				t.Errorf("primary asked %d times, fallback %d; want %d and %d",
+					primary.calls, fallback.calls, test.wantPrimary, test.wantFallback)
+			}
+		})
+	}
+}
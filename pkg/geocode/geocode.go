@@ -0,0 +1,53 @@
+// Package geocode resolves GPS coordinates to administrative place names.
+package geocode
+
+import "errors"
+
+// Place names keyed by address field, e.g. "country" or "county"
+type Location map[string]string
+
+// Address fields every Geocoder knows of, outermost first. Fields missing
+// from an answer are left empty rather than filled with a placeholder, so
+// cached answers hold only what the provider said.
+var Fields = []string{"country", "state", "state_district", "county"}
+
+// Location fields that can be chosen as folder levels, outermost first.
+// Fields other than Fields are only reported by some providers.
+var LevelFields = []string{"country", "state", "state_district", "county", "city", "suburb", "neighbourhood"}
+
+// Default detail level of Nominatim lookups: enough for cities
+const DefaultZoom = 10
+
+// Return the Nominatim zoom needed to resolve every one of fields. It
+// never goes below DefaultZoom so cached answers stay complete.
+func ZoomFor(fields []string) int {
+	zoom := DefaultZoom
+	for _, field := range fields {
+		switch field {
+		case "suburb":
+			zoom = max(zoom, 14)
+		case "neighbourhood":
+			zoom = max(zoom, 16)
+		}
+	}
+	return zoom
+}
+
+// Default language of place names, as a BCP 47 tag
+const DefaultLanguage = "en"
+
+// Language asking for names in the local language of each place, the
+// endonyms, where the provider supports it
+const LocalLanguage = "local"
+
+// Default folder name for address fields missing from a response
+const DefaultPlaceholder = "Unknown"
+
+// Resolves coordinates to a Location with the Fields the provider knows
+type Geocoder interface {
+	ReverseGeocode(lat, lon float64) (Location, error)
+}
+
+// Returned when a provider answers but knows no place at the
+// coordinates, e.g. out at sea
+var ErrNoResult = errors.New("no place found")
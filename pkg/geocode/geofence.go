@@ -0,0 +1,110 @@
+package geocode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Location field holding the name of the geofence a photo was taken in
+const PlaceField = "place"
+
+// A named region: a circle around a point or a polygon. The name may
+// hold slashes for nested folders, e.g. "Family/Grandma's".
+type Fence struct {
+	Name string
+
+	Lat, Lon float64 // centre of a circle
+	RadiusKm float64 // radius of a circle; 0 for a polygon
+
+	Polygon [][2]float64 // latitude and longitude of the corners, in order
+}
+
+// Parse a fence such as "Home=48.8566,2.3522,200m" (a circle with a
+// radius in m or km, metres if no unit is given) or
+// "Office=48.87,2.33;48.87,2.34;48.86,2.34" (a polygon of at least three
+// corners)
+func ParseFence(spec string) (Fence, error) {
+	name, shape, found := strings.Cut(spec, "=")
+	fence := Fence{Name: strings.Trim(strings.TrimSpace(name), "/")}
+	if !found || fence.Name == "" {
+		return fence, fmt.Errorf("geofence %q: expected name=shape", spec)
+	}
+
+	if strings.Contains(shape, ";") {
+		for _, corner := range strings.Split(shape, ";") {
+			values, err := parseFloats(corner)
+			if err != nil || len(values) != 2 {
+				return fence, fmt.Errorf("geofence %q: invalid corner %q, want lat,lon", spec, strings.TrimSpace(corner))
+			}
+			fence.Polygon = append(fence.Polygon, [2]float64{values[0], values[1]})
+		}
+		if len(fence.Polygon) < 3 {
+			return fence, fmt.Errorf("geofence %q: a polygon needs at least three corners", spec)
+		}
+		return fence, nil
+	}
+
+	parts := strings.Split(shape, ",")
+	if len(parts) != 3 {
+		return fence, fmt.Errorf("geofence %q: want lat,lon,radius or lat,lon;lat,lon;lat,lon", spec)
+	}
+	center, err := parseFloats(strings.Join(parts[:2], ","))
+	if err != nil {
+		return fence, fmt.Errorf("geofence %q: invalid centre", spec)
+	}
+	fence.Lat, fence.Lon = center[0], center[1]
+
+	radius := strings.ToLower(strings.TrimSpace(parts[2]))
+	scale := 0.001
+	if text, ok := strings.CutSuffix(radius, "km"); ok {
+		radius, scale = text, 1
+	} else {
+		radius = strings.TrimSuffix(radius, "m")
+	}
+	r, err := strconv.ParseFloat(strings.TrimSpace(radius), 64)
+	if err != nil || r <= 0 {
+		return fence, fmt.Errorf("geofence %q: invalid radius %q", spec, strings.TrimSpace(parts[2]))
+	}
+	fence.RadiusKm = r * scale
+	return fence, nil
+}
+
+// Parse comma-separated coordinates
+func parseFloats(text string) ([]float64, error) {
+	var values []float64
+	for _, part := range strings.Split(text, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Report whether the point lies inside the fence
+func (f Fence) Contains(lat, lon float64) bool {
+	if len(f.Polygon) == 0 {
+		return DistanceKm(f.Lat, f.Lon, lat, lon) <= f.RadiusKm
+	}
+	// Count the edges a ray from the point towards the east crosses
+	inside := false
+	for i, j := 0, len(f.Polygon)-1; i < len(f.Polygon); j, i = i, i+1 {
+		a, b := f.Polygon[i], f.Polygon[j]
+		if (a[0] > lat) != (b[0] > lat) && lon < (b[1]-a[1])*(lat-a[0])/(b[0]-a[0])+a[1] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Return the first of fences containing the point
+func FindFence(fences []Fence, lat, lon float64) (Fence, bool) {
+	for _, fence := range fences {
+		if fence.Contains(lat, lon) {
+			return fence, true
+		}
+	}
+	return Fence{}, false
+}
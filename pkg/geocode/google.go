@@ -0,0 +1,79 @@
+package geocode
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Endpoint of the Google Maps geocoding API
+const DefaultGoogleURL = "https://maps.googleapis.com/maps/api/geocode/json"
+
+// Geocoder backed by the Google Maps geocoding API; needs an API key
+type Google struct {
+	BaseURL  string       // service endpoint
+	Client   *http.Client // HTTP client used for requests
+	Key      string       // Google Maps API key
+	Language string       // language of place names; "" means DefaultLanguage, LocalLanguage the local names
+}
+
+// Create a Google geocoder using the given API key
+func NewGoogle(key string) *Google {
+	return &Google{BaseURL: DefaultGoogleURL, Client: NewHTTPClient(DefaultUserAgent, DefaultRetries), Key: key}
+}
+
+func (g *Google) ReverseGeocode(lat, lon float64) (Location, error) {
+	query := url.Values{}
+	query.Set("latlng", fmt.Sprintf("%f,%f", lat, lon))
+	query.Set("key", g.Key)
+	if lang := language(g.Language); lang != "" {
+		query.Set("language", lang)
+	}
+
+	var data struct {
+		Status       string `json:"status"`
+		ErrorMessage string `json:"error_message"`
+		Results      []struct {
+			AddressComponents []struct {
+				LongName  string   `json:"long_name"`
+				ShortName string   `json:"short_name"`
+				Types     []string `json:"types"`
+			} `json:"address_components"`
+		} `json:"results"`
+	}
+	if err := getJSON(g.Client, g.BaseURL+"?"+query.Encode(), g.Language, &data); err != nil {
+		return nil, err
+	}
+	if data.Status == "ZERO_RESULTS" {
+		return nil, ErrNoResult
+	}
+	if data.Status != "OK" {
+		return nil, fmt.Errorf("API error: %s %s", data.Status, data.ErrorMessage)
+	}
+
+	// The first result is the most specific; take each component type
+	// from the first result that has it
+	names := make(map[string]string)
+	countryCode := ""
+	for _, result := range data.Results {
+		for _, component := range result.AddressComponents {
+			for _, componentType := range component.Types {
+				if _, found := names[componentType]; !found {
+					names[componentType] = component.LongName
+					if componentType == "country" {
+						countryCode = strings.ToLower(component.ShortName)
+					}
+				}
+			}
+		}
+	}
+
+	return Location{
+		"country":      names["country"],
+		"state":        names["administrative_area_level_1"],
+		"county":       names["administrative_area_level_2"],
+		"city":         names["locality"],
+		"country_code": countryCode,
+	}, nil
+}
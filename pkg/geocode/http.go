@@ -0,0 +1,52 @@
+package geocode
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Fetch url asking for place names in lang and decode its JSON body
+// into v. Non-200 responses are returned as errors carrying the status
+// code.
+func getJSON(client *http.Client, url, lang string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if lang := language(lang); lang != "" {
+		req.Header.Set("Accept-Language", lang)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API error: %d", resp.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// Return the language tag to ask a provider for, "" for LocalLanguage
+func language(lang string) string {
+	if lang == LocalLanguage {
+		return ""
+	}
+	return orDefault(lang, DefaultLanguage)
+}
+
+// Return value, or def if it is empty
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,22 @@
+package geocode
+
+// Geocoder wrapper allowing a bounded number of lookups in flight
+type limited struct {
+	next  Geocoder
+	slots chan struct{}
+}
+
+// Wrap next so that at most n lookups run at the same time; n below 1
+// is treated as 1
+func Limit(next Geocoder, n int) Geocoder {
+	if n < 1 {
+		n = 1
+	}
+	return limited{next: next, slots: make(chan struct{}, n)}
+}
+
+func (l limited) ReverseGeocode(lat, lon float64) (Location, error) {
+	l.slots <- struct{}{}
+	defer func() { <-l.slots }()
+	return l.next.ReverseGeocode(lat, lon)
+}
@@ -0,0 +1,101 @@
+package geocode
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Records the most lookups it had in flight at once
+type inFlightGeocoder struct {
+	current, max atomic.Int32
+}
+
+func (g *inFlightGeocoder) ReverseGeocode(lat, lon float64) (Location, error) {
+	n := g.current.Add(1)
+	defer g.current.Add(-1)
+	for {
+		seen := g.max.Load()
+		if n <= seen || g.max.CompareAndSwap(seen, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return Location{"country": "France"}, nil
+}
+
+func TestLimit(t *testing.T) {
+	for _, limit := range []int{0, 1, 3, 8} {
+		next := &inFlightGeocoder{}
+		geocoder := Limit(next, limit)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 40; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := geocoder.ReverseGeocode(48.85, 2.35); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		want := int32(max(limit, 1))
+		if got := next.max.Load(); got > want {
+			t.Errorf("Limit(%d): %d lookups in flight at once, want at most %d", limit, got, want)
+		} else if got < want {
+			// Not wrong, but the limit was never reached, so it went untested
+			t.Logf("Limit(%d): only %d lookups in flight at once", limit, got)
+		}
+	}
+}
+
+// Blocks lookups of one position until release is closed
+type slowGeocoder struct {
+	slowLat float64
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g *slowGeocoder) ReverseGeocode(lat, lon float64) (Location, error) {
+	if lat == g.slowLat {
+		close(g.started)
+		<-g.release
+	}
+	return Location{"country": "France"}, nil
+}
+
+func TestCacheHitsSkipConcurrencyLimit(t *testing.T) {
+	next := &slowGeocoder{slowLat: 1, started: make(chan struct{}), release: make(chan struct{})}
+	cache := NewMemoryCache(next)
+	cache.LimitConcurrency(1)
+	if _, err := cache.ReverseGeocode(48.85, 2.35); err != nil {
+		t.Fatal(err)
+	}
+
+	// A slow miss holds the only slot
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.ReverseGeocode(1, 1)
+	}()
+	<-next.started
+
+	hit := make(chan error, 1)
+	go func() {
+		_, err := cache.ReverseGeocode(48.85, 2.35)
+		hit <- err
+	}()
+	select {
+	case err := <-hit:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("cache hit waited for the slot of a miss")
+	}
+	close(next.release)
+	<-done
+}
@@ -0,0 +1,72 @@
+package geocode
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Endpoint of the Mapbox geocoding API
+const DefaultMapboxURL = "https://api.mapbox.com/geocoding/v5/mapbox.places"
+
+// Geocoder backed by the Mapbox geocoding API; needs an access token
+type Mapbox struct {
+	BaseURL  string       // service endpoint, without the coordinates path
+	Client   *http.Client // HTTP client used for requests
+	Token    string       // Mapbox access token
+	Language string       // language of place names; "" means DefaultLanguage, LocalLanguage the local names
+}
+
+// Create a Mapbox geocoder using the given access token
+func NewMapbox(token string) *Mapbox {
+	return &Mapbox{BaseURL: DefaultMapboxURL, Client: NewHTTPClient(DefaultUserAgent, DefaultRetries), Token: token}
+}
+
+func (m *Mapbox) ReverseGeocode(lat, lon float64) (Location, error) {
+	query := url.Values{}
+	query.Set("access_token", m.Token)
+	if lang := language(m.Language); lang != "" {
+		query.Set("language", lang)
+	}
+	query.Set("types", "country,region,district,place")
+
+	var data struct {
+		Features []struct {
+			PlaceType  []string `json:"place_type"`
+			Text       string   `json:"text"`
+			Properties struct {
+				ShortCode string `json:"short_code"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+	u := fmt.Sprintf("%s/%f,%f.json?%s", m.BaseURL, lon, lat, query.Encode())
+	if err := getJSON(m.Client, u, m.Language, &data); err != nil {
+		return nil, err
+	}
+	if len(data.Features) == 0 {
+		return nil, ErrNoResult
+	}
+
+	// Mapbox returns one feature per requested type
+	names := make(map[string]string)
+	countryCode := ""
+	for _, feature := range data.Features {
+		for _, placeType := range feature.PlaceType {
+			if _, found := names[placeType]; !found {
+				names[placeType] = feature.Text
+				if placeType == "country" {
+					countryCode = strings.ToLower(feature.Properties.ShortCode)
+				}
+			}
+		}
+	}
+
+	return Location{
+		"country":      names["country"],
+		"state":        names["region"],
+		"county":       names["district"],
+		"city":         names["place"],
+		"country_code": countryCode,
+	}, nil
+}
@@ -0,0 +1,151 @@
+package geocode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// Endpoint of the public Nominatim service
+const DefaultBaseURL = "https://nominatim.openstreetmap.org"
+
+// Query parameters set by Nominatim that Params may not override
+var ReservedParams = []string{"lat", "lon", "format"}
+
+// Report whether a query parameter is reserved
+func IsReserved(key string) bool {
+	for _, reserved := range ReservedParams {
+		if key == reserved {
+			return true
+		}
+	}
+	return false
+}
+
+// Returned when Nominatim has blocked this client; the run must stop
+var ErrBanned = errors.New("blocked by the geocoding API")
+
+// Default pattern matched against 403/429 bodies to detect a ban
+const DefaultBanPattern = `(?i)\b(blocked|banned|access denied)\b`
+
+// Geocoder backed by the Nominatim reverse-geocoding API
+type Nominatim struct {
+	BaseURL    string         // service endpoint, without the /reverse path
+	Client     *http.Client   // HTTP client used for requests
+	Language   string         // language of place names, sent as Accept-Language; "" means DefaultLanguage, LocalLanguage the local names
+	Zoom       int            // detail level of lookups; 0 means DefaultZoom
+	Params     url.Values     // extra query parameters merged into each request
+	BanPattern *regexp.Regexp // 403/429 body pattern reported as ErrBanned; nil disables
+}
+
+// Create a Nominatim geocoder for the public endpoint
+func NewNominatim() *Nominatim {
+	return &Nominatim{
+		BaseURL:    DefaultBaseURL,
+		Client:     NewHTTPClient(DefaultUserAgent, DefaultRetries),
+		BanPattern: regexp.MustCompile(DefaultBanPattern),
+	}
+}
+
+// Endpoint of the LocationIQ API, which speaks the Nominatim protocol
+const DefaultLocationIQURL = "https://us1.locationiq.com/v1"
+
+// Create a geocoder for LocationIQ, a hosted Nominatim-compatible service
+// that needs an API key
+func NewLocationIQ(key string) *Nominatim {
+	n := NewNominatim()
+	n.BaseURL = DefaultLocationIQURL
+	n.Params = url.Values{"key": {key}}
+	return n
+}
+
+// Build the reverse-geocode URL, merging in any extra query parameters
+func (n *Nominatim) reverseURL(lat, lon float64) string {
+	query := url.Values{}
+	query.Set("format", "json")
+	query.Set("lat", fmt.Sprintf("%f", lat))
+	query.Set("lon", fmt.Sprintf("%f", lon))
+	zoom := n.Zoom
+	if zoom == 0 {
+		zoom = DefaultZoom
+	}
+	query.Set("zoom", strconv.Itoa(zoom))
+	for key, values := range n.Params {
+		query[key] = values
+	}
+	return n.BaseURL + "/reverse?" + query.Encode()
+}
+
+// Fetch location details (country, state, state district, county).
+// Fields missing from the response are left empty.
+func (n *Nominatim) ReverseGeocode(lat, lon float64) (Location, error) {
+	url := n.reverseURL(lat, lon)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if lang := language(n.Language); lang != "" {
+		req.Header.Set("Accept-Language", lang)
+	}
+
+	// Perform the request
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if isBanned(resp.StatusCode, body, n.BanPattern) {
+			return nil, fmt.Errorf("%w (HTTP %d)", ErrBanned, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("API error: %d", resp.StatusCode)
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	address, ok := data["address"].(map[string]interface{})
+	if !ok {
+		// Nominatim answers {"error": "Unable to geocode"} where nothing is
+		if message, found := data["error"]; found {
+			return nil, fmt.Errorf("%w: %v", ErrNoResult, message)
+		}
+		return nil, fmt.Errorf("invalid address data")
+	}
+
+	// Keep every address part as it is, so layouts can use more than
+	// Fields and -city-fields can choose between city, town and village
+	location := Location{}
+	for key, value := range address {
+		if str, ok := value.(string); ok {
+			location[key] = str
+		}
+	}
+
+	return location, nil
+}
+
+// Report whether a failed response means the client has been banned
+func isBanned(status int, body []byte, pattern *regexp.Regexp) bool {
+	if pattern == nil {
+		return false
+	}
+	if status != http.StatusForbidden && status != http.StatusTooManyRequests {
+		return false
+	}
+	return pattern.Match(body)
+}
@@ -0,0 +1,199 @@
+package geocode
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Cities files of a GeoNames dump, most detailed first
+var geonamesCityFiles = []string{"cities500.txt", "cities1000.txt", "cities5000.txt", "cities15000.txt"}
+
+// Default distance beyond which the nearest place is not trusted
+const DefaultMaxDistanceKm = 100
+
+// A populated place of the GeoNames dataset
+type place struct {
+	name    string
+	lat     float64
+	lon     float64
+	country string // ISO 3166 alpha-2 code
+	admin1  string
+	admin2  string
+	zone    string // IANA time zone, e.g. "Europe/Paris"
+}
+
+// Geocoder that resolves coordinates without network access, using the
+// nearest populated place of a GeoNames dump
+// (https://download.geonames.org/export/dump/).
+type Offline struct {
+	MaxDistanceKm float64 // no match when the nearest place is farther away
+
+	countries map[string]string // "FR" -> "France"
+	admin1    map[string]string // "FR.11" -> "Île-de-France"
+	admin2    map[string]string // "FR.11.75" -> "Paris"
+	grid      map[[2]int][]place
+	zones     sync.Map // IANA name -> *time.Location, loaded on first use
+}
+
+// Load a GeoNames dump from dir. It must contain one of the cities files
+// (cities500.txt ... cities15000.txt) plus admin1CodesASCII.txt;
+// admin2Codes.txt and countryInfo.txt are used when present.
+func LoadGeoNames(dir string) (*Offline, error) {
+	o := &Offline{
+		MaxDistanceKm: DefaultMaxDistanceKm,
+		grid:          make(map[[2]int][]place),
+	}
+
+	var err error
+	if o.admin1, err = readCodes(filepath.Join(dir, "admin1CodesASCII.txt"), 0, 1); err != nil {
+		return nil, err
+	}
+	if o.admin2, err = readCodes(filepath.Join(dir, "admin2Codes.txt"), 0, 1); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if o.countries, err = readCodes(filepath.Join(dir, "countryInfo.txt"), 0, 4); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, name := range geonamesCityFiles {
+		err = o.readPlaces(filepath.Join(dir, name))
+		if !errors.Is(err, os.ErrNotExist) {
+			return o, err
+		}
+	}
+	return nil, fmt.Errorf("no GeoNames cities file (%s) in %s", strings.Join(geonamesCityFiles, ", "), dir)
+}
+
+// Read a tab-separated file into a map from column key to column value,
+// skipping comment lines
+func readCodes(path string, key, value int) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	codes := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		cols := strings.Split(line, "\t")
+		if len(cols) > key && len(cols) > value {
+			codes[cols[key]] = cols[value]
+		}
+	}
+	return codes, scanner.Err()
+}
+
+// Read the populated places of a GeoNames cities file into the grid
+func (o *Offline) readPlaces(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		cols := strings.Split(scanner.Text(), "\t")
+		if len(cols) < 12 {
+			continue
+		}
+		lat, err1 := strconv.ParseFloat(cols[4], 64)
+		lon, err2 := strconv.ParseFloat(cols[5], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		p := place{name: cols[1], lat: lat, lon: lon, country: cols[8], admin1: cols[10], admin2: cols[11]}
+		if len(cols) > 17 {
+			p.zone = cols[17]
+		}
+		cell := gridCell(lat, lon)
+		o.grid[cell] = append(o.grid[cell], p)
+	}
+	return scanner.Err()
+}
+
+// Grid cell of one degree containing the coordinates
+func gridCell(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat)), int(math.Floor(lon))}
+}
+
+// Great-circle distance between two points in kilometres
+func DistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// Find the nearest place, searching rings of grid cells outwards until
+// no closer place can exist
+func (o *Offline) nearest(lat, lon float64) (place, float64, bool) {
+	var best place
+	bestDist := math.Inf(1)
+	center := gridCell(lat, lon)
+	maxRing := int(math.Ceil(o.MaxDistanceKm/111)) + 1
+
+	for ring := 0; ring <= maxRing; ring++ {
+		for dLat := -ring; dLat <= ring; dLat++ {
+			for dLon := -ring; dLon <= ring; dLon++ {
+				if abs(dLat) != ring && abs(dLon) != ring {
+					continue
+				}
+				for _, p := range o.grid[[2]int{center[0] + dLat, center[1] + dLon}] {
+					if d := DistanceKm(lat, lon, p.lat, p.lon); d < bestDist {
+						best, bestDist = p, d
+					}
+				}
+			}
+		}
+		// Every place in a farther ring is at least ring degrees of
+		// latitude away (about 111 km each)
+		if bestDist <= float64(ring)*111 {
+			break
+		}
+	}
+	return best, bestDist, !math.IsInf(bestDist, 1)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func (o *Offline) ReverseGeocode(lat, lon float64) (Location, error) {
+	p, dist, found := o.nearest(lat, lon)
+	if !found || dist > o.MaxDistanceKm {
+		return nil, fmt.Errorf("%w within %.0f km", ErrNoResult, o.MaxDistanceKm)
+	}
+
+	country := p.country
+	if name, found := o.countries[p.country]; found {
+		country = name
+	}
+
+	return Location{
+		"country":      country,
+		"state":        o.admin1[p.country+"."+p.admin1],
+		"county":       o.admin2[p.country+"."+p.admin1+"."+p.admin2],
+		"city":         p.name,
+		"country_code": strings.ToLower(p.country),
+	}, nil
+}
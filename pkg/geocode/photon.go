@@ -0,0 +1,60 @@
+package geocode
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Endpoint of the public Photon service
+const DefaultPhotonURL = "https://photon.komoot.io"
+
+// Geocoder backed by the Photon API (https://photon.komoot.io), an
+// OpenStreetMap geocoder without Nominatim's strict rate limit
+type Photon struct {
+	BaseURL  string       // service endpoint, without the /reverse path
+	Client   *http.Client // HTTP client used for requests
+	Language string       // language of place names; "" means DefaultLanguage, LocalLanguage the local names
+}
+
+// Create a Photon geocoder for the public endpoint
+func NewPhoton() *Photon {
+	return &Photon{BaseURL: DefaultPhotonURL, Client: NewHTTPClient(DefaultUserAgent, DefaultRetries)}
+}
+
+func (p *Photon) ReverseGeocode(lat, lon float64) (Location, error) {
+	query := url.Values{}
+	query.Set("lat", fmt.Sprintf("%f", lat))
+	query.Set("lon", fmt.Sprintf("%f", lon))
+	if lang := language(p.Language); lang != "" {
+		query.Set("lang", lang)
+	}
+
+	var data struct {
+		Features []struct {
+			Properties struct {
+				Country     string `json:"country"`
+				CountryCode string `json:"countrycode"`
+				State       string `json:"state"`
+				County      string `json:"county"`
+				City        string `json:"city"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := getJSON(p.Client, p.BaseURL+"/reverse?"+query.Encode(), p.Language, &data); err != nil {
+		return nil, err
+	}
+	if len(data.Features) == 0 {
+		return nil, ErrNoResult
+	}
+
+	props := data.Features[0].Properties
+	return Location{
+		"country":      props.Country,
+		"state":        props.State,
+		"county":       props.County,
+		"city":         props.City,
+		"country_code": strings.ToLower(props.CountryCode),
+	}, nil
+}
@@ -0,0 +1,54 @@
+package geocode
+
+import (
+	"sync"
+	"time"
+)
+
+// Token bucket spacing out calls to perSecond on average, used by the
+// clients of ProviderClient
+type tokenBucket struct {
+	interval time.Duration // time to earn one token
+	burst    float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Create a bucket allowing perSecond calls a second on average, with
+// bursts of up to burst calls; nil if perSecond is 0 or less
+func newTokenBucket(perSecond float64, burst int) *tokenBucket {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		interval: time.Duration(float64(time.Second) / perSecond),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Take a token, returning how long the caller must wait for it
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	// Tokens may go negative: each waiter queues behind the previous one
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens * float64(b.interval))
+}
@@ -0,0 +1,34 @@
+package geocode
+
+import (
+	"fmt"
+	"time"
+	_ "time/tzdata" // zone rules for systems without a zoneinfo database
+)
+
+// Finds the time zone in effect at a position
+type TimezoneFinder interface {
+	Timezone(lat, lon float64) (*time.Location, error)
+}
+
+// Return the time zone of the nearest place of the dataset. Zone borders
+// are not in the GeoNames dump, so near a border the zone of the other
+// side may win.
+func (o *Offline) Timezone(lat, lon float64) (*time.Location, error) {
+	p, dist, found := o.nearest(lat, lon)
+	if !found || dist > o.MaxDistanceKm {
+		return nil, fmt.Errorf("no known place within %.0f km", o.MaxDistanceKm)
+	}
+	if p.zone == "" {
+		return nil, fmt.Errorf("no time zone known for %s", p.name)
+	}
+	if zone, ok := o.zones.Load(p.zone); ok {
+		return zone.(*time.Location), nil
+	}
+	zone, err := time.LoadLocation(p.zone)
+	if err != nil {
+		return nil, err
+	}
+	o.zones.Store(p.zone, zone)
+	return zone, nil
+}
@@ -0,0 +1,90 @@
+package geocode
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Latin letters the decomposition in Transliterate does not cover
+var latinLetters = map[rune]string{
+	'ß': "ss", 'ẞ': "SS", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE",
+	'ø': "o", 'Ø': "O", 'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D",
+	'þ': "th", 'Þ': "Th", 'ł': "l", 'Ł': "L", 'ı': "i", 'ħ': "h", 'Ħ': "H",
+}
+
+// Accented Latin letters and their base letter
+var accented = map[rune]rune{}
+
+func init() {
+	groups := map[rune]string{
+		'a': "àáâãäåāăąǎ", 'A': "ÀÁÂÃÄÅĀĂĄǍ",
+		'c': "çćĉċč", 'C': "ÇĆĈĊČ",
+		'd': "ď", 'D': "Ď",
+		'e': "èéêëēĕėęěẽ", 'E': "ÈÉÊËĒĔĖĘĚẼ",
+		'g': "ĝğġģ", 'G': "ĜĞĠĢ",
+		'h': "ĥ", 'H': "Ĥ",
+		'i': "ìíîïĩīĭįǐ", 'I': "ÌÍÎÏĨĪĬĮǏİ",
+		'j': "ĵ", 'J': "Ĵ",
+		'k': "ķ", 'K': "Ķ",
+		'l': "ĺļľŀ", 'L': "ĹĻĽĿ",
+		'n': "ñńņňŉ", 'N': "ÑŃŅŇ",
+		'o': "òóôõöōŏőǒơ", 'O': "ÒÓÔÕÖŌŎŐǑƠ",
+		'r': "ŕŗř", 'R': "ŔŖŘ",
+		's': "śŝşšș", 'S': "ŚŜŞŠȘ",
+		't': "ţťŧț", 'T': "ŢŤŦȚ",
+		'u': "ùúûüũūŭůűųǔư", 'U': "ÙÚÛÜŨŪŬŮŰŲǓƯ",
+		'w': "ŵ", 'W': "Ŵ",
+		'y': "ýÿŷ", 'Y': "ÝŸŶ",
+		'z': "źżž", 'Z': "ŹŻŽ",
+	}
+	for base, letters := range groups {
+		for _, letter := range letters {
+			accented[letter] = base
+		}
+	}
+}
+
+// Cyrillic and Greek letters in a simple Latin spelling
+var scriptLetters = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g", 'д': "d", 'е': "e", 'ё': "yo", 'є': "ye",
+	'ж': "zh", 'з': "z", 'и': "i", 'і': "i", 'ї': "yi", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh",
+	'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'ђ': "dj", 'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz", 'ў': "u",
+
+	'α': "a", 'ά': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'έ': "e", 'ζ': "z", 'η': "i",
+	'ή': "i", 'θ': "th", 'ι': "i", 'ί': "i", 'ϊ': "i", 'ΐ': "i", 'κ': "k", 'λ': "l", 'μ': "m",
+	'ν': "n", 'ξ': "x", 'ο': "o", 'ό': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t",
+	'υ': "y", 'ύ': "y", 'ϋ': "y", 'ΰ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o", 'ώ': "o",
+}
+
+// Spell a place name in Latin letters without accents, e.g. "München"
+// becomes "Munchen" and "Москва" "Moskva". Cyrillic and Greek are
+// transliterated letter by letter; other scripts are kept as they are.
+func Transliterate(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r < 0x80 {
+			b.WriteRune(r)
+			continue
+		}
+		if base, ok := accented[r]; ok {
+			b.WriteRune(base)
+			continue
+		}
+		if latin, ok := latinLetters[r]; ok {
+			b.WriteString(latin)
+			continue
+		}
+		lower := unicode.ToLower(r)
+		if latin, ok := scriptLetters[lower]; ok {
+			if lower != r && latin != "" {
+				latin = strings.ToUpper(latin[:1]) + latin[1:]
+			}
+			b.WriteString(latin)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
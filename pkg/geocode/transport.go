@@ -0,0 +1,150 @@
+package geocode
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// User-Agent sent when none is configured. Nominatim's usage policy asks
+// for one that identifies the application.
+const DefaultUserAgent = "pic-sorter (+https://github.com/avijeet7/pic-sorter)"
+
+// Default number of retries after a 429 or 5xx response
+const DefaultRetries = 4
+
+// Longest wait between two attempts
+const maxBackoff = 30 * time.Second
+
+// RoundTripper that sets the User-Agent and retries rate-limited and
+// server-error responses with exponential backoff
+type transport struct {
+	next      http.RoundTripper
+	userAgent string
+	retries   int
+	backoff   time.Duration // wait before the first retry, doubled each time
+
+	bucket     *tokenBucket   // spaces out every attempt, retries included; nil disables
+	banPattern *regexp.Regexp // 403/429 body pattern returned at once instead of retried; nil disables
+}
+
+// Create an HTTP client for geocoding requests that identifies itself
+// with userAgent and retries 429/5xx responses up to retries times
+func NewHTTPClient(userAgent string, retries int) *http.Client {
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second * time.Duration(retries+1),
+		Transport: &transport{
+			next:      http.DefaultTransport,
+			userAgent: userAgent,
+			retries:   retries,
+			backoff:   time.Second,
+		},
+	}
+}
+
+// Return a copy of client for one provider: every request it sends,
+// retries included, is spaced out to perSecond on average (0 or less for
+// no limit), and a 429 response whose body matches banPattern is returned
+// at once rather than retried, so a banned client stops asking. Clients
+// not made by NewHTTPClient are returned unchanged.
+func ProviderClient(client *http.Client, perSecond float64, banPattern *regexp.Regexp) *http.Client {
+	if client == nil {
+		return nil
+	}
+	t, ok := client.Transport.(*transport)
+	if !ok {
+		return client
+	}
+	limited := *t
+	limited.bucket = newTokenBucket(perSecond, 1)
+	limited.banPattern = banPattern
+	copied := *client
+	copied.Transport = &limited
+	return &copied
+}
+
+// Report whether a response status is worth retrying
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// Wait before the next attempt: the server's Retry-After if it sent one,
+// otherwise the exponential backoff
+func retryDelay(resp *http.Response, backoff time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		backoff = time.Duration(seconds) * time.Second
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+
+	backoff := t.backoff
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req); err != nil {
+			return nil, err
+		}
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || !retryable(resp.StatusCode) || attempt >= t.retries {
+			return resp, err
+		}
+		banned, err := t.banned(resp)
+		if err != nil {
+			return nil, err
+		}
+		if banned {
+			return resp, nil
+		}
+
+		delay := retryDelay(resp, backoff)
+		resp.Body.Close()
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+	}
+}
+
+// Wait for the token bucket, if any, to allow the next attempt
+func (t *transport) wait(req *http.Request) error {
+	if t.bucket == nil {
+		return nil
+	}
+	timer := time.NewTimer(t.bucket.reserve())
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// Report whether a response says the client is banned, see isBanned. Its
+// body is read to check and put back for the caller.
+func (t *transport) banned(resp *http.Response) (bool, error) {
+	if t.banPattern == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return false, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return false, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return isBanned(resp.StatusCode, body, t.banPattern), nil
+}
@@ -0,0 +1,90 @@
+package geocode
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serve the given statuses and bodies in turn, then 200s, recording when
+// each request came
+type scriptedServer struct {
+	statuses []int
+	body     string
+
+	mu       sync.Mutex
+	requests []time.Time
+}
+
+func (s *scriptedServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	n := len(s.requests)
+	s.requests = append(s.requests, time.Now())
+	s.mu.Unlock()
+	if n < len(s.statuses) {
+		rw.WriteHeader(s.statuses[n])
+		rw.Write([]byte(s.body))
+		return
+	}
+	rw.Write([]byte(`{"address": {"country": "France"}}`))
+}
+
+// Create a Nominatim geocoder for server whose client retries at once
+func testNominatim(server *httptest.Server, perSecond float64) *Nominatim {
+	n := NewNominatim()
+	n.BaseURL = server.URL
+	client := NewHTTPClient(DefaultUserAgent, DefaultRetries)
+	client.Transport.(*transport).backoff = time.Millisecond
+	n.Client = ProviderClient(client, perSecond, n.BanPattern)
+	return n
+}
+
+func TestTransportStopsWhenBanned(t *testing.T) {
+	script := &scriptedServer{statuses: []int{http.StatusTooManyRequests}, body: "You have been banned"}
+	server := httptest.NewServer(script)
+	defer server.Close()
+
+	_, err := testNominatim(server, 0).ReverseGeocode(48.85, 2.35)
+	if !errors.Is(err, ErrBanned) {
+		t.Errorf("error = %v, want ErrBanned", err)
+	}
+	if len(script.requests) != 1 {
+		t.Errorf("sent %d requests, want 1: a banned client must not retry", len(script.requests))
+	}
+}
+
+func TestTransportRetriesThroughRateLimit(t *testing.T) {
+	script := &scriptedServer{statuses: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable}, body: "slow down"}
+	server := httptest.NewServer(script)
+	defer server.Close()
+
+	const perSecond = 20
+	location, err := testNominatim(server, perSecond).ReverseGeocode(48.85, 2.35)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location["country"] != "France" {
+		t.Errorf("country = %q, want France", location["country"])
+	}
+	if len(script.requests) != 3 {
+		t.Fatalf("sent %d requests, want 3", len(script.requests))
+	}
+	// The backoff is 1ms, so only the rate limit spaces the retries out
+	interval := time.Second / perSecond
+	for i := 1; i < len(script.requests); i++ {
+		if gap := script.requests[i].Sub(script.requests[i-1]); gap < interval*9/10 {
+			t.Errorf("request %d came %s after the previous one, want at least %s", i+1, gap, interval)
+		}
+	}
+}
+
+func TestProviderClientKeepsOwnClient(t *testing.T) {
+	client := &http.Client{}
+	if got := ProviderClient(client, 1, regexp.MustCompile("banned")); got != client {
+		t.Error("a client not made by NewHTTPClient was replaced")
+	}
+}
@@ -0,0 +1,164 @@
+// Package gpx reads GPX track logs and looks up where they were at a
+// given time, to geotag photos from cameras without GPS.
+package gpx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default longest gap between track points, or between a photo and the
+// nearest point, that Locate bridges
+const DefaultMaxGap = 5 * time.Minute
+
+// A recorded position
+type Point struct {
+	Lat, Lon float64
+	Time     time.Time
+}
+
+// Timed positions from one or more GPX files, ordered by time
+type Track struct {
+	Points []Point
+	Offset time.Duration // added to photo times before the lookup, for cameras with a wrong clock
+	MaxGap time.Duration // see DefaultMaxGap
+}
+
+// The parts of a GPX document that carry timed positions
+type document struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []point `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Waypoints []point `xml:"wpt"`
+	Routes    []struct {
+		Points []point `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+type point struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Time string  `xml:"time"`
+}
+
+// Read the timed points of a GPX document; points without a time are
+// ignored
+func Read(r io.Reader) ([]Point, error) {
+	var doc document
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var raw []point
+	for _, trk := range doc.Tracks {
+		for _, seg := range trk.Segments {
+			raw = append(raw, seg.Points...)
+		}
+	}
+	for _, rte := range doc.Routes {
+		raw = append(raw, rte.Points...)
+	}
+	raw = append(raw, doc.Waypoints...)
+
+	var points []Point
+	for _, p := range raw {
+		if p.Time == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(p.Time))
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q: %w", p.Time, err)
+		}
+		points = append(points, Point{Lat: p.Lat, Lon: p.Lon, Time: t})
+	}
+	return points, nil
+}
+
+// Load a GPX file, or every .gpx file in a directory, into one track
+func Load(path string) (*Track, error) {
+	files := []string{path}
+	if info, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".gpx") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	track := &Track{MaxGap: DefaultMaxGap}
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		points, err := Read(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		track.Points = append(track.Points, points...)
+	}
+	if len(track.Points) == 0 {
+		return nil, fmt.Errorf("%s: no timed track points", path)
+	}
+	sort.SliceStable(track.Points, func(i, j int) bool { return track.Points[i].Time.Before(track.Points[j].Time) })
+	return track, nil
+}
+
+// Return where the track was when a photo was taken at t. Between two
+// points no more than MaxGap apart the position is interpolated linearly;
+// otherwise the nearest point is used if it is within MaxGap. ok is false
+// if the track has no position close enough in time.
+func (t *Track) Locate(taken time.Time) (lat, lon float64, ok bool) {
+	points := t.Points
+	if len(points) == 0 {
+		return 0, 0, false
+	}
+	at := taken.Add(t.Offset)
+	i := sort.Search(len(points), func(i int) bool { return !points[i].Time.Before(at) })
+
+	if i < len(points) && points[i].Time.Equal(at) {
+		return points[i].Lat, points[i].Lon, true
+	}
+	if i > 0 && i < len(points) {
+		before, after := points[i-1], points[i]
+		if span := after.Time.Sub(before.Time); span <= t.MaxGap {
+			f := float64(at.Sub(before.Time)) / float64(span)
+			return before.Lat + f*(after.Lat-before.Lat), before.Lon + f*(after.Lon-before.Lon), true
+		}
+	}
+
+	// Fall back to the nearest point on either side
+	best, bestGap := -1, t.MaxGap
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(points) {
+			continue
+		}
+		gap := at.Sub(points[j].Time)
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap <= bestGap {
+			best, bestGap = j, gap
+		}
+	}
+	if best < 0 {
+		return 0, 0, false
+	}
+	return points[best].Lat, points[best].Lon, true
+}
@@ -0,0 +1,242 @@
+// Package orient turns JPEG photos upright according to their EXIF
+// Orientation tag, for viewers that ignore the tag.
+package orient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// JPEG quality of images that cannot be rotated losslessly
+const quality = 95
+
+// Tag number of the EXIF orientation
+const orientationTag = 0x0112
+
+// Arguments of jpegtran for each orientation, undoing it losslessly
+var jpegtranArgs = map[int][]string{
+	2: {"-flip", "horizontal"},
+	3: {"-rotate", "180"},
+	4: {"-flip", "vertical"},
+	5: {"-transpose"},
+	6: {"-rotate", "90"},
+	7: {"-transverse"},
+	8: {"-rotate", "270"},
+}
+
+// Turn the JPEG at path upright and set its orientation tag to 1 (normal).
+// With jpegtran on the PATH the image data is transformed losslessly;
+// otherwise, or where the image size does not allow that, it is decoded,
+// rotated and encoded again at high quality, keeping its metadata.
+// Returns false if the image had no orientation to apply.
+func Apply(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	orientation, _, _, err := readOrientation(data)
+	if err != nil || jpegtranArgs[orientation] == nil {
+		return false, err
+	}
+
+	rotated, err := jpegtran(path, orientation)
+	if err != nil {
+		if rotated, err = reencode(data, orientation); err != nil {
+			return false, err
+		}
+	}
+	_, offset, order, err := readOrientation(rotated)
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 {
+		order.PutUint16(rotated[offset:], 1)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return true, writeFile(path, rotated, info)
+}
+
+// Transform the JPEG at path with jpegtran, refusing lossy edges
+func jpegtran(path string, orientation int) ([]byte, error) {
+	bin, err := exec.LookPath("jpegtran")
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"-copy", "all", "-perfect"}, jpegtranArgs[orientation]...)
+	return exec.Command(bin, append(args, path)...).Output()
+}
+
+// Decode a JPEG, transform its pixels and encode it again with the
+// metadata segments of the original
+func reencode(data []byte, orientation int) ([]byte, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, Transform(img, orientation), &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+
+	segments, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+	out := encoded.Bytes()
+	var result bytes.Buffer
+	result.Grow(len(out) + len(data)/8)
+	result.Write(out[:2]) // start of image
+	for _, seg := range segments {
+		// Application segments (Exif, XMP, ICC profile) and comments
+		if seg.marker >= 0xe1 && seg.marker <= 0xef || seg.marker == 0xfe {
+			result.Write(data[seg.start:seg.end])
+		}
+	}
+	result.Write(out[2:])
+	return result.Bytes(), nil
+}
+
+// Return a copy of img turned upright from an EXIF orientation
+func Transform(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	size := image.Rect(0, 0, w, h)
+	if orientation >= 5 {
+		size = image.Rect(0, 0, h, w)
+	}
+	dst := image.NewRGBA(size)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Destination of the source pixel (x, y)
+			dx, dy := x, y
+			switch orientation {
+			case 2:
+				dx = w - 1 - x
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dy = h - 1 - y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// Replace path through a temporary file, keeping its permissions and
+// modification time
+func writeFile(path string, data []byte, info os.FileInfo) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed into place
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	os.Chmod(tmp.Name(), info.Mode().Perm())
+	os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime())
+	return os.Rename(tmp.Name(), path)
+}
+
+// A marker segment in the header of a JPEG file
+type segment struct {
+	marker     byte
+	start, end int // offsets of the whole segment, marker included
+}
+
+// Split the header of a JPEG file into its marker segments, up to but
+// not including the start of scan
+func jpegSegments(data []byte) ([]segment, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("not a JPEG file")
+	}
+	var segments []segment
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, errors.New("corrupt JPEG header")
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			pos++ // fill byte
+			continue
+		}
+		if marker == 0xda { // start of scan: the image data follows
+			return segments, nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("corrupt JPEG header")
+		}
+		segments = append(segments, segment{marker: marker, start: pos, end: end})
+		pos = end
+	}
+}
+
+// Read the orientation tag of a JPEG, and the file offset and byte order
+// of its value; 0 and offset 0 if it has none
+func readOrientation(data []byte) (int, int, binary.ByteOrder, error) {
+	segments, err := jpegSegments(data)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	for _, seg := range segments {
+		body := seg.start + 4
+		if seg.marker != 0xe1 || !bytes.HasPrefix(data[body:seg.end], []byte("Exif\x00\x00")) {
+			continue
+		}
+		tiff := body + 6
+		if tiff+8 > seg.end {
+			break
+		}
+		var order binary.ByteOrder
+		switch string(data[tiff : tiff+2]) {
+		case "II":
+			order = binary.LittleEndian
+		case "MM":
+			order = binary.BigEndian
+		default:
+			return 0, 0, nil, nil
+		}
+		ifd := tiff + int(order.Uint32(data[tiff+4:]))
+		if ifd < tiff || ifd+2 > seg.end {
+			break
+		}
+		for i := 0; i < int(order.Uint16(data[ifd:])); i++ {
+			entry := ifd + 2 + 12*i
+			if entry+12 > seg.end {
+				break
+			}
+			// A SHORT value sits in the first two bytes of the value field
+			if order.Uint16(data[entry:]) == orientationTag && order.Uint16(data[entry+2:]) == 3 {
+				return int(order.Uint16(data[entry+8:])), entry + 8, order, nil
+			}
+		}
+		break
+	}
+	return 0, 0, nil, nil
+}
@@ -0,0 +1,117 @@
+// Package phash computes perceptual hashes of images: similar pictures,
+// such as the frames of a burst, get hashes that differ in few bits.
+package phash
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+
+	"pic-sorter/pkg/exifinfo"
+	"pic-sorter/pkg/thumb"
+)
+
+// Side of the grayscale grid the DCT is taken of
+const gridSize = 32
+
+// Side of the block of low frequencies that make up the hash
+const hashSize = 8
+
+// Hash an image file. The preview in its EXIF data is used when there is
+// one, which is much faster than decoding the image and hashes the same.
+func File(imagePath string) (uint64, error) {
+	if data, err := exifinfo.Thumbnail(imagePath); err == nil {
+		if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+			return Hash(img), nil
+		}
+	}
+	img, err := thumb.Decode(imagePath)
+	if err != nil {
+		return 0, err
+	}
+	return Hash(img), nil
+}
+
+// Return the DCT-based perceptual hash of img: each bit tells whether
+// one of the 64 lowest frequencies of its 32x32 grayscale version is
+// above their median
+func Hash(img image.Image) uint64 {
+	grid := grayscale(img)
+	dct := dct2(grid)
+
+	coeffs := make([]float64, 0, hashSize*hashSize)
+	for y := 0; y < hashSize; y++ {
+		coeffs = append(coeffs, dct[y][:hashSize]...)
+	}
+	// The DC term only says how bright the image is
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for i, c := range coeffs {
+		if c > median {
+			hash |= 1 << i
+		}
+	}
+	return hash
+}
+
+// Number of bits two hashes differ in: 0 for the same picture, up to
+// about 10 for the frames of a burst, around 32 for unrelated images
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Average the luminance of img over a gridSize x gridSize grid
+func grayscale(img image.Image) [gridSize][gridSize]float64 {
+	var sum, count [gridSize][gridSize]float64
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		gy := (y - b.Min.Y) * gridSize / h
+		for x := b.Min.X; x < b.Max.X; x++ {
+			gx := (x - b.Min.X) * gridSize / w
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sum[gy][gx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+			count[gy][gx]++
+		}
+	}
+	// Images smaller than the grid leave cells empty; they stay 0
+	for y := range sum {
+		for x := range sum[y] {
+			if count[y][x] > 0 {
+				sum[y][x] /= count[y][x]
+			}
+		}
+	}
+	return sum
+}
+
+// Two-dimensional DCT-II of the grid, rows then columns
+func dct2(grid [gridSize][gridSize]float64) [gridSize][gridSize]float64 {
+	var cos [gridSize][gridSize]float64
+	for k := range cos {
+		for n := range cos[k] {
+			cos[k][n] = math.Cos(math.Pi / gridSize * (float64(n) + 0.5) * float64(k))
+		}
+	}
+	var rows, out [gridSize][gridSize]float64
+	for y := 0; y < gridSize; y++ {
+		for k := 0; k < gridSize; k++ {
+			for n := 0; n < gridSize; n++ {
+				rows[y][k] += grid[y][n] * cos[k][n]
+			}
+		}
+	}
+	for x := 0; x < gridSize; x++ {
+		for k := 0; k < gridSize; k++ {
+			for n := 0; n < gridSize; n++ {
+				out[k][x] += rows[n][x] * cos[k][n]
+			}
+		}
+	}
+	return out
+}
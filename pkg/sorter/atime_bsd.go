@@ -0,0 +1,18 @@
+//go:build darwin || freebsd || netbsd
+
+package sorter
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Return the last access time of a file, or its modification time if
+// the system does not tell
+func accessTime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Atimespec.Unix())
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,16 @@
+package sorter
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Return the last access time of a file, or its modification time if
+// the system does not tell
+func accessTime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Atim.Unix())
+	}
+	return info.ModTime()
+}
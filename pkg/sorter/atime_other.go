@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package sorter
+
+import (
+	"os"
+	"time"
+)
+
+// Return the last access time of a file; unknown here, so its
+// modification time
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,16 @@
+package sorter
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Return the last access time of a file, or its modification time if
+// the system does not tell
+func accessTime(info os.FileInfo) time.Time {
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, data.LastAccessTime.Nanoseconds())
+	}
+	return info.ModTime()
+}
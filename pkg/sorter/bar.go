@@ -0,0 +1,100 @@
+package sorter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Width of the bar itself, in characters
+const barWidth = 30
+
+// Single-line progress bar redrawn in place on a terminal. Log records
+// are printed above it by clearing the line first, see barHandler.
+type progressBar struct {
+	mu        sync.Mutex
+	w         io.Writer
+	processed int
+	total     int
+	current   string
+	drawn     time.Time
+}
+
+func newProgressBar(w io.Writer) *progressBar {
+	return &progressBar{w: w}
+}
+
+// Record progress and the file just handled, if any, and redraw at most
+// ten times a second unless the run is complete
+func (b *progressBar) update(processed, total int, current string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.processed, b.total = processed, total
+	if current != "" {
+		b.current = current
+	}
+	if processed < total && time.Since(b.drawn) < 100*time.Millisecond {
+		return
+	}
+	b.draw()
+}
+
+// Draw the bar; the caller holds the lock
+func (b *progressBar) draw() {
+	filled := 0
+	if b.total > 0 {
+		filled = barWidth * b.processed / b.total
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	current := ""
+	if b.current != "" {
+		current = filepath.Base(b.current)
+	}
+	if len(current) > 40 {
+		current = current[:37] + "..."
+	}
+	fmt.Fprintf(b.w, "\r\033[K[%s] %d/%d, %d left %s", bar, b.processed, b.total, b.total-b.processed, current)
+	b.drawn = time.Now()
+}
+
+// Run print, which writes something above the bar
+func (b *progressBar) printAbove(print func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fmt.Fprint(b.w, "\r\033[K")
+	print()
+	b.draw()
+}
+
+// Draw the final state and end the line
+func (b *progressBar) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draw()
+	fmt.Fprintln(b.w)
+}
+
+// Log handler printing records above a progress bar
+type barHandler struct {
+	slog.Handler
+	bar *progressBar
+}
+
+func (h barHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	h.bar.printAbove(func() { err = h.Handler.Handle(ctx, r) })
+	return err
+}
+
+func (h barHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return barHandler{Handler: h.Handler.WithAttrs(attrs), bar: h.bar}
+}
+
+func (h barHandler) WithGroup(name string) slog.Handler {
+	return barHandler{Handler: h.Handler.WithGroup(name), bar: h.bar}
+}
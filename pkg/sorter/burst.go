@@ -0,0 +1,103 @@
+package sorter
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"pic-sorter/pkg/phash"
+)
+
+// Ways of handling bursts and near-duplicate shots, see Options.Bursts
+const (
+	BurstsFolder = "folder" // move each burst into a subfolder of its own
+	BurstsTag    = "tag"    // only name the burst in the catalog
+)
+
+// Defaults for grouping bursts
+const (
+	DefaultBurstGap      = 30 * time.Second
+	DefaultBurstDistance = 10
+)
+
+// Check that mode is "" or one of the Bursts* modes
+func checkBursts(mode string) error {
+	switch mode {
+	case "", BurstsFolder, BurstsTag:
+		return nil
+	}
+	return fmt.Errorf("unknown bursts mode %q, want %s or %s", mode, BurstsFolder, BurstsTag)
+}
+
+// Hash the first image of a group that can be hashed. Videos are never
+// part of a burst.
+func groupHash(group []string) (uint64, bool) {
+	for _, imagePath := range group {
+		if HasExt(imagePath, VideoExts) {
+			continue
+		}
+		if hash, err := phash.File(imagePath); err == nil {
+			return hash, true
+		}
+	}
+	return 0, false
+}
+
+// Name the bursts among the moves: runs of at least two shots bound for
+// the same folder, taken no more than BurstGap apart and whose hashes
+// differ in at most BurstDistance bits from the shot before. With
+// BurstsFolder each burst also gets a subfolder, e.g.
+// "burst_2023-07-01_143005".
+func groupBursts(moves []plannedMove, opts Options) {
+	gap, distance := opts.BurstGap, opts.BurstDistance
+	if gap <= 0 {
+		gap = DefaultBurstGap
+	}
+	if distance <= 0 {
+		distance = DefaultBurstDistance
+	}
+
+	byFolder := make(map[string][]*plannedMove)
+	for i := range moves {
+		move := &moves[i]
+		if move.hashed && move.info.ExactTime {
+			folder := strings.Join(move.levels, "/")
+			byFolder[folder] = append(byFolder[folder], move)
+		}
+	}
+	folders := make([]string, 0, len(byFolder))
+	for folder := range byFolder {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+
+	for _, folder := range folders {
+		seen := make(map[string]int)
+		runs := splitByTime(byFolder[folder], gap, func(prev, next *plannedMove) bool {
+			return phash.Distance(prev.hash, next.hash) > distance
+		})
+		for _, run := range runs {
+			// The RAW and JPEG of one shot do not make a burst
+			shots := make(map[string]bool)
+			for _, move := range run {
+				shots[move.shot] = true
+			}
+			if len(shots) < 2 {
+				continue
+			}
+			name := "burst_" + run[0].info.Time.Format("2006-01-02_150405")
+			if seen[name]++; seen[name] > 1 {
+				name = fmt.Sprintf("%s_%d", name, seen[name])
+			}
+			for _, move := range run {
+				move.burst = name
+				if opts.Bursts == BurstsFolder {
+					// Moves of a group share their levels
+					move.levels = append(slices.Clip(move.levels), name)
+				}
+			}
+		}
+	}
+}
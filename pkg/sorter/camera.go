@@ -0,0 +1,33 @@
+package sorter
+
+import "strings"
+
+// Makers whose model names already say what the device is, e.g.
+// "iPhone 14" or "Pixel 7"
+var selfNamedModels = map[string]bool{"apple": true, "google": true}
+
+// Return a folder name for a camera, e.g. "Canon EOS R5" or "iPhone 14".
+// The make is left out when the model already names the device; an
+// unknown camera gives placeholder.
+func cameraName(make, model, placeholder string) string {
+	make, model = strings.TrimSpace(make), strings.TrimSpace(model)
+	name := model
+	if make != "" {
+		brand := strings.Fields(make)[0] // "NIKON" of "NIKON CORPORATION"
+		switch {
+		case model == "":
+			name = make
+		case selfNamedModels[strings.ToLower(brand)]:
+		case !strings.HasPrefix(strings.ToLower(model), strings.ToLower(brand)):
+			name = brand + " " + model
+		}
+	}
+	if name == "" {
+		return placeholder
+	}
+	// A model name must never add a folder level
+	return levelSeparators.Replace(name)
+}
+
+// Replaces the path separators in values that become one folder level
+var levelSeparators = strings.NewReplacer("/", "-", `\`, "-")
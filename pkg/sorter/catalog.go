@@ -0,0 +1,289 @@
+package sorter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pic-sorter/pkg/exifinfo"
+	"pic-sorter/pkg/geocode"
+)
+
+// Metadata of one photo in the sorted tree
+type CatalogEntry struct {
+	Path   string `json:"path"`   // absolute path in the sorted tree
+	Source string `json:"source"` // absolute path it was sorted from
+	SHA256 string `json:"sha256"`
+
+	HasGPS bool    `json:"has_gps"`
+	Lat    float64 `json:"lat,omitempty"`
+	Lon    float64 `json:"lon,omitempty"`
+
+	Country       string `json:"country,omitempty"`
+	State         string `json:"state,omitempty"`
+	StateDistrict string `json:"state_district,omitempty"`
+	County        string `json:"county,omitempty"`
+	City          string `json:"city,omitempty"`
+	Place         string `json:"place,omitempty"` // name of the geofence the photo was taken in, see Options.Geofences
+
+	Time      time.Time `json:"time"`
+	ExactTime bool      `json:"exact_time"` // Time comes from the metadata, not the file
+	Make      string    `json:"make,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	Burst     string    `json:"burst,omitempty"` // name of the burst of similar shots the photo belongs to, see Options.Bursts
+
+	Added time.Time `json:"added"`
+}
+
+// Searchable record of the photos sorted into a tree, kept as one JSON
+// file next to the journals
+type Catalog struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]CatalogEntry // by Path
+	dirty   bool
+}
+
+// On-disk format of a Catalog
+type catalogFile struct {
+	Photos []CatalogEntry `json:"photos"`
+}
+
+// Default path of the catalog of a sorted tree
+func DefaultCatalogPath(destRoot string) string {
+	return filepath.Join(destRoot, stateDir, "catalog.json")
+}
+
+// Load the catalog saved at path; a missing file is an empty catalog
+func OpenCatalog(path string) (*Catalog, error) {
+	c := &Catalog{path: path, entries: make(map[string]CatalogEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file catalogFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("catalog %s: %w", path, err)
+	}
+	for _, entry := range file.Photos {
+		c.entries[entry.Path] = entry
+	}
+	return c, nil
+}
+
+// Build the catalog entry of a photo placed at dst
+func catalogEntry(src, dst, sum string, info exifinfo.Info, location geocode.Location) CatalogEntry {
+	return CatalogEntry{
+		Path:   absPath(dst),
+		Source: absPath(src),
+		SHA256: sum,
+
+		HasGPS: info.HasGPS,
+		Lat:    info.Lat,
+		Lon:    info.Lon,
+
+		Country:       location["country"],
+		State:         location["state"],
+		StateDistrict: location["state_district"],
+		County:        location["county"],
+		City:          location["city"],
+		Place:         location[geocode.PlaceField],
+
+		Time:      info.Time,
+		ExactTime: info.ExactTime,
+		Make:      info.Make,
+		Model:     info.Model,
+
+		Added: time.Now(),
+	}
+}
+
+// Add or replace the entry of a photo
+func (c *Catalog) Add(entry CatalogEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[entry.Path] = entry
+	c.dirty = true
+}
+
+// Remove the photo at path, e.g. because it was moved to the trash
+func (c *Catalog) Remove(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := absPath(path)
+	if _, ok := c.entries[key]; ok {
+		delete(c.entries, key)
+		c.dirty = true
+	}
+}
+
+// Return the entries matching q, ordered by capture time
+func (c *Catalog) Query(q CatalogQuery) []CatalogEntry {
+	c.mu.Lock()
+	var matches []CatalogEntry
+	for _, entry := range c.entries {
+		if q.Match(entry) {
+			matches = append(matches, entry)
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if !matches[i].Time.Equal(matches[j].Time) {
+			return matches[i].Time.Before(matches[j].Time)
+		}
+		return matches[i].Path < matches[j].Path
+	})
+	return matches
+}
+
+// Write the catalog back to its file if it changed
+func (c *Catalog) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	file := catalogFile{Photos: make([]CatalogEntry, 0, len(c.entries))}
+	for _, entry := range c.entries {
+		file.Photos = append(file.Photos, entry)
+	}
+	sort.Slice(file.Photos, func(i, j int) bool { return file.Photos[i].Path < file.Photos[j].Path })
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), os.ModePerm); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn catalog
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Conditions on catalog entries; empty fields match everything. Text
+// fields compare case-insensitively.
+type CatalogQuery struct {
+	Country, State, StateDistrict, County, City string
+	Place                                       string
+	Make, Model                                 string
+	From, To                                    time.Time // capture time in [From, To)
+	HasGPS                                      *bool
+}
+
+// Report whether entry satisfies every condition of q
+func (q CatalogQuery) Match(entry CatalogEntry) bool {
+	text := []struct{ want, got string }{
+		{q.Country, entry.Country},
+		{q.State, entry.State},
+		{q.StateDistrict, entry.StateDistrict},
+		{q.County, entry.County},
+		{q.City, entry.City},
+		{q.Place, entry.Place},
+		{q.Make, entry.Make},
+		{q.Model, entry.Model},
+	}
+	for _, field := range text {
+		if field.want != "" && !strings.EqualFold(field.want, field.got) {
+			return false
+		}
+	}
+	if !q.From.IsZero() && entry.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !entry.Time.Before(q.To) {
+		return false
+	}
+	return q.HasGPS == nil || *q.HasGPS == entry.HasGPS
+}
+
+// Parse query terms such as "country=France year=2021". Keys are the
+// location fields, place (a geofence), make, model, year (2021), month (2021-07), day
+// (2021-07-14), from and to (inclusive dates) and gps (yes or no).
+func ParseQuery(terms []string) (CatalogQuery, error) {
+	var q CatalogQuery
+	for _, term := range terms {
+		key, value, found := strings.Cut(term, "=")
+		if !found || value == "" {
+			return q, fmt.Errorf("expected key=value, got %q", term)
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "country":
+			q.Country = value
+		case "state":
+			q.State = value
+		case "state_district":
+			q.StateDistrict = value
+		case "county":
+			q.County = value
+		case "city":
+			q.City = value
+		case "place":
+			q.Place = value
+		case "make":
+			q.Make = value
+		case "model":
+			q.Model = value
+		case "year":
+			err = q.between(value, "2006", 1, 0, 0)
+		case "month":
+			err = q.between(value, "2006-01", 0, 1, 0)
+		case "day", "date":
+			err = q.between(value, "2006-01-02", 0, 0, 1)
+		case "from":
+			q.From, err = time.ParseInLocation("2006-01-02", value, time.Local)
+		case "to":
+			var to time.Time
+			to, err = time.ParseInLocation("2006-01-02", value, time.Local)
+			q.To = to.AddDate(0, 0, 1)
+		case "gps":
+			var hasGPS bool
+			switch strings.ToLower(value) {
+			case "yes", "true":
+				hasGPS = true
+			case "no", "false":
+			default:
+				err = fmt.Errorf("want yes or no")
+			}
+			q.HasGPS = &hasGPS
+		default:
+			return q, fmt.Errorf("unknown query key %q", key)
+		}
+		if err != nil {
+			return q, fmt.Errorf("invalid %s: %w", term, err)
+		}
+	}
+	return q, nil
+}
+
+// Limit q to the period starting at value, formatted with layout, that
+// lasts the given number of years, months and days
+func (q *CatalogQuery) between(value, layout string, years, months, days int) error {
+	from, err := time.ParseInLocation(layout, value, time.Local)
+	if err != nil {
+		return err
+	}
+	q.From, q.To = from, from.AddDate(years, months, days)
+	return nil
+}
@@ -0,0 +1,117 @@
+package sorter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Strategies for an image whose destination name is taken by a file
+// with different content
+const (
+	CollisionSuffix = "suffix" // append a number: IMG_1234_1.jpg
+	CollisionHash   = "hash"   // append the start of the content hash: IMG_1234_9f86d081.jpg
+	CollisionSkip   = "skip"   // leave the image where it is
+	CollisionFail   = "fail"   // report the image as failed
+)
+
+// Check that strategy is one of the Collision* strategies
+func checkCollisionStrategy(strategy string) error {
+	switch strategy {
+	case "", CollisionSuffix, CollisionHash, CollisionSkip, CollisionFail:
+		return nil
+	}
+	return fmt.Errorf("unknown collision strategy %q, want %s, %s, %s or %s",
+		strategy, CollisionSuffix, CollisionHash, CollisionSkip, CollisionFail)
+}
+
+// Reported for an image whose destination is taken, unless a new name
+// is picked
+type CollisionError struct {
+	Path string // the destination that exists
+}
+
+func (e *CollisionError) Error() string {
+	return e.Path + " already exists"
+}
+
+// Destination names in use: files on disk and names handed out during
+// the run, so that workers placing files with the same name never
+// overwrite each other
+type destNames struct {
+	mu     sync.Mutex
+	taken  map[string]bool
+	exists func(path string) bool // nil checks the local filesystem
+}
+
+func newDestNames() *destNames {
+	return &destNames{taken: make(map[string]bool)}
+}
+
+// Report whether path is taken; the caller holds the lock
+func (n *destNames) inUse(path string) bool {
+	if n.taken[path] {
+		return true
+	}
+	if n.exists != nil {
+		return n.exists(path)
+	}
+	_, err := os.Lstat(path)
+	return !os.IsNotExist(err)
+}
+
+// Reserve dst, or pick a free name next to it per strategy. sum is the
+// SHA-256 of the content, used by CollisionHash. Skip and fail return a
+// *CollisionError.
+func (n *destNames) reserve(dst, strategy, sum string) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !n.inUse(dst) {
+		n.taken[dst] = true
+		return dst, nil
+	}
+
+	ext := filepath.Ext(dst)
+	stem := strings.TrimSuffix(dst, ext)
+	switch strategy {
+	case CollisionSkip, CollisionFail:
+		return "", &CollisionError{Path: dst}
+	case CollisionHash:
+		if len(sum) > 8 {
+			sum = sum[:8]
+		}
+		stem += "_" + sum
+		if candidate := stem + ext; !n.inUse(candidate) {
+			n.taken[candidate] = true
+			return candidate, nil
+		}
+	}
+	for i := 1; ; i++ {
+		candidate := stem + "_" + strconv.Itoa(i) + ext
+		if !n.inUse(candidate) {
+			n.taken[candidate] = true
+			return candidate, nil
+		}
+	}
+}
+
+// Mark path as taken even if a file is there, for a file about to be
+// moved out of the way
+func (n *destNames) take(path string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.taken[path] = true
+}
+
+// Hand back names whose placement failed
+func (n *destNames) release(paths ...string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, path := range paths {
+		delete(n.taken, path)
+	}
+}
@@ -0,0 +1,125 @@
+package sorter
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Policies for images whose content is already in the sorted tree or
+// was placed earlier in the same run
+const (
+	DuplicateSkip     = "skip"      // leave the duplicate where it is
+	DuplicateKeepBoth = "keep-both" // place it anyway under a numbered name
+	DuplicateReplace  = "replace"   // trash the existing copy and place the new one
+	DuplicateTrash    = "trash"     // move the duplicate into the trash folder
+)
+
+// Directory under the destination root holding pic-sorter's own files
+const stateDir = ".pic-sorter"
+
+// Check that policy is one of the Duplicate* policies
+func checkDuplicatePolicy(policy string) error {
+	switch policy {
+	case "", DuplicateSkip, DuplicateKeepBoth, DuplicateReplace, DuplicateTrash:
+		return nil
+	}
+	return fmt.Errorf("unknown duplicate policy %q, want %s, %s, %s or %s",
+		policy, DuplicateSkip, DuplicateKeepBoth, DuplicateReplace, DuplicateTrash)
+}
+
+// Content index of the sorted tree. Existing files are only hashed once
+// an image of the same size shows up, so a large tree costs a walk, not
+// a full read.
+type dupIndex struct {
+	mu     sync.Mutex
+	bySize map[int64][]string // files not hashed yet
+	byHash map[string]string  // SHA-256 to the path holding that content
+}
+
+// Index the files under destRoot, leaving out pic-sorter's state, the
+// thumbnails and the skip folder, if any
+func newDupIndex(destRoot, skip string) (*dupIndex, error) {
+	d := &dupIndex{bySize: make(map[int64][]string), byHash: make(map[string]string)}
+	err := filepath.WalkDir(destRoot, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == destRoot && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == stateDir || entry.Name() == thumbsDir || (skip != "" && path == skip) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err == nil && entry.Type()&fs.ModeSymlink != 0 {
+			// Trees built with ModeLink hold links to the content
+			info, err = os.Stat(path)
+		}
+		if err == nil && info.Mode().IsRegular() {
+			d.bySize[info.Size()] = append(d.bySize[info.Size()], path)
+		}
+		return nil
+	})
+	return d, err
+}
+
+// Return the path already holding the content sum, or else record dst as
+// holding it. Hashing happens under the lock so that concurrent workers
+// never both miss the same existing file.
+func (d *dupIndex) claim(size int64, sum, dst string) (existing string, dup bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, path := range d.bySize[size] {
+		if h, err := fileSHA256(path); err == nil {
+			if _, taken := d.byHash[h]; !taken {
+				d.byHash[h] = path
+			}
+		}
+	}
+	delete(d.bySize, size)
+
+	if existing, ok := d.byHash[sum]; ok {
+		return existing, true
+	}
+	d.byHash[sum] = dst
+	return "", false
+}
+
+// Record that path now holds the content sum
+func (d *dupIndex) set(sum, path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.byHash[sum] = path
+}
+
+// Move a claim made for dst to the name the file gets after all, in one
+// step so that no other worker can miss the content in between
+func (d *dupIndex) moveClaim(sum, dst, to string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if holder, ok := d.byHash[sum]; !ok || holder == dst {
+		d.byHash[sum] = to
+	}
+}
+
+// Forget a claim made for dst if its placement failed
+func (d *dupIndex) release(sum, dst string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.byHash[sum] == dst {
+		delete(d.byHash, sum)
+	}
+}
+
+// Reserve a path in the trash folder of destRoot for a file named like path
+func trashPath(names *destNames, destRoot, path string) string {
+	trashed, _ := names.reserve(filepath.Join(destRoot, stateDir, "trash", filepath.Base(path)), CollisionSuffix, "")
+	return trashed
+}
@@ -0,0 +1,133 @@
+package sorter
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formats of ExportLocations
+const (
+	ExportGeoJSON = "geojson"
+	ExportKML     = "kml"
+)
+
+// Place of an entry as one line, innermost first
+func placeName(entry CatalogEntry) string {
+	var parts []string
+	for _, part := range []string{entry.Place, entry.City, entry.County, entry.State, entry.Country} {
+		if part != "" && (len(parts) == 0 || parts[len(parts)-1] != part) {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Write the entries that have coordinates as a GeoJSON FeatureCollection
+// or a KML document, one point per photo
+func ExportLocations(w io.Writer, format string, entries []CatalogEntry) error {
+	var located []CatalogEntry
+	for _, entry := range entries {
+		if entry.HasGPS {
+			located = append(located, entry)
+		}
+	}
+	switch format {
+	case ExportGeoJSON:
+		return writeGeoJSON(w, located)
+	case ExportKML:
+		return writeKML(w, located)
+	}
+	return fmt.Errorf("unknown export format %q, want %s or %s", format, ExportGeoJSON, ExportKML)
+}
+
+func writeGeoJSON(w io.Writer, entries []CatalogEntry) error {
+	type geometry struct {
+		Type        string    `json:"type"`
+		Coordinates []float64 `json:"coordinates"` // longitude first
+	}
+	type feature struct {
+		Type       string         `json:"type"`
+		Geometry   geometry       `json:"geometry"`
+		Properties map[string]any `json:"properties"`
+	}
+	collection := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Features: []feature{}}
+
+	for _, entry := range entries {
+		props := map[string]any{
+			"name":  filepath.Base(entry.Path),
+			"path":  entry.Path,
+			"time":  entry.Time.Format(time.RFC3339),
+			"place": placeName(entry),
+		}
+		for key, value := range map[string]string{
+			"country": entry.Country, "state": entry.State, "county": entry.County, "city": entry.City,
+			"make": entry.Make, "model": entry.Model,
+		} {
+			if value != "" {
+				props[key] = value
+			}
+		}
+		collection.Features = append(collection.Features, feature{
+			Type:       "Feature",
+			Geometry:   geometry{Type: "Point", Coordinates: []float64{entry.Lon, entry.Lat}},
+			Properties: props,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(collection)
+}
+
+func writeKML(w io.Writer, entries []CatalogEntry) error {
+	type point struct {
+		Coordinates string `xml:"coordinates"`
+	}
+	type timeStamp struct {
+		When string `xml:"when"`
+	}
+	type placemark struct {
+		Name        string    `xml:"name"`
+		Description string    `xml:"description,omitempty"`
+		TimeStamp   timeStamp `xml:"TimeStamp"`
+		Point       point     `xml:"Point"`
+	}
+	doc := struct {
+		XMLName    xml.Name    `xml:"kml"`
+		Xmlns      string      `xml:"xmlns,attr"`
+		Name       string      `xml:"Document>name"`
+		Placemarks []placemark `xml:"Document>Placemark"`
+	}{Xmlns: "http://www.opengis.net/kml/2.2", Name: "pic-sorter photos"}
+
+	for _, entry := range entries {
+		description := placeName(entry)
+		if camera := strings.TrimSpace(entry.Make + " " + entry.Model); camera != "" {
+			description = strings.TrimPrefix(description+"; "+camera, "; ")
+		}
+		doc.Placemarks = append(doc.Placemarks, placemark{
+			Name:        filepath.Base(entry.Path),
+			Description: description,
+			TimeStamp:   timeStamp{When: entry.Time.Format(time.RFC3339)},
+			Point:       point{Coordinates: fmt.Sprintf("%g,%g", entry.Lon, entry.Lat)},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,179 @@
+package sorter
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Extensions of the images that are always processed
+var ImageExts = []string{".jpg", ".jpeg", ".png", ".heic", ".heif", ".avif", ".webp"}
+
+// Extensions of the videos that are always processed, sorted by their
+// MP4/QuickTime metadata
+var VideoExts = []string{".mp4", ".mov", ".m4v", ".3gp"}
+
+// Default extensions of RAW files, sorted by their own EXIF data or
+// together with their JPEG when pairing is enabled
+const DefaultRawExts = ".cr2,.cr3,.nef,.arw,.dng,.orf,.raf,.rw2"
+
+// Report whether name has one of the given extensions (case-insensitive)
+func HasExt(name string, exts []string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse a comma-separated extension list into lowercase ".ext" entries
+func ParseExts(list string) []string {
+	var exts []string
+	for _, e := range strings.Split(list, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" {
+			continue
+		}
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		exts = append(exts, e)
+	}
+	return exts
+}
+
+// Parse a size such as "100KB", "2.5M" or "512". Units are binary: K or
+// KB is 1024 bytes, M or MB 1024 KB and G or GB 1024 MB.
+func ParseSize(text string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(text))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := 1.0
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			s = s[:n-1]
+		}
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", text)
+	}
+	return int64(value * multiplier), nil
+}
+
+// Report whether a pattern matches either the base name or the
+// slash-separated path relative to the source directory
+func matchAny(patterns []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	base := filepath.Base(rel)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// List the files under directory that pass the include/exclude filters.
+// Subdirectories are only entered when recursive is set; excluded
+// directories and the destination tree are never entered. Files with a
+// sidecar extension are returned separately and ignore the include list.
+func findFiles(directory string, opts Options) (paths, sidecars []string, err error) {
+	destRoot, _ := filepath.Abs(opts.DestRoot)
+
+	err = filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == directory {
+			return nil
+		}
+
+		rel, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if !opts.Recursive || matchAny(opts.Exclude, rel) {
+				return filepath.SkipDir
+			}
+			if abs, _ := filepath.Abs(path); abs == destRoot {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if matchAny(opts.Exclude, rel) {
+			return nil
+		}
+		// Sidecars follow their image, whatever -include says
+		if HasExt(path, opts.SidecarExts) {
+			sidecars = append(sidecars, path)
+			return nil
+		}
+		if len(opts.Include) > 0 && !matchAny(opts.Include, rel) {
+			return nil
+		}
+		if len(opts.Exts) > 0 && !HasExt(path, opts.Exts) {
+			return nil
+		}
+		if opts.MinSize > 0 {
+			if info, err := d.Info(); err == nil && info.Size() < opts.MinSize {
+				return nil
+			}
+		}
+		paths = append(paths, path)
+		return nil
+	})
+
+	return paths, sidecars, err
+}
+
+// Group image files so each group is geocoded once and moved together.
+// Without pairing every image is its own group; with pairing, files that
+// share a directory and base name (IMG_1234.JPG + IMG_1234.CR2) end up in
+// the same group. With PairLive the still and the video of a Live Photo
+// (IMG_1234.HEIC + IMG_1234.MOV) are grouped the same way.
+func groupImages(paths []string, opts Options) [][]string {
+	var groups [][]string
+	index := make(map[string]int)
+
+	for _, imagePath := range paths {
+		if !HasExt(imagePath, ImageExts) && !HasExt(imagePath, VideoExts) && !HasExt(imagePath, opts.RawExts) {
+			continue
+		}
+
+		if !opts.PairRaw && !opts.PairLive {
+			groups = append(groups, []string{imagePath})
+			continue
+		}
+
+		base := strings.TrimSuffix(imagePath, filepath.Ext(imagePath))
+		i, found := index[base]
+		if found && (opts.PairRaw || livePair(groups[i], imagePath)) {
+			groups[i] = append(groups[i], imagePath)
+			continue
+		}
+		if !found {
+			index[base] = len(groups)
+		}
+		groups = append(groups, []string{imagePath})
+	}
+
+	return groups
+}
@@ -0,0 +1,131 @@
+package sorter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Moments a hook runs at
+const (
+	HookPre  = "pre"  // before the image is placed
+	HookPost = "post" // after the image and its sidecars are placed
+)
+
+// What a hook is told about an image being placed. Path is the
+// destination and Source the file being sorted, both absolute.
+type HookEvent struct {
+	Event    string   `json:"event"`              // HookPre or HookPost
+	Mode     string   `json:"mode"`               // Mode* the image is placed in
+	Folder   string   `json:"folder"`             // destination folder, relative to Options.DestRoot
+	Sidecars []string `json:"sidecars,omitempty"` // absolute destinations of the sidecars placed with the image
+	CatalogEntry
+}
+
+// Longest a hook command may run, see CommandHook
+const DefaultHookTimeout = time.Minute
+
+// Longest to wait for the output of a hook after it was killed
+const hookWaitDelay = time.Second
+
+// Return a hook that runs command with the shell, once per image. The
+// event is written to its standard input as JSON and, for scripts that
+// would rather not parse it, set in PIC_SORTER_* environment variables:
+// EVENT, MODE, SRC, DST, FOLDER, SHA256, TIME, LAT and LON (with GPS
+// data), MAKE, MODEL, COUNTRY, STATE, COUNTY, CITY and PLACE. A command that
+// exits with an error, or runs longer than timeout, fails the hook; 0
+// means DefaultHookTimeout.
+func CommandHook(command string, timeout time.Duration) func(HookEvent) error {
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	return func(event HookEvent) error {
+		input, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", command)
+		}
+		// Programs started by the shell share its output pipe, so waiting
+		// for the shell alone would wait for them too
+		killProcessGroup(cmd)
+		cmd.WaitDelay = hookWaitDelay
+		cmd.Stdin = bytes.NewReader(append(input, '\n'))
+		cmd.Env = append(os.Environ(), hookEnv(event)...)
+		var output bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &output, &output
+
+		err = cmd.Run()
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		if err != nil {
+			if out := strings.TrimSpace(output.String()); out != "" {
+				return fmt.Errorf("%s hook: %w: %s", event.Event, err, out)
+			}
+			return fmt.Errorf("%s hook: %w", event.Event, err)
+		}
+		return nil
+	}
+}
+
+// Return the PIC_SORTER_* variables of an event
+func hookEnv(event HookEvent) []string {
+	vars := map[string]string{
+		"EVENT":   event.Event,
+		"MODE":    event.Mode,
+		"SRC":     event.Source,
+		"DST":     event.Path,
+		"FOLDER":  event.Folder,
+		"SHA256":  event.SHA256,
+		"TIME":    event.Time.Format(time.RFC3339),
+		"MAKE":    event.Make,
+		"MODEL":   event.Model,
+		"COUNTRY": event.Country,
+		"STATE":   event.State,
+		"COUNTY":  event.County,
+		"CITY":    event.City,
+		"PLACE":   event.Place,
+	}
+	if event.HasGPS {
+		vars["LAT"] = strconv.FormatFloat(event.Lat, 'f', -1, 64)
+		vars["LON"] = strconv.FormatFloat(event.Lon, 'f', -1, 64)
+	}
+	env := make([]string, 0, len(vars))
+	for name, value := range vars {
+		env = append(env, "PIC_SORTER_"+name+"="+value)
+	}
+	return env
+}
+
+// Return the event of an image about to be or just placed
+func hookEvent(event string, move plannedMove, files []Move, sum, mode, folder string) HookEvent {
+	if mode == "" {
+		mode = ModeMove
+	}
+	hook := HookEvent{
+		Event:        event,
+		Mode:         mode,
+		Folder:       folder,
+		CatalogEntry: catalogEntry(move.imagePath, files[0].Dst, sum, move.info, move.location),
+	}
+	hook.Burst = move.burst
+	for _, file := range files[1:] {
+		hook.Sidecars = append(hook.Sidecars, absPath(file.Dst))
+	}
+	return hook
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package sorter
+
+import "os/exec"
+
+// Kill only the shell when the context of cmd ends; Cmd.WaitDelay stops
+// waiting for the programs it started
+func killProcessGroup(cmd *exec.Cmd) {}
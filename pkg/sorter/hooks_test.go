@@ -0,0 +1,39 @@
+package sorter
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandHookTimeoutKillsChildren(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	hook := CommandHook("sleep 5 & sleep 5", 500*time.Millisecond)
+
+	start := time.Now()
+	err := hook(HookEvent{Event: HookPre})
+	elapsed := time.Since(start)
+
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("hook error = %v, want a timeout", err)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("hook returned after %s, want about 500ms", elapsed)
+	}
+}
+
+func TestCommandHookFails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	if err := CommandHook("read line; test -n \"$line\"", 0)(HookEvent{Event: HookPost}); err != nil {
+		t.Errorf("hook reading its event failed: %v", err)
+	}
+	err := CommandHook("echo nope; exit 3", 0)(HookEvent{Event: HookPost})
+	if err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("hook error = %v, want the exit status and output", err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package sorter
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Run cmd in a process group of its own and kill the whole group when its
+// context ends, so programs the shell started do not outlive a timeout
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,200 @@
+package sorter
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"pic-sorter/pkg/storage"
+)
+
+// One move recorded in a journal
+type JournalEntry struct {
+	Src    string    `json:"src"`
+	Dst    string    `json:"dst"`
+	SHA256 string    `json:"sha256"`
+	Size   int64     `json:"size,omitempty"` // size of files transferred between storages, which have no SHA256
+	Mode   string    `json:"mode,omitempty"` // ModeCopy or a link mode if Src was left in place, "created" for new files
+	Time   time.Time `json:"time"`
+
+	ModTime *time.Time `json:"mtime,omitempty"` // modification time Src had before the run changed it, restored by undo
+}
+
+// Mode of journal entries for files a run created, such as XMP sidecars;
+// their Src is empty and undo deletes them
+const createdMode = "created"
+
+// Append-only record of the moves of a run, written as JSON lines so
+// that everything moved before a crash can still be undone
+type Journal struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// Default path of the journal of a run started at t
+func DefaultJournalPath(destRoot string, t time.Time) string {
+	return filepath.Join(destRoot, stateDir, "journal-"+t.Format("20060102-150405")+".jsonl")
+}
+
+// Create a journal at path, creating its directory if needed
+func CreateJournal(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Append an entry and flush it to disk
+func (j *Journal) Record(entry JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.enc.Encode(entry); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Path of the journal file
+func (j *Journal) Path() string {
+	return j.file.Name()
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// Read all entries of a journal
+func ReadJournal(path string) ([]JournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Return the hex SHA-256 of a file's contents
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Move every journaled file back to where it came from, newest first;
+// copies whose original is still in place are simply removed. Files whose
+// contents changed since the move, or whose original path is taken again,
+// are left alone unless force is set for the checksum. The returned
+// summary lists restored files in Moves and skipped ones in Errors. A
+// nil logger discards messages.
+func Undo(entries []JournalEntry, force bool, logger *slog.Logger) Summary {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	var summary Summary
+	fail := func(entry JournalEntry, err error) {
+		logger.Warn("not restoring file", "file", entry.Dst, "reason", err)
+		summary.Failed++
+		summary.Errors = append(summary.Errors, FileError{Path: entry.Dst, Err: err})
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+
+		if storage.IsURL(entry.Src) || storage.IsURL(entry.Dst) {
+			done, err := undoTransfer(entry, force)
+			if err != nil {
+				fail(entry, err)
+				continue
+			}
+			logger.Info(done, "file", entry.Src)
+			summary.Moved++
+			summary.Moves = append(summary.Moves, Move{Src: entry.Dst, Dst: entry.Src})
+			continue
+		}
+
+		sum, err := fileSHA256(entry.Dst)
+		if err != nil {
+			fail(entry, err)
+			continue
+		}
+		if sum != entry.SHA256 && !force {
+			fail(entry, errors.New("contents changed since the move"))
+			continue
+		}
+		if entry.Mode == createdMode {
+			if err := os.Remove(entry.Dst); err != nil {
+				fail(entry, err)
+				continue
+			}
+			logger.Info("removed created file", "file", entry.Dst)
+			summary.Moved++
+			continue
+		}
+		_, err = os.Lstat(entry.Src)
+		if keepsOriginals(entry.Mode) && err == nil {
+			if err := os.Remove(entry.Dst); err != nil {
+				fail(entry, err)
+				continue
+			}
+			logger.Info("removed copy", "file", entry.Dst)
+			summary.Moved++
+			summary.Moves = append(summary.Moves, Move{Src: entry.Dst, Dst: entry.Src})
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			fail(entry, fmt.Errorf("%s already exists", entry.Src))
+			continue
+		}
+
+		if err := (RenameMover{}).Move(entry.Dst, entry.Src); err != nil {
+			fail(entry, err)
+			continue
+		}
+		if entry.ModTime != nil {
+			if info, err := os.Stat(entry.Src); err == nil {
+				os.Chtimes(entry.Src, accessTime(info), *entry.ModTime)
+			}
+		}
+		logger.Info("restored file", "file", entry.Src)
+		summary.Moved++
+		summary.Moves = append(summary.Moves, Move{Src: entry.Dst, Dst: entry.Src})
+	}
+
+	return summary
+}
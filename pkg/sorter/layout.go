@@ -0,0 +1,110 @@
+package sorter
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"pic-sorter/pkg/exifinfo"
+	"pic-sorter/pkg/geocode"
+)
+
+// Values available to a destination layout template, e.g.
+// "{{.Country}}/{{.Year}}/{{.City}}"
+type LayoutFields struct {
+	Country       string
+	State         string
+	StateDistrict string
+	County        string
+	City          string
+	Place         string // name of the geofence the photo was taken in
+
+	Date  time.Time // capture time, or modification time if EXIF has none
+	Year  string    // "2023"
+	Month string    // "07"
+	Day   string    // "01"
+
+	Make        string // camera maker
+	Model       string // camera model
+	CameraModel string // folder name of the camera, e.g. "Canon EOS R5" or "iPhone 14"
+}
+
+// Names of the LayoutFields that need a geocoded location
+var locationLayoutFields = []string{".Country", ".State", ".StateDistrict", ".County", ".City"}
+
+// Parsed destination layout
+type layout struct {
+	tmpl          *template.Template
+	needsLocation bool
+}
+
+// Parse a layout template; an empty text returns a nil layout
+func parseLayout(text string) (*layout, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("layout").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid layout: %w", err)
+	}
+	// Catch unknown fields now rather than once per file
+	if err := tmpl.Execute(io.Discard, LayoutFields{}); err != nil {
+		return nil, fmt.Errorf("invalid layout: %w", err)
+	}
+
+	l := &layout{tmpl: tmpl}
+	for _, field := range locationLayoutFields {
+		if strings.Contains(text, field) {
+			l.needsLocation = true
+		}
+	}
+	return l, nil
+}
+
+// Build the layout fields from image metadata and, if known, its location.
+// Empty values become placeholder so every folder level has a name. The
+// rendered layout is split on "/", so path separators in values are
+// replaced and values of only dots, such as "..", become placeholder too.
+func layoutFields(info exifinfo.Info, location geocode.Location, placeholder string) LayoutFields {
+	or := func(value string) string {
+		if strings.Trim(value, ". ") == "" {
+			return placeholder
+		}
+		return levelSeparators.Replace(value)
+	}
+	return LayoutFields{
+		Country:       or(location["country"]),
+		State:         or(location["state"]),
+		StateDistrict: or(location["state_district"]),
+		County:        or(location["county"]),
+		City:          or(location["city"]),
+		Place:         or(location[geocode.PlaceField]),
+
+		Date:  info.Time,
+		Year:  info.Time.Format("2006"),
+		Month: info.Time.Format("01"),
+		Day:   info.Time.Format("02"),
+
+		Make:        or(info.Make),
+		Model:       or(info.Model),
+		CameraModel: cameraName(info.Make, info.Model, placeholder),
+	}
+}
+
+// Render the layout into folder levels, dropping empty ones
+func (l *layout) levels(fields LayoutFields) ([]string, error) {
+	var b strings.Builder
+	if err := l.tmpl.Execute(&b, fields); err != nil {
+		return nil, err
+	}
+
+	var levels []string
+	for _, level := range strings.Split(b.String(), "/") {
+		if level = strings.TrimSpace(level); level != "" {
+			levels = append(levels, level)
+		}
+	}
+	return levels, nil
+}
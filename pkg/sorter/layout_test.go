@@ -0,0 +1,61 @@
+package sorter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"pic-sorter/pkg/exifinfo"
+	"pic-sorter/pkg/geocode"
+)
+
+func TestLayoutLevels(t *testing.T) {
+	info := exifinfo.Info{Time: time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC), Make: "Canon", Model: "EOS R5"}
+
+	tests := []struct {
+		name     string
+		layout   string
+		location geocode.Location
+		want     string
+	}{
+		{
+			name:     "fields",
+			layout:   "{{.Country}}/{{.Year}}/{{.City}}",
+			location: geocode.Location{"country": "France", "city": "Paris"},
+			want:     "France|2023|Paris",
+		},
+		{
+			name:     "missing fields get the placeholder",
+			layout:   "{{.Country}}/{{.State}}/{{.CameraModel}}",
+			location: geocode.Location{"country": "France"},
+			want:     "France|Unknown|Canon EOS R5",
+		},
+		{
+			name:     "slashes in values add no levels",
+			layout:   "{{.Country}}/{{.State}}/{{.City}}",
+			location: geocode.Location{"country": "Italia", "state": "Trentino-Alto Adige/Südtirol", "city": `Nord\Sud`},
+			want:     `Italia|Trentino-Alto Adige-Südtirol|Nord-Sud`,
+		},
+		{
+			name:     "values cannot leave the tree",
+			layout:   "{{.Country}}/{{.State}}/{{.City}}",
+			location: geocode.Location{"country": "..", "state": "../../etc", "city": "."},
+			want:     "Unknown|..-..-etc|Unknown",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, err := parseLayout(test.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			levels, err := l.levels(layoutFields(info, test.location, geocode.DefaultPlaceholder))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(levels, "|"); got != test.want {
+				t.Errorf("levels = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package sorter
+
+// Keep the first groups holding at most maxFiles images. Groups are never
+// split, so the first one is kept even if it alone holds more. Returns
+// the kept groups and the number of images left out.
+func limitGroups(groups [][]string, maxFiles int) ([][]string, int) {
+	kept, count := 0, 0
+	for kept < len(groups) {
+		n := len(groups[kept])
+		if kept > 0 && count+n > maxFiles {
+			break
+		}
+		count += n
+		kept++
+	}
+	deferred := 0
+	for _, group := range groups[kept:] {
+		deferred += len(group)
+	}
+	return groups[:kept], deferred
+}
+
+// Return the images of groups and their sidecars
+func groupedFiles(groups [][]string, sidecars map[string][]string) (images, sidecarPaths []string) {
+	for _, group := range groups {
+		for _, imagePath := range group {
+			images = append(images, imagePath)
+			sidecarPaths = append(sidecarPaths, sidecars[imagePath]...)
+		}
+	}
+	return images, sidecarPaths
+}
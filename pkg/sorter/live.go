@@ -0,0 +1,97 @@
+package sorter
+
+import (
+	"path/filepath"
+
+	"pic-sorter/pkg/exifinfo"
+)
+
+// Extensions of the stills of Live Photos
+var liveStillExts = []string{".heic", ".heif", ".jpg", ".jpeg"}
+
+// Report whether adding imagePath to group makes the still and the video
+// of a Live Photo: one of them is a video and the other a still
+func livePair(group []string, imagePath string) bool {
+	files := append([]string{imagePath}, group...)
+	stills, videos := 0, 0
+	for _, file := range files {
+		switch {
+		case HasExt(file, VideoExts):
+			videos++
+		case HasExt(file, liveStillExts):
+			stills++
+		}
+	}
+	return stills == 1 && videos == 1 && len(files) == 2
+}
+
+// Join the still and the video of Live Photos whose names differ, such as
+// edited or exported copies, by the content identifier both carry. Only
+// directories holding both unpaired stills and unpaired videos are read.
+func pairContentIDs(groups [][]string) [][]string {
+	type candidates struct{ stills, videos []int }
+	byDir := make(map[string]*candidates)
+	for i, group := range groups {
+		if len(group) != 1 {
+			continue
+		}
+		dir := filepath.Dir(group[0])
+		c := byDir[dir]
+		if c == nil {
+			c = &candidates{}
+			byDir[dir] = c
+		}
+		switch {
+		case HasExt(group[0], VideoExts):
+			c.videos = append(c.videos, i)
+		case HasExt(group[0], liveStillExts):
+			c.stills = append(c.stills, i)
+		}
+	}
+
+	merged := make(map[int]bool)
+	for _, c := range byDir {
+		if len(c.stills) == 0 || len(c.videos) == 0 {
+			continue
+		}
+		stills := make(map[string]int)
+		for _, i := range c.stills {
+			if id := contentID(groups[i][0]); id != "" {
+				stills[id] = i
+			}
+		}
+		if len(stills) == 0 {
+			continue
+		}
+		for _, i := range c.videos {
+			id := contentID(groups[i][0])
+			still, found := stills[id]
+			if !found || id == "" {
+				continue
+			}
+			groups[still] = append(groups[still], groups[i][0])
+			merged[i] = true
+			delete(stills, id)
+		}
+	}
+	if len(merged) == 0 {
+		return groups
+	}
+
+	kept := groups[:0]
+	for i, group := range groups {
+		if !merged[i] {
+			kept = append(kept, group)
+		}
+	}
+	return kept
+}
+
+// Read the Live Photo content identifier of an image or video, or ""
+func contentID(path string) string {
+	info, err := exifinfo.Read(path)
+	if err != nil {
+		return ""
+	}
+	return info.ContentID
+}
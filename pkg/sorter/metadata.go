@@ -0,0 +1,52 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pic-sorter/pkg/geocode"
+	"pic-sorter/pkg/xmp"
+)
+
+// Place names of a location for the image metadata; fields the geocoder
+// did not know stay empty
+func placeOf(location geocode.Location) xmp.Place {
+	return xmp.Place{
+		Country:     location["country"],
+		CountryCode: location["country_code"],
+		State:       location["state"],
+		City:        location["city"],
+	}
+}
+
+// Write the place names of a placed image into its metadata: into the
+// file itself for JPEGs, otherwise into its XMP sidecar, which is created
+// if the image came without one. files are the placed image and its
+// sidecars. Returns the file that was written and whether it is new;
+// read-only images are left alone.
+func writePlace(files []Move, place xmp.Place) (written string, created bool, err error) {
+	image := files[0].Dst
+	info, err := os.Stat(image)
+	if err != nil {
+		return "", false, err
+	}
+	if info.Mode().Perm()&0o200 == 0 {
+		return "", false, nil
+	}
+
+	switch strings.ToLower(filepath.Ext(image)) {
+	case ".jpg", ".jpeg":
+		return image, false, xmp.WriteJPEG(image, place)
+	}
+
+	sidecar := strings.TrimSuffix(image, filepath.Ext(image)) + ".xmp"
+	for _, file := range files[1:] {
+		if strings.EqualFold(filepath.Ext(file.Dst), ".xmp") {
+			sidecar = file.Dst
+			break
+		}
+	}
+	created, err = xmp.WriteSidecar(sidecar, place)
+	return sidecar, created, err
+}
@@ -0,0 +1,196 @@
+package sorter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// Places a file at its destination path
+type Mover interface {
+	Move(src, dst string) error
+}
+
+// Ways of placing files in the sorted tree
+const (
+	ModeMove     = "move"     // rename files into the tree
+	ModeCopy     = "copy"     // copy files, leaving the originals in place
+	ModeLink     = "link"     // symlink files into the tree, leaving the originals in place
+	ModeHardlink = "hardlink" // hard-link files into the tree; needs a single file system
+)
+
+// Report whether files placed in mode are still at their source
+func keepsOriginals(mode string) bool {
+	return mode == ModeCopy || mode == ModeLink || mode == ModeHardlink
+}
+
+// Report whether files placed in mode share their data with the source,
+// so rewriting them would change the originals
+func linksOriginals(mode string) bool {
+	return mode == ModeLink || mode == ModeHardlink
+}
+
+// Return the Mover for a mode
+func MoverFor(mode string) (Mover, error) {
+	switch mode {
+	case "", ModeMove:
+		return RenameMover{}, nil
+	case ModeCopy:
+		return CopyMover{}, nil
+	case ModeLink:
+		return SymlinkMover{}, nil
+	case ModeHardlink:
+		return HardlinkMover{}, nil
+	}
+	return nil, fmt.Errorf("unknown mode %q, want %s, %s, %s or %s", mode, ModeMove, ModeCopy, ModeLink, ModeHardlink)
+}
+
+// Mover that renames files, creating destination folders as needed. A
+// rename within a file system never rewrites the data, so there is
+// nothing to verify. Across file systems, where a rename is impossible,
+// the file is copied and verified like CopyMover does, and the original
+// is only removed once the copy's SHA-256 matches it.
+type RenameMover struct{}
+
+func (RenameMover) Move(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	err := renameExclusive(src, dst)
+	if !crossDevice(err) {
+		return err
+	}
+	if err := copyVerified(src, dst); err != nil {
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		// Leave a single copy; the original is the one still known
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// Report whether a rename failed because source and destination are on
+// different file systems
+func crossDevice(err error) bool {
+	if err == nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return errors.Is(err, syscall.Errno(17)) // ERROR_NOT_SAME_DEVICE
+	}
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// Mover that copies files and keeps the originals. The copy is written
+// to a temporary file, synced, and only renamed into place once its
+// SHA-256 matches the source. It gets the permissions and access and
+// modification times of the original, as a rename would keep them.
+type CopyMover struct{}
+
+func (CopyMover) Move(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	return copyVerified(src, dst)
+}
+
+// Copy src to dst through a temporary file and verify the result
+func copyVerified(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed into place
+
+	// Hash the source while copying it
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	os.Chmod(tmp.Name(), info.Mode().Perm())
+	// The modification time dates images without EXIF
+	os.Chtimes(tmp.Name(), accessTime(info), info.ModTime())
+
+	// Re-read what actually reached the disk
+	copied, err := fileSHA256(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if want := hex.EncodeToString(h.Sum(nil)); copied != want {
+		return fmt.Errorf("checksum mismatch copying %s: got %s, want %s", src, copied, want)
+	}
+
+	return renameExclusive(tmp.Name(), dst)
+}
+
+// Rename src to dst unless a file is there, reporting a *CollisionError
+// then. The name was reserved, but another process or run may have taken
+// it since, so a hard link that fails on an existing file comes first;
+// file systems without hard links fall back to checking before renaming.
+func renameExclusive(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		if err := os.Remove(src); err != nil {
+			os.Remove(dst)
+			return err
+		}
+		return nil
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return &CollisionError{Path: dst}
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return &CollisionError{Path: dst}
+	}
+	return os.Rename(src, dst)
+}
+
+// Mover that creates a symbolic link to the absolute path of the source,
+// so the tree stays valid wherever it is read from
+type SymlinkMover struct{}
+
+func (SymlinkMover) Move(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Symlink(absPath(src), dst)
+}
+
+// Mover that creates a hard link to the source. Both must be on the same
+// file system.
+type HardlinkMover struct{}
+
+func (HardlinkMover) Move(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Link(src, dst)
+}
@@ -0,0 +1,33 @@
+package sorter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoversNeverReplaceFiles(t *testing.T) {
+	for _, mover := range []Mover{RenameMover{}, CopyMover{}} {
+		dir := t.TempDir()
+		src, dst := filepath.Join(dir, "src.jpg"), filepath.Join(dir, "dst.jpg")
+		if err := os.WriteFile(src, []byte("new"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Appeared at the destination after its name was reserved
+		if err := os.WriteFile(dst, []byte("existing"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		var collision *CollisionError
+		if err := mover.Move(src, dst); !errors.As(err, &collision) {
+			t.Errorf("%T: error = %v, want a collision", mover, err)
+		}
+		if data, _ := os.ReadFile(dst); string(data) != "existing" {
+			t.Errorf("%T: destination holds %q, want it untouched", mover, data)
+		}
+		if data, _ := os.ReadFile(src); string(data) != "new" {
+			t.Errorf("%T: source holds %q, want it kept", mover, data)
+		}
+	}
+}
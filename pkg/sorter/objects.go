@@ -0,0 +1,460 @@
+package sorter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"pic-sorter/pkg/geocode"
+	"pic-sorter/pkg/storage"
+)
+
+// Bytes read from the start and from the end of a stored photo for its
+// metadata: EXIF sits at the start, the MP4 index at either end
+const stagedBytes = 1 << 20
+
+// State shared by the workers placing files between storages
+type transfer struct {
+	src, dst storage.Storage
+	destRoot string // key of the sorted tree in dst
+	objects  map[string]storage.Object
+	names    *destNames
+	prog     *progress
+}
+
+// Sort the photos at src into Options.DestRoot where either may be a
+// storage URL such as s3://bucket/photos, see storage.Open. Files are
+// copied between the storages directly, on the server within one
+// bucket; only the first and last MiB of a photo are downloaded to read
+// its metadata. Only ModeMove and ModeCopy are supported; duplicates are
+// not detected and the State and Catalog are not used.
+func (s *Sorter) RunStorage(src string) (summary Summary, err error) {
+	opts := s.Options
+	layout, err := checkOptions(opts)
+	if err != nil {
+		return Summary{}, err
+	}
+	if opts.Mode != "" && opts.Mode != ModeMove && opts.Mode != ModeCopy {
+		return Summary{}, fmt.Errorf("%s mode needs local files", opts.Mode)
+	}
+	if opts.Bursts != "" {
+		return Summary{}, errors.New("grouping bursts needs local files")
+	}
+	if opts.WriteMetadata && !opts.DryRun {
+		return Summary{}, errors.New("cannot write metadata to stored photos")
+	}
+	if opts.MaxFiles > 0 || opts.MaxGeocodeRequests > 0 || opts.MaxDuration > 0 {
+		return Summary{}, errors.New("run limits are not supported for storage URLs")
+	}
+	if opts.AutoRotate && !opts.DryRun {
+		return Summary{}, errors.New("cannot rotate stored photos")
+	}
+	if opts.WriteTimeShift && !opts.DryRun {
+		return Summary{}, errors.New("cannot write shifted times to stored photos")
+	}
+	if opts.TouchExifDate && !opts.DryRun {
+		return Summary{}, errors.New("cannot set modification times of stored photos")
+	}
+	if len(opts.ThumbSizes) > 0 && !opts.DryRun {
+		return Summary{}, errors.New("thumbnails need a local destination")
+	}
+	if opts.Quarantine != "" || opts.Strict {
+		return Summary{}, errors.New("checking for damaged files needs local files")
+	}
+	if s.PreMove != nil || s.PostMove != nil {
+		return Summary{}, errors.New("move hooks need local files")
+	}
+	srcStore, srcRoot, err := storage.Open(src)
+	if err != nil {
+		return Summary{}, err
+	}
+	dstStore, destRoot, err := storage.Open(opts.DestRoot)
+	if err != nil {
+		return Summary{}, err
+	}
+	staging, err := os.MkdirTemp("", "pic-sorter-")
+	if err != nil {
+		return Summary{}, err
+	}
+	defer os.RemoveAll(staging)
+
+	prog := newProgress(s.Events)
+	defer s.startOutput(prog)()
+	geocoder, cache := s.runGeocoder()
+	statsBefore := cache.Stats()
+	defer func() {
+		summary = prog.snapshot()
+		summary.addCacheStats(statsBefore, cache.Stats())
+	}()
+
+	objects, err := srcStore.List(srcRoot)
+	if err != nil {
+		return Summary{}, err
+	}
+	// The sorted tree may be inside the source
+	var skip string
+	if storage.Same(srcStore, dstStore) {
+		skip = destRoot
+	}
+	keys, sidecarKeys := findObjects(objects, srcRoot, skip, opts)
+	place := &transfer{src: srcStore, dst: dstStore, destRoot: destRoot, objects: make(map[string]storage.Object), names: newDestNames(), prog: prog}
+	for _, object := range objects {
+		place.objects[object.Key] = object
+	}
+	place.names.exists = func(key string) bool {
+		_, err := dstStore.Stat(key)
+		return !errors.Is(err, fs.ErrNotExist)
+	}
+
+	groups := groupImages(keys, opts)
+	sidecars := matchSidecars(keys, sidecarKeys)
+	total := 0
+	for _, group := range groups {
+		total += len(group)
+	}
+	prog.start(total)
+	defer prog.done()
+
+	var (
+		dims     = dimensions(opts.By)
+		adaptive = opts.MinPerLevel > 0 || (layout == nil && (dims[0] == ByTrip || dims[0] == ByEvent))
+		moves    []plannedMove
+		unsorted []plannedMove
+		movesMu  sync.Mutex
+		done     = make(chan struct{})
+		abortErr error
+		abort    sync.Once
+	)
+
+	parallel(groups, opts.Workers, done, func(group []string) {
+		// Reports name files by URL
+		urls := make([]string, len(group))
+		for i, key := range group {
+			urls[i] = srcStore.URL(key)
+		}
+		url := urls[0]
+		staged, err := stageGroup(srcStore, place.objects, group, staging)
+		if err != nil {
+			s.logger.Error("cannot read file", "file", url, "reason", err)
+			failGroup(urls, err, prog)
+			return
+		}
+		result := resolveGroup(staged, geocoder, opts, layout, s.Track, s.Timezones)
+		os.RemoveAll(filepath.Dir(staged[0]))
+
+		if errors.Is(result.err, ErrNoGPS) && s.Unresolved != nil {
+			if levels := s.Unresolved(url, result.info); len(levels) > 0 {
+				result.levels, result.err = levels, nil
+			}
+		}
+		if errors.Is(result.err, geocode.ErrBanned) {
+			failGroup(urls, result.err, prog)
+			abort.Do(func() {
+				abortErr = fmt.Errorf("%s: %w", path.Base(group[0]), result.err)
+				close(done)
+			})
+			return
+		}
+		toUnsorted := false
+		if result.reason != "" && opts.Unsorted != "" {
+			s.logger.Warn("cannot sort file, moving it to the unsorted folder", "file", url, "reason", result.err)
+			result.levels, result.err, toUnsorted = []string{opts.Unsorted, result.reason}, nil, true
+		}
+		switch {
+		case errors.Is(result.err, ErrFiltered):
+			s.logger.Debug("leaving file outside the selection", "file", url)
+			for _, url := range urls {
+				prog.skipped(url, result.err)
+			}
+			return
+		case errors.Is(result.err, ErrScreenshot):
+			s.logger.Info("skipping screenshot", "file", url)
+			for _, url := range urls {
+				prog.skipped(url, result.err)
+			}
+			return
+		case errors.Is(result.err, ErrNoGPS):
+			s.logger.Warn("no GPS data", "file", url)
+			for _, url := range urls {
+				prog.skipped(url, result.err)
+			}
+			return
+		case result.err != nil:
+			s.logger.Error("cannot resolve destination", "file", url, "reason", result.err)
+			failGroup(urls, result.err, prog)
+			return
+		}
+
+		for _, key := range group {
+			move := plannedMove{
+				imagePath: key,
+				levels:    result.levels,
+				sidecars:  sidecars[key],
+				info:      result.info,
+				location:  result.location,
+				unsorted:  toUnsorted,
+			}
+			if adaptive {
+				movesMu.Lock()
+				if toUnsorted {
+					unsorted = append(unsorted, move)
+				} else {
+					moves = append(moves, move)
+				}
+				movesMu.Unlock()
+				continue
+			}
+			s.transferMove(move, place)
+		}
+	})
+	if abortErr != nil {
+		return Summary{}, abortErr
+	}
+
+	if adaptive {
+		planTree(moves, opts, layout)
+		parallel(append(moves, unsorted...), opts.Workers, done, func(move plannedMove) {
+			s.transferMove(move, place)
+		})
+	}
+	return Summary{}, nil
+}
+
+// Pick the objects below root that findFiles would pick from a
+// directory: images in keys, sidecars in sidecars. Objects below skip,
+// unless it is "", are left out.
+func findObjects(objects []storage.Object, root, skip string, opts Options) (keys, sidecars []string) {
+	for _, object := range objects {
+		if skip != "" && (object.Key == skip || strings.HasPrefix(object.Key, skip+"/")) {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(object.Key, root), "/")
+		dir := path.Dir(rel)
+		if dir != "." && !opts.Recursive {
+			continue
+		}
+		// Excluding a folder excludes everything in it
+		excluded := matchAny(opts.Exclude, rel)
+		for ; dir != "." && !excluded; dir = path.Dir(dir) {
+			excluded = matchAny(opts.Exclude, dir)
+		}
+		if excluded {
+			continue
+		}
+		if HasExt(rel, opts.SidecarExts) {
+			sidecars = append(sidecars, object.Key)
+			continue
+		}
+		if len(opts.Include) > 0 && !matchAny(opts.Include, rel) {
+			continue
+		}
+		if len(opts.Exts) > 0 && !HasExt(rel, opts.Exts) {
+			continue
+		}
+		if object.Size < opts.MinSize {
+			continue
+		}
+		keys = append(keys, object.Key)
+	}
+	return keys, sidecars
+}
+
+// Download enough of each object of a group into a new folder under dir
+// to read its metadata, and return the local paths. The files keep the
+// names, sizes and modification times of the objects; the bytes that are
+// not needed are left as holes.
+func stageGroup(store storage.Storage, objects map[string]storage.Object, group []string, dir string) ([]string, error) {
+	dir, err := os.MkdirTemp(dir, "group-")
+	if err != nil {
+		return nil, err
+	}
+	var staged []string
+	for _, key := range group {
+		local := filepath.Join(dir, path.Base(key))
+		if err := stageObject(store, objects[key], local); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		staged = append(staged, local)
+	}
+	return staged, nil
+}
+
+// Write the start and end of an object to local
+func stageObject(store storage.Storage, object storage.Object, local string) error {
+	file, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	ranges := [][2]int64{{0, object.Size}}
+	if object.Size > 2*stagedBytes {
+		ranges = [][2]int64{{0, stagedBytes}, {object.Size - stagedBytes, stagedBytes}}
+	}
+	for _, r := range ranges {
+		if err = copyRange(file, store, object.Key, r[0], r[1]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = file.Truncate(object.Size)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(local, time.Now(), object.ModTime)
+}
+
+// Copy length bytes at offset of an object to the same offset of file
+func copyRange(file *os.File, store storage.Storage, key string, offset, length int64) error {
+	r, err := store.OpenRange(key, offset, length)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	return err
+}
+
+// Copy an image and its sidecars to their destination and delete the
+// originals in move mode. A taken name is handled per OnCollision, with
+// the object's ETag standing in for its checksum; nothing is ever
+// overwritten. If a sidecar cannot be copied, the copies already made
+// are deleted so the image never ends up separated from its sidecars.
+func (s *Sorter) transferMove(move plannedMove, t *transfer) {
+	opts := s.Options
+	prog := t.prog
+	url := t.src.URL(move.imagePath)
+	destination := strings.Join(move.levels, "/")
+
+	image := t.objects[move.imagePath]
+	key := filepath.ToSlash(opts.Sanitize.join(t.destRoot, move.levels, path.Base(move.imagePath)))
+	dst, err := t.names.reserve(key, opts.OnCollision, image.ETag)
+	if err != nil {
+		if opts.OnCollision == CollisionSkip {
+			s.logger.Info("skipping file", "file", url, "reason", err)
+			prog.skipped(url, err)
+			return
+		}
+		s.logger.Error("cannot move file", "file", url, "reason", err)
+		prog.fileFailed(url, err)
+		return
+	}
+
+	files := []Move{{Src: move.imagePath, Dst: dst}}
+	dsts := []string{dst}
+	size := image.Size
+	fail := func(err error) {
+		t.names.release(dsts...)
+		s.logger.Error("cannot move file", "file", url, "reason", err)
+		prog.fileFailed(url, err)
+	}
+	for _, sidecar := range move.sidecars {
+		sidecarDst, err := t.names.reserve(path.Join(path.Dir(dst), path.Base(sidecarDest(move.imagePath, dst, sidecar))), CollisionFail, "")
+		if err != nil {
+			fail(err)
+			return
+		}
+		files = append(files, Move{Src: sidecar, Dst: sidecarDst})
+		dsts = append(dsts, sidecarDst)
+		size += t.objects[sidecar].Size
+	}
+	// Summaries, plans and the journal name files by URL
+	urls := make([]Move, len(files))
+	for i, file := range files {
+		urls[i] = Move{Src: t.src.URL(file.Src), Dst: t.dst.URL(file.Dst)}
+	}
+
+	if opts.DryRun {
+		s.logger.Info("would move", "file", url, "dst", urls[0].Dst)
+		prog.fileProcessed(urls, destination, move.location["country"], size)
+		return
+	}
+
+	verb := "moving"
+	if opts.Mode == ModeCopy {
+		verb = "copying"
+	}
+	s.logger.Info(verb, "file", url, "destination", destination)
+	for i, file := range files {
+		if err := storage.Transfer(t.src, file.Src, t.dst, file.Dst, t.objects[file.Src].Size); err != nil {
+			for _, placed := range files[:i] {
+				if deleteErr := t.dst.Delete(placed.Dst); deleteErr != nil {
+					s.logger.Error("cannot delete copy", "file", t.dst.URL(placed.Dst), "reason", deleteErr)
+				}
+			}
+			fail(err)
+			return
+		}
+	}
+	// Originals go only once every file has its copy
+	if !keepsOriginals(opts.Mode) {
+		for _, file := range files {
+			if err := t.src.Delete(file.Src); err != nil {
+				s.logger.Error("cannot delete original", "file", t.src.URL(file.Src), "reason", err)
+			}
+		}
+	}
+
+	if s.Journal != nil {
+		for i, file := range files {
+			entry := JournalEntry{Src: urls[i].Src, Dst: urls[i].Dst, Size: t.objects[file.Src].Size, Mode: opts.Mode, Time: time.Now()}
+			if err := s.Journal.Record(entry); err != nil {
+				s.logger.Error("cannot write journal", "file", urls[i].Src, "reason", err)
+			}
+		}
+	}
+	if move.unsorted {
+		prog.unsorted()
+	}
+	prog.fileProcessed(urls, destination, move.location["country"], size)
+}
+
+// Take back a journaled transfer involving a storage URL: delete a copy
+// whose original is still there, or transfer the file back. Unless force
+// is set, the file must still have its journaled size. Returns what was
+// done, for the log.
+func undoTransfer(entry JournalEntry, force bool) (string, error) {
+	srcStore, srcKey, err := storage.Open(entry.Src)
+	if err != nil {
+		return "", err
+	}
+	dstStore, dstKey, err := storage.Open(entry.Dst)
+	if err != nil {
+		return "", err
+	}
+	object, err := dstStore.Stat(dstKey)
+	if err != nil {
+		return "", err
+	}
+	if object.Size != entry.Size && !force {
+		return "", errors.New("contents changed since the move")
+	}
+
+	_, err = srcStore.Stat(srcKey)
+	if err == nil {
+		if !keepsOriginals(entry.Mode) {
+			return "", fmt.Errorf("%s already exists", entry.Src)
+		}
+		return "removed copy", dstStore.Delete(dstKey)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if err := storage.Transfer(dstStore, dstKey, srcStore, srcKey, object.Size); err != nil {
+		return "", err
+	}
+	return "restored file", dstStore.Delete(dstKey)
+}
@@ -0,0 +1,63 @@
+package sorter
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"pic-sorter/pkg/storage/s3test"
+)
+
+func TestRunStorageAndUndo(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	server.Setenv(t)
+	server.PageSize = 1
+
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	originals := []string{"photos/inbox/a.jpg", "photos/inbox/b.jpg"}
+	for _, name := range originals {
+		server.PutObject(name, img.Bytes())
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := CreateJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(nil, Options{DestRoot: "s3://photos/sorted", Mode: ModeMove, Unsorted: "_unsorted", Workers: 1})
+	s.Logger, s.Journal = logger, journal
+	summary, err := s.RunStorage("s3://photos/inbox")
+	journal.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Moved != 2 || len(summary.Errors) > 0 {
+		t.Fatalf("moved %d files with errors %v, want 2", summary.Moved, summary.Errors)
+	}
+	sorted := []string{"photos/sorted/_unsorted/no-gps/a.jpg", "photos/sorted/_unsorted/no-gps/b.jpg"}
+	if got := server.Names(); !reflect.DeepEqual(got, sorted) {
+		t.Fatalf("objects after the run = %v, want %v", got, sorted)
+	}
+
+	entries, err := ReadJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary = Undo(entries, false, logger)
+	if summary.Moved != 2 || len(summary.Errors) > 0 {
+		t.Fatalf("undid %d moves with errors %v, want 2", summary.Moved, summary.Errors)
+	}
+	if got := server.Names(); !reflect.DeepEqual(got, originals) {
+		t.Errorf("objects after undo = %v, want %v", got, originals)
+	}
+}
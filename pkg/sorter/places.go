@@ -0,0 +1,88 @@
+package sorter
+
+import (
+	"fmt"
+	"strings"
+
+	"pic-sorter/pkg/geocode"
+)
+
+// Spellings of country folders, see Options.CountryFormat
+const (
+	CountryName     = "name"     // common English short name, e.g. "United Kingdom"
+	CountryOfficial = "official" // official English name, e.g. "United Kingdom of Great Britain and Northern Ireland"
+	CountryISO2     = "iso2"     // ISO 3166-1 alpha-2 code, e.g. "GB"
+	CountryISO3     = "iso3"     // ISO 3166-1 alpha-3 code, e.g. "GBR"
+)
+
+// Check that format is "" or one of the Country* formats
+func checkCountryFormat(format string) error {
+	switch format {
+	case "", CountryName, CountryOfficial, CountryISO2, CountryISO3:
+		return nil
+	}
+	return fmt.Errorf("unknown country format %q, want %s, %s, %s or %s",
+		format, CountryName, CountryOfficial, CountryISO2, CountryISO3)
+}
+
+// Check that none of the city fields is empty. Any other name is allowed,
+// since providers differ in the address fields they return.
+func checkCityFields(fields []string) error {
+	for _, field := range fields {
+		if strings.TrimSpace(field) == "" {
+			return fmt.Errorf("empty city field in %q", strings.Join(fields, ","))
+		}
+	}
+	return nil
+}
+
+// Address fields tried for the city when Options.CityFields is empty.
+// Nominatim calls smaller places a town or village rather than a city.
+var defaultCityFields = []string{"city", "town", "village"}
+
+// Return location with the names Options.CountryFormat and
+// Options.CityFields ask for. The country is looked up by the
+// "country_code" the providers return; answers without one, such as
+// those cached by older versions, keep the provider's name. The city is
+// the first of the city fields the answer has, or empty if it has none;
+// providers other than Nominatim have nothing but "city" to offer.
+// location may be shared with the geocode cache, so it is copied before
+// any change.
+func preferredNames(location geocode.Location, opts Options) geocode.Location {
+	country := location["country"]
+	if opts.CountryFormat != "" {
+		if c, ok := geocode.CountryByCode(location["country_code"]); ok {
+			switch opts.CountryFormat {
+			case CountryName:
+				country = c.Name
+			case CountryOfficial:
+				country = c.Official
+			case CountryISO2:
+				country = c.Alpha2
+			case CountryISO3:
+				country = c.Alpha3
+			}
+		}
+	}
+	cityFields := opts.CityFields
+	if len(cityFields) == 0 {
+		cityFields = defaultCityFields
+	}
+	city := ""
+	for _, field := range cityFields {
+		if value := location[field]; value != "" {
+			city = value
+			break
+		}
+	}
+	if country == location["country"] && city == location["city"] {
+		return location
+	}
+
+	names := make(geocode.Location, len(location))
+	for key, value := range location {
+		names[key] = value
+	}
+	names["country"], names["city"] = country, city
+	return names
+}
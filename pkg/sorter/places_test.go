@@ -0,0 +1,34 @@
+package sorter
+
+import (
+	"testing"
+
+	"pic-sorter/pkg/geocode"
+)
+
+func TestPreferredCity(t *testing.T) {
+	tests := []struct {
+		name       string
+		location   geocode.Location
+		cityFields []string
+		want       string
+	}{
+		{name: "city", location: geocode.Location{"city": "Lyon", "suburb": "Vaise"}, want: "Lyon"},
+		{name: "town by default", location: geocode.Location{"town": "Annecy"}, want: "Annecy"},
+		{name: "none", location: geocode.Location{"country": "France"}, want: ""},
+		{
+			name:       "falls through to suburb",
+			location:   geocode.Location{"town": "Annecy", "suburb": "Seynod"},
+			cityFields: []string{"city", "suburb"},
+			want:       "Seynod",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := preferredNames(test.location, Options{CityFields: test.cityFields})
+			if got["city"] != test.want {
+				t.Errorf("city = %q, want %q", got["city"], test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package sorter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Write moves to path as CSV (src,dst columns) if it ends in .csv,
+// otherwise as a JSON array
+func WritePlan(path string, moves []Move) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		w := csv.NewWriter(file)
+		w.Write([]string{"src", "dst"})
+		for _, move := range moves {
+			w.Write([]string{move.Src, move.Dst})
+		}
+		w.Flush()
+		err = w.Error()
+	} else {
+		enc := json.NewEncoder(file)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(moves)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Read a plan written by WritePlan, possibly edited since
+func ReadPlan(path string) ([]Move, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var moves []Move
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		moves, err = readPlanCSV(file)
+	} else {
+		err = json.NewDecoder(file).Decode(&moves)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("plan %s: %w", path, err)
+	}
+	for i, move := range moves {
+		if move.Src == "" || move.Dst == "" {
+			return nil, fmt.Errorf("plan %s: move %d needs both src and dst", path, i+1)
+		}
+	}
+	return moves, nil
+}
+
+// Read the rows of a CSV plan after its src,dst header
+func readPlanCSV(r io.Reader) ([]Move, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || len(rows[0]) != 2 || rows[0][0] != "src" || rows[0][1] != "dst" {
+		return nil, errors.New("want a src,dst header")
+	}
+	moves := make([]Move, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		moves = append(moves, Move{Src: row[0], Dst: row[1]})
+	}
+	return moves, nil
+}
+
+// Carry out the moves of a plan in order, placing files per mode and
+// recording them in journal if it is not nil. Nothing is placed unless
+// the destination has room for all of it. Existing files are never
+// overwritten. The returned summary lists placed files in Moves and the
+// others in Errors. A nil logger discards messages.
+func Apply(moves []Move, mode string, journal *Journal, logger *slog.Logger) (Summary, error) {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	mover, err := MoverFor(mode)
+	if err != nil {
+		return Summary{}, err
+	}
+	srcs := make([]string, len(moves))
+	for i, move := range moves {
+		srcs[i] = move.Src
+	}
+	// A plan places its files in one tree, whose file system the first
+	// destination tells
+	if len(moves) > 0 {
+		if err := checkSpace(filepath.Dir(moves[0].Dst), mode, srcs); err != nil {
+			return Summary{}, err
+		}
+	}
+	summary := Summary{Mode: mode}
+	fail := func(move Move, err error) {
+		logger.Error("cannot apply move", "file", move.Src, "dst", move.Dst, "reason", err)
+		summary.Failed++
+		summary.Errors = append(summary.Errors, FileError{Path: move.Src, Err: err})
+	}
+
+	placed := make(map[string]bool)
+	for _, move := range moves {
+		if placed[absPath(move.Dst)] {
+			fail(move, errors.New("destination used twice in the plan"))
+			continue
+		}
+		if _, err := os.Lstat(move.Dst); err == nil {
+			fail(move, fmt.Errorf("%s already exists", move.Dst))
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			fail(move, err)
+			continue
+		}
+		info, err := os.Stat(move.Src)
+		if err != nil {
+			fail(move, err)
+			continue
+		}
+		sum, err := fileSHA256(move.Src)
+		if err != nil {
+			fail(move, err)
+			continue
+		}
+
+		if err := mover.Move(move.Src, move.Dst); err != nil {
+			fail(move, err)
+			continue
+		}
+		placed[absPath(move.Dst)] = true
+		logger.Info("applied move", "file", move.Src, "dst", move.Dst)
+		if journal != nil {
+			entry := JournalEntry{Src: absPath(move.Src), Dst: absPath(move.Dst), SHA256: sum, Mode: mode, Time: time.Now()}
+			if err := journal.Record(entry); err != nil {
+				logger.Error("cannot write journal", "file", move.Src, "reason", err)
+			}
+		}
+		summary.Moved++
+		summary.BytesMoved += info.Size()
+		summary.Moves = append(summary.Moves, move)
+	}
+	return summary, nil
+}
@@ -0,0 +1,154 @@
+package sorter
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+)
+
+// One line of the -progress-json stream
+type progressEvent struct {
+	Event       string `json:"event"`
+	File        string `json:"file,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Total       int    `json:"total"`
+	Processed   int    `json:"processed"`
+	Failed      int    `json:"failed"`
+}
+
+// Track run progress and, when enabled, emit it as JSON lines so a GUI
+// can follow along without parsing the human-readable output. Workers
+// report concurrently, so every method takes the lock.
+type progress struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	bar     *progressBar // nil unless a progress bar is shown
+	total   int
+	handled int // files moved, failed or skipped so far
+	summary Summary
+}
+
+// Create a progress tracker; events are written to w unless it is nil
+func newProgress(w io.Writer) *progress {
+	p := &progress{}
+	if w != nil {
+		p.enc = json.NewEncoder(w)
+	}
+	return p
+}
+
+func (p *progress) emit(event progressEvent) {
+	if p.bar != nil {
+		p.bar.update(p.handled, p.total, event.File)
+	}
+	if p.enc == nil {
+		return
+	}
+	event.Total = p.total
+	event.Processed = p.summary.Moved
+	event.Failed = p.summary.Failed
+	p.enc.Encode(event)
+}
+
+// Report the start of a run over total files
+func (p *progress) start(total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = total
+	p.emit(progressEvent{Event: "start"})
+}
+
+// Report an image moved into the destination folder, together with its
+// sidecars; files[0] is the image and size covers all of them. country
+// is counted in the per-country breakdown unless empty.
+func (p *progress) fileProcessed(files []Move, destination, country string, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handled++
+	p.summary.Moves = append(p.summary.Moves, files...)
+	p.summary.Moved++
+	if country != "" {
+		if p.summary.ByCountry == nil {
+			p.summary.ByCountry = make(map[string]int)
+		}
+		p.summary.ByCountry[country]++
+	}
+	p.summary.Sidecars += len(files) - 1
+	p.summary.BytesMoved += size
+	p.emit(progressEvent{Event: "file-processed", File: files[0].Src, Destination: destination})
+}
+
+// Report an image whose content is already at existing. Unless it will
+// still be placed, this is the last report about the image.
+func (p *progress) duplicate(file, existing string, placed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !placed {
+		p.handled++
+	}
+	p.summary.Duplicates++
+	p.emit(progressEvent{Event: "duplicate", File: file, Destination: existing})
+}
+
+// Report an image left in place on purpose: it has no GPS data, is a
+// skipped screenshot, lies outside the selection or its destination name
+// is taken
+func (p *progress) skipped(file string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handled++
+	switch {
+	case errors.Is(err, ErrNoGPS):
+		p.summary.NoGPS++
+	case errors.Is(err, ErrFiltered):
+		p.summary.Filtered++
+	default:
+		p.summary.Skipped++
+	}
+	p.emit(progressEvent{Event: "skipped", File: file, Error: err.Error()})
+}
+
+// Count a placed image as moved to the unsorted folder; fileProcessed
+// reports it as well
+func (p *progress) unsorted() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.summary.Unsorted++
+}
+
+// Report a damaged image moved to the quarantine folder at dst
+func (p *progress) quarantined(file, dst string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handled++
+	p.summary.Failed++
+	p.summary.Quarantined++
+	p.summary.Errors = append(p.summary.Errors, FileError{Path: file, Err: err})
+	p.emit(progressEvent{Event: "quarantined", File: file, Destination: dst, Error: err.Error()})
+}
+
+// Report a file that could not be sorted
+func (p *progress) fileFailed(file string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handled++
+	p.summary.Failed++
+	p.summary.Errors = append(p.summary.Errors, FileError{Path: file, Err: err})
+	p.emit(progressEvent{Event: "error", File: file, Error: err.Error()})
+}
+
+// Report the end of the run
+func (p *progress) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emit(progressEvent{Event: "done"})
+}
+
+// Return a copy of the summary collected so far
+func (p *progress) snapshot() Summary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.summary
+}
@@ -0,0 +1,95 @@
+package sorter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pic-sorter/pkg/exifinfo"
+)
+
+// Suffix of the file written next to each quarantined image
+const reasonExt = ".reason.txt"
+
+// Check every image of a group with exifinfo.Check and return the first
+// problem found
+func checkGroup(group []string) error {
+	for _, imagePath := range group {
+		if err := exifinfo.Check(imagePath); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(imagePath), err)
+		}
+	}
+	return nil
+}
+
+// Move a group of damaged images and their sidecars to the quarantine
+// folder, each image with a text file saying why. The group stays
+// together, as one damaged file of a RAW+JPEG pair would otherwise
+// separate the pair.
+func (s *Sorter) quarantine(group []string, sidecars map[string][]string, reason error, p *placement) {
+	opts := s.Options
+	for _, imagePath := range group {
+		dst, _ := p.names.reserve(filepath.Join(opts.Quarantine, filepath.Base(imagePath)), CollisionSuffix, "")
+		files := []Move{{Src: imagePath, Dst: dst}}
+		for _, sidecar := range sidecars[imagePath] {
+			sidecarDst, _ := p.names.reserve(sidecarDest(imagePath, dst, sidecar), CollisionSuffix, "")
+			files = append(files, Move{Src: sidecar, Dst: sidecarDst})
+		}
+		if opts.DryRun {
+			s.logger.Warn("would quarantine damaged file", "file", imagePath, "dst", dst, "reason", reason)
+			p.prog.quarantined(imagePath, dst, reason)
+			continue
+		}
+
+		sums := make([]string, len(files))
+		var err error
+		for i, file := range files {
+			if sums[i], err = fileSHA256(file.Src); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = s.placeAll(files, opts.Mode, p.mover)
+		}
+		if err != nil {
+			s.logger.Error("cannot quarantine file", "file", imagePath, "reason", err)
+			p.prog.fileFailed(imagePath, err)
+			continue
+		}
+		for i, file := range files {
+			s.record(file, sums[i], opts.Mode)
+		}
+
+		note := fmt.Sprintf("file: %s\nreason: %v\ntime: %s\n", absPath(imagePath), reason, time.Now().Format(time.RFC3339))
+		if err := os.WriteFile(dst+reasonExt, []byte(note), 0o644); err != nil {
+			s.logger.Warn("cannot write reason file", "file", dst+reasonExt, "reason", err)
+		} else if sum, err := fileSHA256(dst + reasonExt); err == nil {
+			s.record(Move{Dst: dst + reasonExt}, sum, createdMode)
+		}
+
+		s.logger.Warn("quarantined damaged file", "file", imagePath, "dst", dst, "reason", reason)
+		if keepsOriginals(opts.Mode) {
+			s.remember(imagePath, sums[0], StateCopied)
+		} else if s.State != nil {
+			s.State.Forget(imagePath)
+		}
+		p.prog.quarantined(imagePath, dst, reason)
+	}
+}
+
+// Place files in order with mover, putting the ones already placed back
+// if one fails
+func (s *Sorter) placeAll(files []Move, mode string, mover Mover) error {
+	for i, file := range files {
+		if err := mover.Move(file.Src, file.Dst); err != nil {
+			for _, placed := range files[:i] {
+				if revertErr := revertMove(mode, placed.Src, placed.Dst); revertErr != nil {
+					s.logger.Error("cannot put file back", "file", placed.Src, "reason", revertErr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package sorter
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Machine-readable account of what went wrong in a run, for scripts and
+// cron jobs
+type ErrorReport struct {
+	Time   time.Time   `json:"time"`
+	Error  string      `json:"error,omitempty"` // why the run stopped early, if it did
+	Failed int         `json:"failed"`
+	Files  []FileError `json:"files"` // one entry per failed file
+}
+
+// Write the errors of a run as JSON to path. runErr is the error the run
+// returned, if any. The report is written even if nothing failed, so a
+// stale report never survives a clean run.
+func WriteErrorReport(path string, summary Summary, runErr error) error {
+	report := ErrorReport{Time: time.Now(), Failed: summary.Failed, Files: summary.Errors}
+	if runErr != nil {
+		report.Error = runErr.Error()
+	}
+	if report.Files == nil {
+		report.Files = []FileError{}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
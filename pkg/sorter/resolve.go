@@ -0,0 +1,295 @@
+package sorter
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"pic-sorter/pkg/exifinfo"
+	"pic-sorter/pkg/geocode"
+	"pic-sorter/pkg/gpx"
+)
+
+// Sort dimensions selectable with Options.By
+const (
+	ByLocation = "location" // country/state/state_district/county, see Options.Levels
+	ByDate     = "date"     // capture date formatted with Options.DateLayout
+	ByTrip     = "trip"     // one folder per trip, see Options.TripGap
+	ByEvent    = "event"    // one folder per shooting session, see Options.EventGap
+	ByCamera   = "camera"   // camera make and model from EXIF
+)
+
+// Split Options.By into its dimensions, e.g. "location,date" into
+// location then date; empty means ByLocation
+func dimensions(by string) []string {
+	var dims []string
+	for _, dim := range strings.Split(by, ",") {
+		if dim = strings.TrimSpace(dim); dim != "" {
+			dims = append(dims, dim)
+		}
+	}
+	if len(dims) == 0 {
+		return []string{ByLocation}
+	}
+	return dims
+}
+
+// Check that by names known dimensions. Location, date and camera can be
+// combined in any order; trips and events are only clustered on their own.
+func CheckDimensions(by string) error {
+	dims := dimensions(by)
+	for _, dim := range dims {
+		switch dim {
+		case ByLocation, ByDate, ByCamera:
+		case ByTrip, ByEvent:
+			if len(dims) > 1 {
+				return fmt.Errorf("-by %s cannot be combined with other dimensions", dim)
+			}
+		default:
+			return fmt.Errorf("unknown sort dimension %q, want %s, %s, %s, %s or %s",
+				dim, ByLocation, ByDate, ByTrip, ByEvent, ByCamera)
+		}
+	}
+	return nil
+}
+
+// Default layout of date folders: YYYY/MM/DD
+const DefaultDateLayout = "2006/01/02"
+
+// Reported for images that have no usable GPS coordinates
+var ErrNoGPS = errors.New("no GPS data")
+
+// Reported for screenshots when Options.SkipScreenshots is set
+var ErrScreenshot = errors.New("screenshot")
+
+// Default folder, under the destination root, for images that cannot be
+// sorted; see Options.Unsorted
+const DefaultUnsortedDir = "_unsorted"
+
+// Subfolders of the unsorted folder, by why an image could not be sorted
+const (
+	UnsortedNoGPS         = "no-gps"
+	UnsortedCorruptEXIF   = "corrupt-exif"
+	UnsortedGeocodeFailed = "geocode-failed"
+)
+
+// Destination folders resolved for one group of images
+type resolvedGroup struct {
+	group    []string
+	levels   []string
+	info     exifinfo.Info
+	location geocode.Location // nil unless the group was geocoded
+	err      error            // ErrNoGPS, or reading metadata or geocoding failed
+	reason   string           // unsorted subfolder matching err, if any
+}
+
+// Merge the metadata of a group: GPS, camera and EXIF time come from the
+// first file that has them, the fallback time from the first file
+func groupInfo(group []string) (exifinfo.Info, error) {
+	var merged exifinfo.Info
+	var lastErr error
+	read := 0
+	for _, imagePath := range group {
+		info, err := exifinfo.Read(imagePath)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		read++
+		if read == 1 {
+			merged = info
+			continue
+		}
+		if !merged.HasGPS && info.HasGPS {
+			merged.HasGPS, merged.Lat, merged.Lon = true, info.Lat, info.Lon
+		}
+		if !merged.ExactTime && info.ExactTime {
+			merged.Time, merged.ExactTime = info.Time, true
+		}
+		if merged.Make == "" && merged.Model == "" {
+			merged.Make, merged.Model = info.Make, info.Model
+		}
+	}
+	if read == 0 {
+		return merged, lastErr
+	}
+	return merged, nil
+}
+
+// Return a copy of location with every name transliterated; the original
+// may be shared with the geocode cache
+func transliterated(location geocode.Location) geocode.Location {
+	names := make(geocode.Location, len(location))
+	for key, value := range location {
+		names[key] = geocode.Transliterate(value)
+	}
+	return names
+}
+
+// Return a copy of location naming the geofence it lies in; the original
+// may be shared with the geocode cache
+func withPlace(location geocode.Location, place string) geocode.Location {
+	names := make(geocode.Location, len(location)+1)
+	for key, value := range location {
+		names[key] = value
+	}
+	names[geocode.PlaceField] = place
+	return names
+}
+
+// Split a time formatted with layout into folder levels
+func dateLevels(t time.Time, layout string) []string {
+	if layout == "" {
+		layout = DefaultDateLayout
+	}
+	return strings.Split(t.Format(layout), "/")
+}
+
+// Return the capture time of info as the local time in zone, the zone of
+// its position. The instant comes from the GPS clock if the image has
+// one, else from the EXIF time read in cameraZone, else from the file
+// time. An EXIF time with no camera zone is taken as local already.
+func localTime(info exifinfo.Info, zone, cameraZone *time.Location) time.Time {
+	t := info.Time
+	switch {
+	case !info.GPSTime.IsZero():
+		return info.GPSTime.In(zone)
+	case !info.ExactTime:
+		return t.In(zone)
+	case cameraZone != nil:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), cameraZone).In(zone)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), zone)
+}
+
+// Resolve the destination folders of a group of images. Images without
+// GPS data but with a capture time are positioned from track, if any.
+// With zones, capture times become the local time at the position.
+func resolveGroup(group []string, geocoder geocode.Geocoder, opts Options, layout *layout, track *gpx.Track, zones geocode.TimezoneFinder) resolvedGroup {
+	info, err := groupInfo(group)
+	if err != nil {
+		return resolvedGroup{group: group, err: err, reason: UnsortedCorruptEXIF}
+	}
+	if shift := timeShift(info, opts); shift != 0 && info.ExactTime {
+		info.Time = info.Time.Add(shift)
+	}
+	if opts.SkipScreenshots && info.Screenshot {
+		return resolvedGroup{group: group, info: info, err: ErrScreenshot}
+	}
+	if !info.HasGPS && info.ExactTime && track != nil {
+		info.Lat, info.Lon, info.HasGPS = track.Locate(info.Time)
+	}
+	if zones != nil && info.HasGPS {
+		if zone, err := zones.Timezone(info.Lat, info.Lon); err == nil {
+			info.Time = localTime(info, zone, opts.CameraZone)
+		}
+	}
+
+	if !selected(info, opts) {
+		return resolvedGroup{group: group, info: info, err: ErrFiltered}
+	}
+
+	dims := dimensions(opts.By)
+	needsLocation := slices.Contains(dims, ByLocation) || slices.Contains(dims, ByTrip)
+	if layout != nil {
+		needsLocation = layout.needsLocation
+	}
+	if needsLocation && !info.HasGPS {
+		if !opts.DateFallback {
+			return resolvedGroup{group: group, info: info, err: ErrNoGPS, reason: UnsortedNoGPS}
+		}
+		return resolvedGroup{group: group, levels: dateLevels(info.Time, opts.DateLayout), info: info}
+	}
+
+	// A geofence names the place itself, so location folders need no
+	// lookup; layouts and trips may still ask for the geocoded fields
+	fence, fenced := geocode.Fence{}, false
+	if info.HasGPS {
+		fence, fenced = geocode.FindFence(opts.Geofences, info.Lat, info.Lon)
+	}
+	if fenced && layout == nil && slices.Contains(dims, ByLocation) {
+		needsLocation = false
+	}
+	if len(opts.Countries) > 0 {
+		needsLocation = true
+	}
+
+	var location geocode.Location
+	if needsLocation {
+		if location, err = geocoder.ReverseGeocode(info.Lat, info.Lon); err != nil {
+			return resolvedGroup{group: group, info: info, err: err, reason: UnsortedGeocodeFailed}
+		}
+		location = preferredNames(location, opts)
+		if len(opts.Countries) > 0 && !inCountries(location, opts.Countries) {
+			return resolvedGroup{group: group, info: info, err: ErrFiltered}
+		}
+		if opts.Transliterate {
+			location = transliterated(location)
+		}
+	}
+	if fenced {
+		location = withPlace(location, fence.Name)
+	}
+
+	result := resolvedGroup{group: group, info: info, location: location}
+	if layout != nil {
+		result.levels, result.err = layout.levels(layoutFields(info, location, opts.Placeholder))
+		return result
+	}
+	placeholder := opts.Placeholder
+	if placeholder == "" {
+		placeholder = geocode.DefaultPlaceholder
+	}
+	for _, dim := range dims {
+		switch dim {
+		case ByLocation:
+			if fenced {
+				result.levels = append(result.levels, strings.Split(fence.Name, "/")...)
+				continue
+			}
+			result.levels = append(result.levels, folderLevels(location, opts.Levels, placeholder)...)
+		case ByDate:
+			result.levels = append(result.levels, dateLevels(info.Time, opts.DateLayout)...)
+		case ByCamera:
+			result.levels = append(result.levels, cameraName(info.Make, info.Model, placeholder))
+		case ByTrip, ByEvent:
+			// Named once all trips or events are known
+		}
+	}
+	return result
+}
+
+// Call fn for every item using the given number of workers. Items not
+// yet started when done is closed are skipped.
+func parallel[T any](items []T, workers int, done <-chan struct{}, fn func(T)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan T)
+	go func() {
+		defer close(jobs)
+		for _, item := range items {
+			select {
+			case jobs <- item:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				fn(item)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,123 @@
+package sorter
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"pic-sorter/pkg/geocode"
+)
+
+// Default length limit of a folder name in bytes, that of most file
+// systems
+const DefaultMaxName = 255
+
+// Characters Windows and SMB shares do not allow in names
+const reservedChars = `/\:*?"<>|`
+
+// Names Windows reserves for devices, with or without an extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// How place names are turned into folder names that work on every file
+// system. Reserved and control characters are always replaced, Windows
+// device names and trailing dots and spaces always avoided.
+type SanitizeOptions struct {
+	Replacement string // replaces spaces and reserved characters; "" means "_"
+	KeepSpaces  bool   // leave spaces in names
+	ASCII       bool   // transliterate names and replace what is still not ASCII
+	MaxName     int    // longest folder name in bytes; 0 means DefaultMaxName
+	MaxPath     int    // longest destination path in bytes, shortening folder names to fit; 0 means no limit
+}
+
+// Make a folder name safe
+func (o SanitizeOptions) name(name string) string {
+	replacement := o.Replacement
+	if replacement == "" {
+		replacement = "_"
+	}
+	if o.ASCII {
+		name = geocode.Transliterate(name)
+	}
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r == ' ' && !o.KeepSpaces,
+			strings.ContainsRune(reservedChars, r),
+			unicode.IsControl(r),
+			o.ASCII && r >= utf8.RuneSelf:
+			b.WriteString(replacement)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	name = truncate(b.String(), o.maxName())
+
+	// Windows drops trailing dots and spaces, merging "St." with "St"
+	name = strings.TrimRight(name, ". ")
+	stem, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(stem)] {
+		name = replacement + name
+	}
+	if name == "" {
+		name = replacement
+	}
+	return name
+}
+
+func (o SanitizeOptions) maxName() int {
+	if o.MaxName <= 0 {
+		return DefaultMaxName
+	}
+	return o.MaxName
+}
+
+// Join destRoot, the sanitized levels and base into a path, shortening the
+// longest folder names until it fits MaxPath. The root and base are never
+// shortened.
+func (o SanitizeOptions) join(destRoot string, levels []string, base string) string {
+	names := make([]string, len(levels))
+	for i, level := range levels {
+		names[i] = o.name(level)
+	}
+	if o.MaxPath > 0 {
+		for excess := o.pathLength(destRoot, names, base) - o.MaxPath; excess > 0; {
+			longest := 0
+			for i := range names {
+				if len(names[i]) > len(names[longest]) {
+					longest = i
+				}
+			}
+			if len(names) == 0 || len(names[longest]) <= 1 {
+				break
+			}
+			cut := min(excess, len(names[longest])-1)
+			names[longest] = strings.TrimRight(truncate(names[longest], len(names[longest])-cut), ". ")
+			if names[longest] == "" {
+				names[longest] = "_"
+			}
+			excess = o.pathLength(destRoot, names, base) - o.MaxPath
+		}
+	}
+	return filepath.Join(append(append([]string{destRoot}, names...), base)...)
+}
+
+func (o SanitizeOptions) pathLength(destRoot string, names []string, base string) int {
+	return len(filepath.Join(append(append([]string{destRoot}, names...), base)...))
+}
+
+// Cut s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
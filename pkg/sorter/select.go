@@ -0,0 +1,55 @@
+package sorter
+
+import (
+	"errors"
+	"strings"
+
+	"pic-sorter/pkg/exifinfo"
+	"pic-sorter/pkg/geocode"
+)
+
+// Reported for images outside the selection of Options.After, Before,
+// Areas and Countries
+var ErrFiltered = errors.New("outside the selection")
+
+// Report whether the capture time and position of an image pass the
+// selection; images without GPS data are outside any area or country.
+// Countries are checked once the image is geocoded, see inCountries.
+func selected(info exifinfo.Info, opts Options) bool {
+	if !opts.After.IsZero() && info.Time.Before(opts.After) {
+		return false
+	}
+	if !opts.Before.IsZero() && !info.Time.Before(opts.Before) {
+		return false
+	}
+	if !info.HasGPS && len(opts.Countries) > 0 {
+		return false
+	}
+	if len(opts.Areas) > 0 {
+		if !info.HasGPS {
+			return false
+		}
+		if _, inside := geocode.FindFence(opts.Areas, info.Lat, info.Lon); !inside {
+			return false
+		}
+	}
+	return true
+}
+
+// Report whether a geocoded location lies in one of countries, given by
+// the provider's name, the name Options.CountryFormat gives or an ISO
+// 3166-1 code, in any case
+func inCountries(location geocode.Location, countries []string) bool {
+	names := []string{location["country"], location["country_code"]}
+	if c, ok := geocode.CountryByCode(location["country_code"]); ok {
+		names = append(names, c.Name, c.Official, c.Alpha3)
+	}
+	for _, want := range countries {
+		for _, name := range names {
+			if name != "" && strings.EqualFold(strings.TrimSpace(want), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
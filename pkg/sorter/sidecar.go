@@ -0,0 +1,89 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Default extensions of sidecar files moved together with their image:
+// XMP edits of RAW developers and the AAE edits of iPhones
+const DefaultSidecarExts = ".xmp,.aae"
+
+// Assign sidecar files to the images they belong to. "IMG_1234.CR2.xmp"
+// belongs to IMG_1234.CR2; "IMG_1234.xmp" to the first image named
+// IMG_1234 that has no sidecar of the same extension yet, or else the
+// first image of that name.
+func matchSidecars(images, sidecars []string) map[string][]string {
+	matched := make(map[string][]string)
+	if len(sidecars) == 0 {
+		return matched
+	}
+
+	isImage := make(map[string]bool, len(images))
+	bases := make(map[string][]string)
+	for _, image := range images {
+		isImage[image] = true
+		base := strings.TrimSuffix(image, filepath.Ext(image))
+		bases[base] = append(bases[base], image)
+	}
+
+	// Full-name sidecars first, so they take precedence for their image
+	var byBase []string
+	for _, sidecar := range sidecars {
+		owner := strings.TrimSuffix(sidecar, filepath.Ext(sidecar))
+		if isImage[owner] {
+			matched[owner] = append(matched[owner], sidecar)
+		} else {
+			byBase = append(byBase, sidecar)
+		}
+	}
+
+	for _, sidecar := range byBase {
+		candidates := bases[strings.TrimSuffix(sidecar, filepath.Ext(sidecar))]
+		if len(candidates) == 0 {
+			continue
+		}
+		owner := candidates[0]
+		ext := strings.ToLower(filepath.Ext(sidecar))
+		for _, image := range candidates {
+			if !hasSidecarExt(matched[image], ext) {
+				owner = image
+				break
+			}
+		}
+		matched[owner] = append(matched[owner], sidecar)
+	}
+	return matched
+}
+
+// Report whether one of the sidecars has the given lowercase extension
+func hasSidecarExt(sidecars []string, ext string) bool {
+	for _, sidecar := range sidecars {
+		if strings.ToLower(filepath.Ext(sidecar)) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// Destination of a sidecar next to its image's destination, renamed
+// along with the image if the image was given a new name
+func sidecarDest(imageSrc, imageDst, sidecar string) string {
+	name := filepath.Base(sidecar)
+	stem := strings.TrimSuffix(filepath.Base(imageSrc), filepath.Ext(imageSrc))
+	if strings.HasPrefix(name, stem) {
+		newStem := strings.TrimSuffix(filepath.Base(imageDst), filepath.Ext(imageDst))
+		name = newStem + name[len(stem):]
+	}
+	return filepath.Join(filepath.Dir(imageDst), name)
+}
+
+// Take back a placement made by a Mover after a later file of the same
+// image failed: delete the copy, or rename the file back
+func revertMove(mode, src, dst string) error {
+	if keepsOriginals(mode) {
+		return os.Remove(dst)
+	}
+	return RenameMover{}.Move(dst, src)
+}
@@ -0,0 +1,889 @@
+// Package sorter moves images into a folder tree based on where they
+// were taken.
+package sorter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pic-sorter/pkg/exifinfo"
+	"pic-sorter/pkg/geocode"
+	"pic-sorter/pkg/gpx"
+	"pic-sorter/pkg/orient"
+	"pic-sorter/pkg/storage"
+	"pic-sorter/pkg/xmp"
+)
+
+// Default root directory of the sorted tree
+const DefaultDestRoot = "sorted_images"
+
+// Options controlling how images are sorted
+type Options struct {
+	DestRoot      string          // root directory of the sorted tree
+	By            string          // ByLocation (default), ByDate, ByTrip, ByEvent or ByCamera, or a comma-separated list such as "location,date"
+	DateLayout    string          // Go time layout of date folders; "/" separates levels
+	CameraZone    *time.Location  // with Sorter.Timezones, the zone the camera clock was set to; nil takes EXIF times as local
+	DateFallback  bool            // sort images without GPS by date instead of skipping them
+	Unsorted      string          // folder under DestRoot for images that cannot be sorted, in UnsortedNoGPS etc. subfolders; "" leaves them in place
+	Quarantine    string          // folder damaged images are moved to, see exifinfo.Check; "" leaves them in place
+	Strict        bool            // stop the run at the first damaged image
+	Layout        string          // text/template of the destination path over LayoutFields; overrides By
+	Placeholder   string          // layout value for fields the metadata does not know
+	Transliterate bool            // spell place names in Latin letters without accents
+	CountryFormat string          // Country* spelling of country names; "" keeps the provider's
+	CityFields    []string        // address fields tried in order for the city, e.g. "town"; nil means city, town, village
+	Geofences     []geocode.Fence // named regions replacing the location folders of photos taken inside; the first match wins
+	Sanitize      SanitizeOptions // how place names become folder names
+	PairRaw       bool            // move RAW+JPEG pairs together
+	PairLive      bool            // move the still and the video of a Live Photo together
+	RawExts       []string        // RAW extensions sorted alongside images, e.g. ".cr2"
+	SidecarExts   []string        // extensions of sidecars moved with their image, e.g. ".xmp"
+	Levels        []string        // ByLocation: location fields used as folder levels, outermost first; nil means geocode.Fields
+	MinPerLevel   int             // adaptive depth: create a level only for this many photos
+	TripGap       time.Duration   // ByTrip: longest pause within a trip; 0 means DefaultTripGap
+	TripDistance  float64         // ByTrip: longest jump in km between photos of a trip; 0 means DefaultTripDistance
+	EventGap      time.Duration   // ByEvent: longest pause within an event; 0 means DefaultEventGap
+	Bursts        string          // BurstsFolder or BurstsTag to group bursts and near-duplicate shots; "" leaves them alone
+	BurstGap      time.Duration   // Bursts: longest pause within a burst; 0 means DefaultBurstGap
+	BurstDistance int             // Bursts: most perceptual hash bits two shots of a burst differ in; 0 means DefaultBurstDistance
+	DryRun        bool            // resolve destinations but leave every file in place
+	Mode          string          // ModeMove (default), ModeCopy, ModeLink or ModeHardlink; used when Sorter.Mover is nil
+	OnDuplicate   string          // Duplicate* policy for content already sorted; "" means DuplicateSkip
+	OnCollision   string          // Collision* strategy for taken names; "" means CollisionSuffix
+	Force         bool            // process files the State has as unchanged, too
+	NoSpaceCheck  bool            // skip checking that DestRoot has room for the files before placing any
+	WriteMetadata bool            // write the place names into the XMP metadata of placed images
+	TouchExifDate bool            // set the modification time of placed files to the capture time from the metadata
+	AutoRotate    bool            // ModeCopy: turn copied JPEGs upright per their EXIF orientation, see orient.Apply
+	ThumbSizes    []int           // write JPEG thumbnails this many pixels wide or high for placed images, see ThumbPath
+
+	TimeShift        time.Duration            // added to capture times from the metadata, for a camera clock set wrong
+	CameraTimeShifts map[string]time.Duration // shifts by camera name (see ByCamera) used instead of TimeShift
+	WriteTimeShift   bool                     // write shifted times into the EXIF of placed JPEGs, see exiftime.Shift
+
+	Workers            int // files decoded and moved in parallel
+	GeocodeConcurrency int // geocode requests allowed in flight at once
+	BatchPrecision     int // geocode one position per geohash cell of this length, see geocode.Batch; 0 looks up every photo
+
+	// Limits of a run; the images they leave out are processed by the
+	// next run, see Summary.Stopped
+	MaxFiles           int           // process at most this many images; 0 means all
+	MaxGeocodeRequests int           // send at most this many geocode requests, cache misses; 0 means no limit
+	MaxDuration        time.Duration // start no new image after this long; 0 means no limit
+
+	Recursive bool     // walk subdirectories of the source
+	Include   []string // only process files matching one of these globs
+	Exclude   []string // skip files and directories matching these globs
+	Exts      []string // only process files with these extensions, see ParseExts; nil means all supported ones
+	MinSize   int64    // skip files smaller than this many bytes
+
+	// Selection by metadata; images outside it are left alone, see ErrFiltered
+	After     time.Time       // only images taken at or after this time
+	Before    time.Time       // only images taken before this time
+	Areas     []geocode.Fence // only images taken inside one of these areas
+	Countries []string        // only images geocoded into one of these countries, by name or ISO code
+
+	SkipScreenshots bool // leave screenshots in place, see exifinfo.Info.Screenshot
+}
+
+// Sorts the images of a directory using a Geocoder and a Mover
+type Sorter struct {
+	Geocoder  geocode.Geocoder
+	Mover     Mover // nil picks the Mover for Options.Mode
+	Options   Options
+	Logger    *slog.Logger           // per-file messages; nil discards them
+	Events    io.Writer              // JSON progress lines, one per event; nil disables them
+	Journal   *Journal               // records every move for undo; nil disables it
+	Progress  io.Writer              // progress bar, normally a terminal; nil disables it
+	Ready     func(path string) bool // reports whether a found file may be sorted now; nil accepts all
+	State     *State                 // files processed by earlier runs, skipped unless Options.Force; nil disables it
+	Catalog   *Catalog               // metadata of placed photos for queries; nil disables it
+	Track     *gpx.Track             // GPX positions for images without GPS data; nil disables it
+	Timezones geocode.TimezoneFinder // if set, capture times become the local time at the GPS position
+
+	// Asked for the folder levels of an image without GPS data, e.g. by
+	// prompting the user; nil, or no levels, leaves the image in place.
+	// Calls come from several workers at once.
+	Unresolved func(path string, info exifinfo.Info) []string
+
+	// Run before an image is placed, e.g. CommandHook; an error leaves
+	// the image in place and counts it as failed. Not run in dry runs.
+	PreMove func(HookEvent) error
+	// Run after an image and its sidecars are placed; errors are only
+	// logged. Calls of both hooks come from several workers at once.
+	PostMove func(HookEvent) error
+
+	logger *slog.Logger // Logger during Run, printing above the bar
+	bar    *progressBar // bar on Progress during Run
+}
+
+// Create a Sorter that logs to slog's default logger and places files
+// per opts.Mode
+func New(geocoder geocode.Geocoder, opts Options) *Sorter {
+	return &Sorter{
+		Geocoder: geocoder,
+		Options:  opts,
+		Logger:   slog.Default(),
+	}
+}
+
+// Return the folder levels for a location, outermost first: the given
+// fields, or geocode.Fields if there are none. Fields the location lacks
+// get the placeholder.
+func folderLevels(location geocode.Location, fields []string, placeholder string) []string {
+	if len(fields) == 0 {
+		fields = geocode.Fields
+	}
+	if placeholder == "" {
+		placeholder = geocode.DefaultPlaceholder
+	}
+	levels := make([]string, len(fields))
+	for i, field := range fields {
+		if levels[i] = location[field]; levels[i] == "" {
+			levels[i] = placeholder
+		}
+	}
+	return levels
+}
+
+// Check that every field can be used as a folder level
+func checkLevelFields(fields []string) error {
+	for _, field := range fields {
+		if !slices.Contains(geocode.LevelFields, field) {
+			return fmt.Errorf("unknown location field %q, want one of %s", field, strings.Join(geocode.LevelFields, ", "))
+		}
+	}
+	return nil
+}
+
+// Destination path of an image in the folder made of the given levels
+func destPath(opts Options, imagePath string, levels []string) string {
+	return opts.Sanitize.join(opts.DestRoot, levels, filepath.Base(imagePath))
+}
+
+// Report whether path is directly in dir
+func inDir(path, dir string) bool {
+	return filepath.Dir(absPath(path)) == absPath(dir)
+}
+
+// Return the absolute form of path, or path itself if that fails
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// An image whose location has been resolved but not yet moved
+type plannedMove struct {
+	imagePath string
+	levels    []string
+	sidecars  []string // moved into the same folder as the image
+	info      exifinfo.Info
+	location  geocode.Location // from reverse geocoding; nil if not geocoded
+	unsorted  bool             // goes to the unsorted folder, see Options.Unsorted
+
+	shot   string // first file of the image's group; the files of one shot share it
+	hash   uint64 // perceptual hash of the shot, if hashed
+	hashed bool
+	burst  string // name of the burst the shot is part of, if any
+}
+
+// Trim each planned move to the deepest level that holds at least
+// minCount photos. Counting needs every location up front, so adaptive
+// sorting resolves all images before moving any of them.
+func limitDepthByCount(moves []plannedMove, minCount int) {
+	counts := make(map[string]int)
+	for _, move := range moves {
+		for depth := 1; depth <= len(move.levels); depth++ {
+			counts[strings.Join(move.levels[:depth], "/")]++
+		}
+	}
+
+	for i, move := range moves {
+		depth := 0
+		for depth < len(move.levels) && counts[strings.Join(move.levels[:depth+1], "/")] >= minCount {
+			depth++
+		}
+		moves[i].levels = move.levels[:depth]
+	}
+}
+
+// Finish planning moves with what needs every move known: trips or
+// events, adaptive depth and bursts. Moves of other runs are unchanged.
+func planTree(moves []plannedMove, opts Options, layout *layout) {
+	if layout == nil {
+		switch dimensions(opts.By)[0] {
+		case ByTrip:
+			clusterTrips(moves, opts)
+		case ByEvent:
+			clusterEvents(moves, opts)
+		}
+	}
+	limitDepthByCount(moves, opts.MinPerLevel)
+	// Burst folders are not subject to MinPerLevel
+	if opts.Bursts != "" {
+		groupBursts(moves, opts)
+	}
+}
+
+// Default number of workers: one per CPU
+var DefaultWorkers = runtime.NumCPU()
+
+// State shared by the workers placing files during a run
+type placement struct {
+	mover Mover
+	dups  *dupIndex
+	names *destNames
+	prog  *progress
+}
+
+// Move an image and its sidecars and report it. An image whose content
+// is already in the tree is handled per OnDuplicate, one whose name is
+// taken per OnCollision; nothing is ever overwritten. If a sidecar
+// cannot be placed, the files already placed are put back so that the
+// image never ends up separated from its sidecars.
+func (s *Sorter) applyMove(move plannedMove, p *placement) {
+	opts := s.Options
+	prog := p.prog
+	destination := strings.Join(move.levels, "/")
+	dst := destPath(opts, move.imagePath, move.levels)
+
+	var size int64
+	if info, err := os.Stat(move.imagePath); err == nil {
+		size = info.Size()
+	}
+	sum, err := fileSHA256(move.imagePath)
+	if err != nil {
+		s.logger.Error("cannot read file", "file", move.imagePath, "reason", err)
+		prog.fileFailed(move.imagePath, err)
+		return
+	}
+
+	// Files moved out of the way in the tree before placing the image
+	var displaced []Move
+	mover, mode := p.mover, opts.Mode
+	reserved, trashed := false, false
+	if existing, dup := p.dups.claim(size, sum, dst); dup {
+		policy := opts.OnDuplicate
+		if policy == DuplicateTrash && keepsOriginals(mode) {
+			// Copy and link modes never touch the originals
+			policy = DuplicateSkip
+		}
+		skip := policy == "" || policy == DuplicateSkip
+		prog.duplicate(move.imagePath, existing, !skip)
+		switch policy {
+		case "", DuplicateSkip:
+			s.logger.Info("skipping duplicate", "file", move.imagePath, "duplicate_of", existing)
+			s.remember(move.imagePath, sum, StateDuplicate)
+			return
+		case DuplicateReplace:
+			displaced = append(displaced, Move{Src: existing, Dst: trashPath(p.names, opts.DestRoot, existing)})
+			if existing == dst {
+				p.names.take(dst)
+				reserved = true
+			}
+		case DuplicateTrash:
+			dst = trashPath(p.names, opts.DestRoot, move.imagePath)
+			destination = path.Join(stateDir, "trash")
+			mover, mode = RenameMover{}, ModeMove
+			reserved, trashed = true, true
+		}
+	}
+
+	if !reserved {
+		strategy := opts.OnCollision
+		free, err := p.names.reserve(dst, strategy, sum)
+		if err != nil {
+			p.dups.release(sum, dst)
+			if strategy == CollisionSkip {
+				s.logger.Info("skipping file", "file", move.imagePath, "reason", err)
+				prog.skipped(move.imagePath, err)
+				s.remember(move.imagePath, sum, StateSkipped)
+				return
+			}
+			s.logger.Error("cannot move file", "file", move.imagePath, "reason", err)
+			prog.fileFailed(move.imagePath, err)
+			return
+		}
+		p.dups.moveClaim(sum, dst, free)
+		dst = free
+	} else {
+		p.dups.set(sum, dst)
+	}
+
+	// Sidecars must keep the name the image got, so they never get a
+	// name of their own
+	files := []Move{{Src: move.imagePath, Dst: dst}}
+	dsts := []string{dst}
+	fail := func(err error) {
+		p.names.release(dsts...)
+		p.dups.release(sum, dst)
+		s.logger.Error("cannot move file", "file", move.imagePath, "reason", err)
+		prog.fileFailed(move.imagePath, err)
+	}
+	for _, sidecar := range move.sidecars {
+		sidecarDst, err := p.names.reserve(sidecarDest(move.imagePath, dst, sidecar), CollisionFail, "")
+		if err != nil {
+			fail(err)
+			return
+		}
+		files = append(files, Move{Src: sidecar, Dst: sidecarDst})
+		dsts = append(dsts, sidecarDst)
+		if info, err := os.Stat(sidecar); err == nil {
+			size += info.Size()
+		}
+	}
+
+	if opts.DryRun {
+		for _, file := range displaced {
+			s.logger.Info("would move", "file", file.Src, "dst", file.Dst)
+		}
+		s.logger.Info("would move", "file", move.imagePath, "dst", dst)
+		prog.fileProcessed(files, destination, move.location["country"], size)
+		return
+	}
+
+	sums := []string{sum}
+	if s.Journal != nil {
+		for _, file := range files[1:] {
+			sidecarSum, err := fileSHA256(file.Src)
+			if err != nil {
+				fail(err)
+				return
+			}
+			sums = append(sums, sidecarSum)
+		}
+	}
+
+	if s.PreMove != nil && !trashed {
+		if err := s.PreMove(hookEvent(HookPre, move, files, sum, mode, destination)); err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	for _, file := range displaced {
+		s.logger.Info("moving duplicate to trash", "file", file.Src, "dst", file.Dst)
+		if err := (RenameMover{}).Move(file.Src, file.Dst); err != nil {
+			fail(err)
+			return
+		}
+		s.record(file, sum, ModeMove)
+		if s.Catalog != nil {
+			s.Catalog.Remove(file.Src)
+		}
+	}
+
+	verb := "moving"
+	switch {
+	case mode == ModeCopy:
+		verb = "copying"
+	case linksOriginals(mode):
+		verb = "linking"
+	}
+	s.logger.Info(verb, "file", move.imagePath, "destination", destination)
+	if err := s.placeAll(files, mode, mover); err != nil {
+		fail(err)
+		return
+	}
+
+	// Rewriting the placed image changes its checksum, sums[0], but not
+	// that of the source, sum, which the state keeps
+	if opts.AutoRotate && mode == ModeCopy {
+		s.autoRotate(files[0].Dst, sums)
+	}
+	if opts.WriteMetadata && move.location != nil {
+		s.writeMetadata(files, sums, move.location)
+	}
+	if shift := timeShift(move.info, opts); opts.WriteTimeShift && shift != 0 && move.info.ExactTime {
+		s.writeTimeShift(files[0].Dst, shift, sums)
+	}
+	placedSum := sums[0]
+	var modTimes []time.Time
+	if opts.TouchExifDate && move.info.ExactTime && !trashed {
+		modTimes = s.touch(files, move.info.Time)
+	}
+	if len(opts.ThumbSizes) > 0 && !move.unsorted && !trashed {
+		s.writeThumbs(files[0].Dst)
+	}
+
+	if s.Journal != nil {
+		for i, file := range files {
+			entry := s.journalEntry(file, sums[i], mode)
+			// Moved originals get their own time back on undo; copies
+			// are deleted
+			if i < len(modTimes) && !modTimes[i].IsZero() && !keepsOriginals(mode) {
+				entry.ModTime = &modTimes[i]
+			}
+			s.writeJournal(entry)
+		}
+	}
+	// Duplicates sent to the trash and unsorted images are not part of
+	// the library
+	if move.unsorted {
+		prog.unsorted()
+	} else if s.Catalog != nil && !trashed {
+		entry := catalogEntry(move.imagePath, dst, placedSum, move.info, move.location)
+		entry.Burst = move.burst
+		s.Catalog.Add(entry)
+	}
+	if keepsOriginals(mode) {
+		s.remember(move.imagePath, sum, StateCopied)
+	} else if s.State != nil {
+		s.State.Forget(move.imagePath)
+	}
+	if s.PostMove != nil && !trashed {
+		if err := s.PostMove(hookEvent(HookPost, move, files, placedSum, mode, destination)); err != nil {
+			s.logger.Warn("post-move hook failed", "file", dst, "reason", err)
+		}
+	}
+	prog.fileProcessed(files, destination, move.location["country"], size)
+}
+
+// Turn a copied image upright, updating its checksum, the first of sums.
+// Failures are only logged: the copy is sorted either way.
+func (s *Sorter) autoRotate(image string, sums []string) {
+	rotated, err := orient.Apply(image)
+	switch {
+	case err != nil:
+		s.logger.Warn("cannot rotate copy", "file", image, "reason", err)
+		return
+	case !rotated:
+		return
+	}
+	s.logger.Debug("rotated copy upright", "file", image)
+	if sum, err := fileSHA256(image); err == nil {
+		sums[0] = sum
+	}
+}
+
+// Write the place names into the metadata of a placed image, updating
+// the checksum in sums of the file that was rewritten. Failures are only logged:
+// the image is sorted either way.
+func (s *Sorter) writeMetadata(files []Move, sums []string, location geocode.Location) {
+	written, created, err := writePlace(files, placeOf(location))
+	switch {
+	case errors.Is(err, xmp.ErrHasPlace):
+		s.logger.Debug("keeping place names in metadata", "file", files[0].Dst)
+		return
+	case err != nil:
+		s.logger.Warn("cannot write metadata", "file", files[0].Dst, "reason", err)
+		return
+	case written == "":
+		s.logger.Info("not writing metadata to read-only file", "file", files[0].Dst)
+		return
+	}
+
+	if created {
+		if sum, err := fileSHA256(written); err == nil {
+			s.record(Move{Dst: written}, sum, createdMode)
+		}
+		return
+	}
+	for i, file := range files {
+		if i < len(sums) && file.Dst == written {
+			if sum, err := fileSHA256(written); err == nil {
+				sums[i] = sum
+			}
+		}
+	}
+}
+
+// Record the outcome of a file left in the source in the state, if there
+// is one and this is not a dry run
+func (s *Sorter) remember(path, sum, outcome string) {
+	if s.State != nil && !s.Options.DryRun {
+		s.State.Record(path, sum, outcome)
+	}
+}
+
+// Record a placement in the journal, if there is one. Absolute paths let
+// undo run from any working directory.
+func (s *Sorter) record(file Move, sum, mode string) {
+	if s.Journal == nil {
+		return
+	}
+	s.writeJournal(s.journalEntry(file, sum, mode))
+}
+
+// Return the journal entry of a placed file
+func (s *Sorter) journalEntry(file Move, sum, mode string) JournalEntry {
+	entry := JournalEntry{
+		Src:    file.Src,
+		Dst:    absPath(file.Dst),
+		SHA256: sum,
+		Mode:   mode,
+		Time:   time.Now(),
+	}
+	if entry.Src != "" {
+		entry.Src = absPath(entry.Src)
+	}
+	return entry
+}
+
+// Append an entry to the journal, logging failures
+func (s *Sorter) writeJournal(entry JournalEntry) {
+	if err := s.Journal.Record(entry); err != nil {
+		s.logger.Error("cannot write journal", "file", entry.Src, "reason", err)
+	}
+}
+
+// Report every file of a group as failed
+func failGroup(group []string, err error, prog *progress) {
+	for _, imagePath := range group {
+		prog.fileFailed(imagePath, err)
+	}
+}
+
+// Check the options shared by Run and RunStorage and parse the layout
+func checkOptions(opts Options) (*layout, error) {
+	if err := checkDuplicatePolicy(opts.OnDuplicate); err != nil {
+		return nil, err
+	}
+	if err := checkCollisionStrategy(opts.OnCollision); err != nil {
+		return nil, err
+	}
+	if err := CheckDimensions(opts.By); err != nil {
+		return nil, err
+	}
+	if err := checkLevelFields(opts.Levels); err != nil {
+		return nil, err
+	}
+	if err := checkBursts(opts.Bursts); err != nil {
+		return nil, err
+	}
+	if err := checkCountryFormat(opts.CountryFormat); err != nil {
+		return nil, err
+	}
+	if err := checkCityFields(opts.CityFields); err != nil {
+		return nil, err
+	}
+	return parseLayout(opts.Layout)
+}
+
+// Set up the summary, logger and progress bar of a run and return the
+// function that takes the bar down again
+func (s *Sorter) startOutput(prog *progress) func() {
+	prog.summary.DryRun = s.Options.DryRun
+	prog.summary.Mode = s.Options.Mode
+	s.logger = s.Logger
+	if s.logger == nil {
+		s.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	if s.Progress == nil {
+		return func() {}
+	}
+	s.bar = newProgressBar(s.Progress)
+	prog.bar = s.bar
+	s.logger = slog.New(barHandler{Handler: s.logger.Handler(), bar: s.bar})
+	return func() {
+		s.bar.finish()
+		s.bar = nil
+	}
+}
+
+// Return the geocoder of a run, and the cache whose stats go into the
+// summary
+func (s *Sorter) runGeocoder() (geocode.Geocoder, *geocode.Cache) {
+	// Reuse the caller's cache so its stats cover persisted hits too
+	cache, ok := s.Geocoder.(*geocode.Cache)
+	if !ok {
+		cache = geocode.NewMemoryCache(s.Geocoder)
+	}
+	// Only requests to the provider take one of the slots, so cache hits
+	// and waits for a cell lookup never queue behind them
+	cache.LimitConcurrency(s.Options.GeocodeConcurrency)
+	return geocode.Batch(cache, s.Options.BatchPrecision), cache
+}
+
+// Process all images in one or more source directories. Workers decode
+// and move files in parallel while geocode requests stay limited to
+// GeocodeConcurrency. An image found in several sources is only sorted
+// from the first of them; the other copies count as duplicates and stay
+// where they are.
+// The returned summary covers whatever was done, even on error; failed
+// files are listed in its Errors. A geocode.ErrBanned from the geocoder
+// aborts the run.
+func (s *Sorter) Run(directories ...string) (summary Summary, err error) {
+	opts := s.Options
+	for _, directory := range directories {
+		if storage.IsURL(directory) {
+			return Summary{}, errors.New("storage URLs need RunStorage")
+		}
+	}
+	if storage.IsURL(opts.DestRoot) {
+		return Summary{}, errors.New("storage URLs need RunStorage")
+	}
+	if err := checkSources(directories); err != nil {
+		return Summary{}, err
+	}
+	layout, err := checkOptions(opts)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	mover := s.Mover
+	if mover == nil {
+		if mover, err = MoverFor(opts.Mode); err != nil {
+			return Summary{}, err
+		}
+	}
+	if opts.WriteMetadata && linksOriginals(opts.Mode) && !opts.DryRun {
+		return Summary{}, fmt.Errorf("cannot write metadata in %s mode: it would change the originals", opts.Mode)
+	}
+	if opts.AutoRotate && opts.Mode != ModeCopy && !opts.DryRun {
+		return Summary{}, errors.New("auto-rotate only changes copies: use copy mode")
+	}
+	if opts.WriteTimeShift && linksOriginals(opts.Mode) && !opts.DryRun {
+		return Summary{}, fmt.Errorf("cannot write shifted times in %s mode: it would change the originals", opts.Mode)
+	}
+	if opts.TouchExifDate && linksOriginals(opts.Mode) && !opts.DryRun {
+		return Summary{}, fmt.Errorf("cannot set modification times in %s mode: it would change the originals", opts.Mode)
+	}
+	// Images waiting in the unsorted folder are not part of the library
+	var unsortedDir string
+	if opts.Unsorted != "" {
+		unsortedDir = filepath.Join(opts.DestRoot, opts.Unsorted)
+	}
+	dups, err := newDupIndex(opts.DestRoot, unsortedDir)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	prog := newProgress(s.Events)
+	defer s.startOutput(prog)()
+	place := &placement{mover: mover, dups: dups, names: newDestNames(), prog: prog}
+	geocoder, cache := s.runGeocoder()
+	statsBefore := cache.Stats()
+	cache.LimitRequests(opts.MaxGeocodeRequests)
+	defer cache.LimitRequests(0)
+	var deadline time.Time
+	if opts.MaxDuration > 0 {
+		deadline = time.Now().Add(opts.MaxDuration)
+	}
+
+	// Why and how many images a limit left for the next run
+	var (
+		stopped  string
+		deferred int
+	)
+	// The returned summary is always filled in from the progress tracker
+	defer func() {
+		summary = prog.snapshot()
+		summary.addCacheStats(statsBefore, cache.Stats())
+		summary.Stopped, summary.Deferred = stopped, deferred
+	}()
+
+	var paths, sidecarPaths []string
+	for _, directory := range directories {
+		found, foundSidecars, err := findFiles(directory, opts)
+		if err != nil {
+			return Summary{}, err
+		}
+		paths, sidecarPaths = append(paths, found...), append(sidecarPaths, foundSidecars...)
+	}
+	if s.Ready != nil {
+		paths, sidecarPaths = filterPaths(paths, s.Ready), filterPaths(sidecarPaths, s.Ready)
+	}
+	// Quarantined images inside the source are not checked again
+	if opts.Quarantine != "" {
+		paths = filterPaths(paths, func(path string) bool { return !inDir(path, opts.Quarantine) })
+	}
+	if s.State != nil && !opts.Force {
+		before := len(paths)
+		paths = filterPaths(paths, func(path string) bool { return !s.State.Unchanged(path) })
+		if unchanged := before - len(paths); unchanged > 0 {
+			s.logger.Info("skipping files processed by earlier runs", "count", unchanged)
+		}
+	}
+
+	paths, copies := sourceDups(paths, directories, opts.Workers)
+
+	groups := groupImages(paths, opts)
+	if opts.PairLive {
+		groups = pairContentIDs(groups)
+	}
+	if opts.MaxFiles > 0 {
+		if groups, deferred = limitGroups(groups, opts.MaxFiles); deferred > 0 {
+			stopped = fmt.Sprintf("file limit of %d reached", opts.MaxFiles)
+			paths, sidecarPaths = groupedFiles(groups, matchSidecars(paths, sidecarPaths))
+		}
+	}
+	if !opts.DryRun && !opts.NoSpaceCheck {
+		if err := checkSpace(opts.DestRoot, opts.Mode, append(slices.Clip(paths), sidecarPaths...)); err != nil {
+			return Summary{}, err
+		}
+	}
+
+	sidecars := matchSidecars(paths, sidecarPaths)
+	total := 0
+	for _, group := range groups {
+		total += len(group)
+	}
+	prog.start(total + len(copies))
+	defer prog.done()
+	for _, dup := range copies {
+		s.logger.Info("skipping copy found in another source", "file", dup.path, "duplicate_of", dup.canonical)
+		prog.duplicate(dup.path, dup.canonical, false)
+		s.remember(dup.path, dup.sum, StateDuplicate)
+	}
+
+	var (
+		dims      = dimensions(opts.By)
+		adaptive  = opts.MinPerLevel > 0 || opts.Bursts != "" || (layout == nil && (dims[0] == ByTrip || dims[0] == ByEvent))
+		moves     []plannedMove
+		unsorted  []plannedMove // moves left out of the adaptive planning
+		movesMu   sync.Mutex
+		done      = make(chan struct{})
+		abortOnce sync.Once
+		abortErr  error
+	)
+	abort := func(err error) {
+		abortOnce.Do(func() {
+			abortErr = err
+			close(done)
+		})
+	}
+	// Ending the run at a limit is no error; what was started completes
+	var started atomic.Int64
+	stop := func(reason string) {
+		abortOnce.Do(func() {
+			stopped = reason
+			close(done)
+		})
+	}
+
+	parallel(groups, opts.Workers, done, func(group []string) {
+		name := filepath.Base(group[0])
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			stop(fmt.Sprintf("time limit of %s reached", opts.MaxDuration))
+			return
+		}
+		started.Add(int64(len(group)))
+		if opts.Quarantine != "" || opts.Strict {
+			if err := checkGroup(group); err != nil {
+				if opts.Quarantine != "" {
+					s.quarantine(group, sidecars, err, place)
+				} else {
+					s.logger.Error("damaged file", "file", group[0], "reason", err)
+					failGroup(group, err, prog)
+				}
+				if opts.Strict {
+					abort(err)
+				}
+				return
+			}
+		}
+
+		result := resolveGroup(group, geocoder, opts, layout, s.Track, s.Timezones)
+
+		if errors.Is(result.err, ErrNoGPS) && s.Unresolved != nil {
+			if levels := s.Unresolved(group[0], result.info); len(levels) > 0 {
+				result.levels, result.err = levels, nil
+			}
+		}
+		if errors.Is(result.err, geocode.ErrBanned) {
+			failGroup(group, result.err, prog)
+			abort(fmt.Errorf("%s: %w", name, result.err))
+			return
+		}
+		if errors.Is(result.err, geocode.ErrQuota) {
+			started.Add(-int64(len(group)))
+			stop(fmt.Sprintf("limit of %d geocode requests reached", opts.MaxGeocodeRequests))
+			return
+		}
+
+		// Images already in the right unsorted subfolder, e.g. on a retry,
+		// stay where they are
+		toUnsorted := false
+		if result.reason != "" && unsortedDir != "" && !inDir(group[0], filepath.Join(unsortedDir, result.reason)) {
+			s.logger.Warn("cannot sort file, moving it to the unsorted folder", "file", group[0], "reason", result.err)
+			result.levels, result.err, toUnsorted = []string{opts.Unsorted, result.reason}, nil, true
+		}
+
+		if errors.Is(result.err, ErrFiltered) {
+			s.logger.Debug("leaving file outside the selection", "file", group[0])
+			for _, imagePath := range group {
+				// Not remembered, so later runs without filters take it
+				prog.skipped(imagePath, result.err)
+			}
+			return
+		}
+		if errors.Is(result.err, ErrScreenshot) {
+			s.logger.Info("skipping screenshot", "file", group[0])
+			for _, imagePath := range group {
+				prog.skipped(imagePath, result.err)
+				s.remember(imagePath, "", StateSkipped)
+			}
+			return
+		}
+		if errors.Is(result.err, ErrNoGPS) {
+			s.logger.Warn("no GPS data", "file", group[0])
+			for _, imagePath := range group {
+				prog.skipped(imagePath, result.err)
+				s.remember(imagePath, "", StateNoGPS)
+			}
+			return
+		}
+		if result.err != nil {
+			s.logger.Error("cannot resolve destination", "file", group[0], "reason", result.err)
+			failGroup(group, result.err, prog)
+			return
+		}
+
+		var hash uint64
+		hashed := false
+		if opts.Bursts != "" && !toUnsorted {
+			hash, hashed = groupHash(group)
+		}
+		for _, imagePath := range group {
+			move := plannedMove{
+				imagePath: imagePath,
+				levels:    result.levels,
+				sidecars:  sidecars[imagePath],
+				info:      result.info,
+				location:  result.location,
+				unsorted:  toUnsorted,
+				shot:      group[0],
+				hash:      hash,
+				hashed:    hashed,
+			}
+			if adaptive {
+				movesMu.Lock()
+				if toUnsorted {
+					unsorted = append(unsorted, move)
+				} else {
+					moves = append(moves, move)
+				}
+				movesMu.Unlock()
+				continue
+			}
+			s.applyMove(move, place)
+		}
+	})
+	if abortErr != nil {
+		return Summary{}, abortErr
+	}
+	deferred += total - int(started.Load())
+
+	if adaptive {
+		planTree(moves, opts, layout)
+		// The images resolved before a limit stopped the run are placed
+		parallel(append(moves, unsorted...), opts.Workers, nil, func(move plannedMove) {
+			s.applyMove(move, place)
+		})
+	}
+	return Summary{}, nil
+}
@@ -0,0 +1,54 @@
+package sorter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"pic-sorter/pkg/geocode"
+)
+
+func TestPlaceholderInDestPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		address     string // Nominatim address of the response
+		fields      []string
+		placeholder string
+		want        string
+	}{
+		{
+			name:        "missing state",
+			address:     `{"country": "France", "state_district": "Paris", "county": "Paris"}`,
+			placeholder: "Inconnu",
+			want:        "France/Inconnu/Paris/Paris/img.jpg",
+		},
+		{
+			name:        "missing city",
+			address:     `{"country": "España", "state": "Cataluña"}`,
+			fields:      []string{"country", "state", "city"},
+			placeholder: "Desconocido",
+			want:        "España/Cataluña/Desconocido/img.jpg",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				rw.Write([]byte(`{"address": ` + test.address + `}`))
+			}))
+			defer server.Close()
+			nominatim := geocode.NewNominatim()
+			nominatim.BaseURL, nominatim.Client = server.URL, server.Client()
+
+			location, err := nominatim.ReverseGeocode(48.85, 2.35)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := Options{DestRoot: "dest"}
+			got := destPath(opts, "src/img.jpg", folderLevels(location, test.fields, test.placeholder))
+			if want := filepath.Join("dest", filepath.FromSlash(test.want)); got != want {
+				t.Errorf("destination = %q, want %q", got, want)
+			}
+		})
+	}
+}
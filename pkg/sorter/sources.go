@@ -0,0 +1,108 @@
+package sorter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An image whose content an earlier source directory of the run has too
+type sourceDup struct {
+	path      string
+	canonical string // the copy that is sorted
+	sum       string
+}
+
+// Report whether path is dir or lies below it
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(absPath(dir), absPath(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Check that no source directory is another one or lies inside it, so no
+// file is found twice
+func checkSources(directories []string) error {
+	if len(directories) == 0 {
+		return fmt.Errorf("no source directory")
+	}
+	for i, a := range directories {
+		for _, b := range directories[i+1:] {
+			if within(a, b) || within(b, a) {
+				return fmt.Errorf("source directories %s and %s overlap", a, b)
+			}
+		}
+	}
+	return nil
+}
+
+// Return the index of the source directory holding path
+func sourceOf(path string, directories []string) int {
+	for i, dir := range directories {
+		if within(path, dir) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Split off the images of paths whose content a file of an earlier source
+// directory has, keeping the copy of the first source as the canonical
+// one. Only files whose size occurs in more than one source are hashed,
+// using the given number of workers. Copies within one source are left
+// to the usual duplicate handling.
+func sourceDups(paths, directories []string, workers int) (kept []string, dups []sourceDup) {
+	if len(directories) < 2 {
+		return paths, nil
+	}
+
+	sizes := make([]int64, len(paths))
+	sources := make([]int, len(paths))
+	bySize := make(map[int64][]int)
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			sizes[i] = -1
+			continue
+		}
+		sizes[i], sources[i] = info.Size(), sourceOf(path, directories)
+		bySize[sizes[i]] = append(bySize[sizes[i]], i)
+	}
+	var candidates []int
+	for _, indices := range bySize {
+		for _, i := range indices[1:] {
+			if sources[i] != sources[indices[0]] {
+				candidates = append(candidates, indices...)
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return paths, nil
+	}
+
+	sums := make([]string, len(paths))
+	parallel(candidates, workers, nil, func(i int) {
+		sums[i], _ = fileSHA256(paths[i])
+	})
+
+	// Paths are in source order, so the first copy seen is the canonical one
+	first := make(map[string]int)
+	for i, path := range paths {
+		if sums[i] == "" {
+			kept = append(kept, path)
+			continue
+		}
+		key := fmt.Sprintf("%d:%s", sizes[i], sums[i])
+		j, seen := first[key]
+		if !seen {
+			first[key] = i
+		}
+		if !seen || sources[j] == sources[i] {
+			kept = append(kept, path)
+			continue
+		}
+		dups = append(dups, sourceDup{path: path, canonical: paths[j], sum: sums[i]})
+	}
+	return kept, dups
+}
@@ -0,0 +1,68 @@
+package sorter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Reported when the destination cannot hold the files of a run
+var ErrNoSpace = errors.New("not enough free space")
+
+// Return path, or its closest ancestor that exists
+func existingAncestor(path string) string {
+	path = absPath(path)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// Check that the file system of destRoot has room for files placed in
+// mode. Moves within one file system and links take no room. The total
+// is an upper bound, as some files may end up left in place. Where free
+// space cannot be told, the check passes.
+func checkSpace(destRoot, mode string, files []string) error {
+	if linksOriginals(mode) {
+		return nil
+	}
+	dir := existingAncestor(destRoot)
+
+	var need int64
+	sameFS := make(map[string]bool) // by source folder
+	for _, file := range files {
+		if !keepsOriginals(mode) {
+			srcDir := filepath.Dir(file)
+			same, checked := sameFS[srcDir]
+			if !checked {
+				same, _ = sameFileSystem(srcDir, dir)
+				sameFS[srcDir] = same
+			}
+			if same {
+				continue
+			}
+		}
+		if info, err := os.Stat(file); err == nil {
+			need += info.Size()
+		}
+	}
+	if need == 0 {
+		return nil
+	}
+
+	free, err := freeSpace(dir)
+	if err != nil {
+		return nil
+	}
+	if uint64(need) > free {
+		return fmt.Errorf("%w: the files need %s on %s, %s is free", ErrNoSpace, formatBytes(need), dir, formatBytes(int64(free)))
+	}
+	return nil
+}
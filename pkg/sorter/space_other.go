@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package sorter
+
+import "errors"
+
+// Return the bytes available on the file system of path; unknown here
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// Report whether two paths are on the same file system; unknown here
+func sameFileSystem(a, b string) (bool, error) {
+	return false, errors.ErrUnsupported
+}
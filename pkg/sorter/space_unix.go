@@ -0,0 +1,26 @@
+//go:build linux || darwin || freebsd
+
+package sorter
+
+import "syscall"
+
+// Return the bytes available to this user on the file system of path
+func freeSpace(path string) (uint64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, err
+	}
+	return uint64(fs.Bavail) * uint64(fs.Bsize), nil
+}
+
+// Report whether two existing paths are on the same file system
+func sameFileSystem(a, b string) (bool, error) {
+	var sa, sb syscall.Stat_t
+	if err := syscall.Stat(a, &sa); err != nil {
+		return false, err
+	}
+	if err := syscall.Stat(b, &sb); err != nil {
+		return false, err
+	}
+	return uint64(sa.Dev) == uint64(sb.Dev), nil
+}
@@ -0,0 +1,28 @@
+package sorter
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// Return the bytes available to this user on the volume of path
+func freeSpace(path string) (uint64, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	if ok, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&available)), 0, 0); ok == 0 {
+		return 0, err
+	}
+	return available, nil
+}
+
+// Report whether two existing paths are on the same volume
+func sameFileSystem(a, b string) (bool, error) {
+	return strings.EqualFold(filepath.VolumeName(absPath(a)), filepath.VolumeName(absPath(b))), nil
+}
@@ -0,0 +1,161 @@
+package sorter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outcomes of a file recorded in a State
+const (
+	StateCopied    = "copied"    // copied or linked into the tree, the original kept
+	StateNoGPS     = "no-gps"    // left in place without GPS data
+	StateDuplicate = "duplicate" // left in place, its content is already sorted
+	StateSkipped   = "skipped"   // left in place, its destination name is taken
+)
+
+// What a State knows about a processed file
+type StateEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256,omitempty"`
+	Outcome string    `json:"outcome"`
+	Time    time.Time `json:"time"`
+}
+
+// Record of the source files earlier runs processed and left in place,
+// so that later runs skip them while they are unchanged
+type State struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]StateEntry // by absolute path
+	dirty   bool
+}
+
+// On-disk format of a State
+type stateFile struct {
+	Files map[string]StateEntry `json:"files"`
+}
+
+// Default path of the state of a sorted tree
+func DefaultStatePath(destRoot string) string {
+	return filepath.Join(destRoot, stateDir, "state.json")
+}
+
+// Load the state saved at path; a missing file is an empty state
+func OpenState(path string) (*State, error) {
+	s := &State{path: path, entries: make(map[string]StateEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file stateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("state %s: %w", path, err)
+	}
+	if file.Files != nil {
+		s.entries = file.Files
+	}
+	return s, nil
+}
+
+// Report whether path was processed before and has not changed since.
+// A file whose modification time changed but whose content did not
+// counts as unchanged.
+func (s *State) Unchanged(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	key := absPath(path)
+
+	s.mu.Lock()
+	entry, ok := s.entries[key]
+	s.mu.Unlock()
+	if !ok || entry.Size != info.Size() {
+		return false
+	}
+	if entry.ModTime.Equal(info.ModTime()) {
+		return true
+	}
+	if entry.SHA256 == "" {
+		return false
+	}
+	if sum, err := fileSHA256(path); err != nil || sum != entry.SHA256 {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.ModTime = info.ModTime()
+	s.entries[key] = entry
+	s.dirty = true
+	return true
+}
+
+// Record the outcome of a file left in place. sum may be empty if the
+// file was not hashed.
+func (s *State) Record(path, sum, outcome string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[absPath(path)] = StateEntry{
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		SHA256:  sum,
+		Outcome: outcome,
+		Time:    time.Now(),
+	}
+	s.dirty = true
+}
+
+// Forget a file, e.g. because it was moved away
+func (s *State) Forget(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := absPath(path)
+	if _, ok := s.entries[key]; ok {
+		delete(s.entries, key)
+		s.dirty = true
+	}
+}
+
+// Write the state back to its file if it changed
+func (s *State) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(stateFile{Files: s.entries})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn state
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,214 @@
+package sorter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pic-sorter/pkg/exifinfo"
+	"pic-sorter/pkg/geocode"
+)
+
+// Formats of WriteStats
+const (
+	StatsTable = "table"
+	StatsJSON  = "json"
+	StatsCSV   = "csv"
+)
+
+// Breakdowns of a stats report, in the order they are written
+const (
+	StatsCountry = "country"
+	StatsState   = "state"
+	StatsCity    = "city"
+	StatsYear    = "year"
+	StatsMonth   = "month"
+	StatsCamera  = "camera"
+	StatsFolder  = "folder"
+)
+
+var statsBreakdowns = []string{StatsCountry, StatsState, StatsCity, StatsYear, StatsMonth, StatsCamera, StatsFolder}
+
+// Photo counts and storage of a sorted tree
+type Stats struct {
+	Photos     int              `json:"photos"`
+	Bytes      int64            `json:"bytes"` // all files of the tree, sidecars included
+	Breakdowns []StatsBreakdown `json:"breakdowns"`
+}
+
+// Counts of one breakdown, e.g. per country
+type StatsBreakdown struct {
+	Name string     `json:"name"` // one of the Stats* breakdowns
+	Rows []StatsRow `json:"rows"`
+}
+
+// Photos and bytes of one value of a breakdown, e.g. "France"
+type StatsRow struct {
+	Key    string `json:"key"`
+	Photos int    `json:"photos"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// Count the photos of the tree under destRoot per place, time, camera
+// and folder. Places and times come from the catalog entries; photos the
+// catalog does not know have their time and camera read from their
+// metadata and an unknown place. Folders are cut to their first
+// folderDepth levels and include every file, sidecars too.
+func TreeStats(destRoot string, entries []CatalogEntry, folderDepth int) (Stats, error) {
+	if folderDepth < 1 {
+		folderDepth = 1
+	}
+	byPath := make(map[string]CatalogEntry, len(entries))
+	for _, entry := range entries {
+		byPath[entry.Path] = entry
+	}
+	rawExts := ParseExts(DefaultRawExts)
+	placeholder := geocode.DefaultPlaceholder
+
+	var stats Stats
+	counts := make(map[string]map[string]*StatsRow)
+	add := func(breakdown, key string, photos int, size int64) {
+		if counts[breakdown] == nil {
+			counts[breakdown] = make(map[string]*StatsRow)
+		}
+		row := counts[breakdown][key]
+		if row == nil {
+			row = &StatsRow{Key: key}
+			counts[breakdown][key] = row
+		}
+		row.Photos += photos
+		row.Bytes += size
+	}
+	or := func(value string) string {
+		if value == "" {
+			return placeholder
+		}
+		return value
+	}
+
+	err := filepath.WalkDir(destRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == stateDir || d.Name() == thumbsDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size := info.Size()
+		stats.Bytes += size
+
+		rel, err := filepath.Rel(destRoot, path)
+		if err != nil {
+			return err
+		}
+		folder := "."
+		if levels := strings.Split(filepath.ToSlash(filepath.Dir(rel)), "/"); levels[0] != "." {
+			folder = strings.Join(levels[:min(len(levels), folderDepth)], "/")
+		}
+		isPhoto := HasExt(path, ImageExts) || HasExt(path, VideoExts) || HasExt(path, rawExts)
+		if !isPhoto {
+			add(StatsFolder, folder, 0, size)
+			return nil
+		}
+		add(StatsFolder, folder, 1, size)
+		stats.Photos++
+
+		entry, found := byPath[absPath(path)]
+		if !found {
+			entry = CatalogEntry{Time: info.ModTime()}
+			if meta, err := exifinfo.Read(path); err == nil {
+				entry.Time, entry.Make, entry.Model = meta.Time, meta.Make, meta.Model
+			}
+		}
+		country := or(entry.Country)
+		add(StatsCountry, country, 1, size)
+		add(StatsState, country+" / "+or(entry.State), 1, size)
+		add(StatsCity, country+" / "+or(entry.City), 1, size)
+		add(StatsYear, entry.Time.Format("2006"), 1, size)
+		add(StatsMonth, entry.Time.Format("2006-01"), 1, size)
+		add(StatsCamera, cameraName(entry.Make, entry.Model, placeholder), 1, size)
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	for _, name := range statsBreakdowns {
+		breakdown := StatsBreakdown{Name: name, Rows: []StatsRow{}}
+		for _, row := range counts[name] {
+			breakdown.Rows = append(breakdown.Rows, *row)
+		}
+		rows := breakdown.Rows
+		sort.Slice(rows, func(i, j int) bool {
+			switch name {
+			case StatsYear, StatsMonth, StatsFolder:
+				// In time or tree order
+				return rows[i].Key < rows[j].Key
+			}
+			// Most photos first, then alphabetically
+			if rows[i].Photos != rows[j].Photos {
+				return rows[i].Photos > rows[j].Photos
+			}
+			return rows[i].Key < rows[j].Key
+		})
+		stats.Breakdowns = append(stats.Breakdowns, breakdown)
+	}
+	return stats, nil
+}
+
+// Write a stats report as aligned tables, as JSON, or as CSV with one
+// breakdown,key,photos,bytes line per row
+func WriteStats(w io.Writer, format string, stats Stats) error {
+	switch format {
+	case StatsTable:
+		return writeStatsTable(w, stats)
+	case StatsJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	case StatsCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"breakdown", "key", "photos", "bytes"})
+		for _, breakdown := range stats.Breakdowns {
+			for _, row := range breakdown.Rows {
+				cw.Write([]string{breakdown.Name, row.Key, strconv.Itoa(row.Photos), strconv.FormatInt(row.Bytes, 10)})
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown stats format %q, want %s, %s or %s", format, StatsTable, StatsJSON, StatsCSV)
+}
+
+func writeStatsTable(w io.Writer, stats Stats) error {
+	if _, err := fmt.Fprintf(w, "%d photos, %s in total\n", stats.Photos, formatBytes(stats.Bytes)); err != nil {
+		return err
+	}
+	for _, breakdown := range stats.Breakdowns {
+		if len(breakdown.Rows) == 0 {
+			continue
+		}
+		width, countWidth := 0, 0
+		for _, row := range breakdown.Rows {
+			width = max(width, len(row.Key))
+			countWidth = max(countWidth, len(strconv.Itoa(row.Photos)))
+		}
+		fmt.Fprintf(w, "\nBy %s:\n", breakdown.Name)
+		for _, row := range breakdown.Rows {
+			fmt.Fprintf(w, "  %-*s %*d  %s\n", width, row.Key, countWidth, row.Photos, formatBytes(row.Bytes))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package sorter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"pic-sorter/pkg/geocode"
+)
+
+// A file that could not be sorted and why
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e FileError) Unwrap() error {
+	return e.Err
+}
+
+// Encode as {"path": ..., "error": ...}
+func (e FileError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Path  string `json:"path"`
+		Error string `json:"error"`
+	}{e.Path, e.Err.Error()})
+}
+
+// A file moved, or planned to be moved, from Src to Dst
+type Move struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+// End-of-run totals
+type Summary struct {
+	DryRun bool   // nothing was moved; Moves lists what would have been
+	Mode   string // how files were placed, ModeMove, ModeCopy, ModeLink or ModeHardlink
+	Moves  []Move // every successful move including sidecars, in completion order
+
+	Moved       int            // files moved into the sorted tree
+	Sidecars    int            // sidecar files moved along with them, not counted in Moved
+	Failed      int            // files that could not be sorted
+	Duplicates  int            // images whose content was already sorted, see Options.OnDuplicate
+	Skipped     int            // images left in place because their name was taken
+	NoGPS       int            // images left in place because they have no GPS data
+	Filtered    int            // images left in place outside the selection, see Options.After
+	Unsorted    int            // images moved to Options.Unsorted, also counted in Moved
+	Quarantined int            // damaged images moved to Options.Quarantine, also counted in Failed
+	ByCountry   map[string]int // images moved per country, if sorted by location
+	BytesMoved  int64          // total size of the moved files
+	Errors      []FileError    // one entry per failed file
+	Stopped     string         // why a run limit ended the run early, see Options.MaxFiles; "" if it did not
+	Deferred    int            // images a run limit left for the next run
+
+	GeocodeRequests int           // reverse-geocode requests sent
+	GeocodeErrors   int           // those of them that failed
+	GeocodeTime     time.Duration // time spent waiting on those requests
+	CacheHits       int           // lookups answered from the cache
+
+	// Estimated request time avoided by the cache: CacheHits times the
+	// average request latency
+	TimeSavedByCache time.Duration
+}
+
+// Average latency of a reverse-geocode request, zero if none were sent
+func (s Summary) AverageLatency() time.Duration {
+	if s.GeocodeRequests == 0 {
+		return 0
+	}
+	return s.GeocodeTime / time.Duration(s.GeocodeRequests)
+}
+
+// Print the summary in human-readable form
+func (s Summary) Print(w io.Writer) {
+	verb := "Moved"
+	switch {
+	case s.DryRun:
+		verb = "Would move"
+	case s.Mode == ModeCopy:
+		verb = "Copied"
+	case linksOriginals(s.Mode):
+		verb = "Linked"
+	}
+	sidecars := ""
+	if s.Sidecars > 0 {
+		sidecars = fmt.Sprintf(" and %d sidecars", s.Sidecars)
+	}
+	fmt.Fprintf(w, "%s %d files%s (%s), %d failed, %d without GPS, %d duplicates, %d skipped\n",
+		verb, s.Moved, sidecars, formatBytes(s.BytesMoved), s.Failed, s.NoGPS, s.Duplicates, s.Skipped)
+	fmt.Fprintf(w, "Geocode requests: %d (avg %s), cache hits: %d, time saved by cache: ~%s\n",
+		s.GeocodeRequests, s.AverageLatency().Round(time.Millisecond),
+		s.CacheHits, s.TimeSavedByCache.Round(time.Millisecond))
+	if s.Unsorted > 0 {
+		fmt.Fprintf(w, "Unsorted: %d images could not be sorted and went to the unsorted folder\n", s.Unsorted)
+	}
+	if s.Quarantined > 0 {
+		fmt.Fprintf(w, "Quarantined: %d damaged images went to the quarantine folder\n", s.Quarantined)
+	}
+	if s.Filtered > 0 {
+		fmt.Fprintf(w, "Outside the selection: %d images were left alone\n", s.Filtered)
+	}
+	if s.Stopped != "" {
+		fmt.Fprintf(w, "Stopped early: %s; %d images are left for the next run\n", s.Stopped, s.Deferred)
+	}
+	if len(s.ByCountry) > 0 {
+		countries := make([]string, 0, len(s.ByCountry))
+		width := 0
+		for country := range s.ByCountry {
+			countries = append(countries, country)
+			if len(country) > width {
+				width = len(country)
+			}
+		}
+		// Most photos first, then alphabetically
+		sort.Slice(countries, func(i, j int) bool {
+			a, b := countries[i], countries[j]
+			if s.ByCountry[a] != s.ByCountry[b] {
+				return s.ByCountry[a] > s.ByCountry[b]
+			}
+			return a < b
+		})
+		fmt.Fprintf(w, "By country:\n")
+		for _, country := range countries {
+			fmt.Fprintf(w, "  %-*s %d\n", width, country, s.ByCountry[country])
+		}
+	}
+}
+
+// Format a byte count with a binary unit, e.g. "1.5 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Add the geocode cache counters of a run, the difference between the
+// stats before and after it, to the summary
+func (s *Summary) addCacheStats(before, after geocode.Stats) {
+	s.GeocodeRequests = after.Requests - before.Requests
+	s.GeocodeErrors = after.Errors - before.Errors
+	s.GeocodeTime = after.Elapsed - before.Elapsed
+	s.CacheHits = after.Hits - before.Hits
+	s.TimeSavedByCache = time.Duration(s.CacheHits) * s.AverageLatency()
+}
@@ -0,0 +1,67 @@
+package sorter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"pic-sorter/pkg/thumb"
+)
+
+// Folder under the destination root holding the thumbnails of
+// Options.ThumbSizes, one tree per size mirroring the sorted one
+const thumbsDir = ".thumbs"
+
+// Return the thumbnail path of size pixels for the photo at photoPath in
+// the tree under destRoot, e.g. .thumbs/256/France/Paris/a.jpg.jpg; the
+// photo's extension is kept so a RAW and a JPEG of one shot do not clash
+func ThumbPath(destRoot, photoPath string, size int) string {
+	rel, err := filepath.Rel(absPath(destRoot), absPath(photoPath))
+	if err != nil {
+		rel = filepath.Base(photoPath)
+	}
+	return filepath.Join(destRoot, thumbsDir, strconv.Itoa(size), rel+".jpg")
+}
+
+// Parse comma-separated thumbnail sizes such as "256,1024"
+func ParseThumbSizes(list string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		size, err := strconv.Atoi(field)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid thumbnail size %q, want pixels such as 256", field)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// Write the thumbnails of Options.ThumbSizes for a placed image. Images
+// that cannot be decoded and have no embedded preview, videos among them,
+// get none.
+func (s *Sorter) writeThumbs(image string) {
+	img, err := thumb.Decode(image)
+	if err != nil {
+		s.logger.Debug("cannot make thumbnail", "file", image, "reason", err)
+		return
+	}
+	for _, size := range s.Options.ThumbSizes {
+		data, err := thumb.Encode(img, size)
+		if err != nil {
+			s.logger.Warn("cannot make thumbnail", "file", image, "reason", err)
+			return
+		}
+		dst := ThumbPath(s.Options.DestRoot, image, size)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err == nil {
+			err = os.WriteFile(dst, data, 0o644)
+		}
+		if err != nil {
+			s.logger.Warn("cannot write thumbnail", "file", image, "reason", err)
+		}
+	}
+}
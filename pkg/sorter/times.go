@@ -0,0 +1,69 @@
+package sorter
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"pic-sorter/pkg/exifinfo"
+	"pic-sorter/pkg/exiftime"
+)
+
+// Return the shift of the capture time of an image: the one its camera
+// has in CameraTimeShifts, else TimeShift
+func timeShift(info exifinfo.Info, opts Options) time.Duration {
+	if len(opts.CameraTimeShifts) > 0 {
+		camera := cameraName(info.Make, info.Model, "")
+		for name, shift := range opts.CameraTimeShifts {
+			if strings.EqualFold(name, camera) {
+				return shift
+			}
+		}
+	}
+	return opts.TimeShift
+}
+
+// Shift the EXIF times of a placed JPEG, updating its checksum, the first
+// of sums. Other formats and read-only files are left alone; failures are
+// only logged: the image is sorted either way.
+func (s *Sorter) writeTimeShift(image string, shift time.Duration, sums []string) {
+	if !HasExt(image, []string{".jpg", ".jpeg"}) {
+		return
+	}
+	if info, err := os.Stat(image); err != nil || info.Mode().Perm()&0o200 == 0 {
+		s.logger.Info("not writing shifted time to read-only file", "file", image)
+		return
+	}
+	shifted, err := exiftime.Shift(image, shift)
+	switch {
+	case err != nil:
+		s.logger.Warn("cannot write shifted time", "file", image, "reason", err)
+		return
+	case !shifted:
+		return
+	}
+	s.logger.Debug("wrote shifted time", "file", image, "shift", shift)
+	if sum, err := fileSHA256(image); err == nil {
+		sums[0] = sum
+	}
+}
+
+// Set the modification time of placed files to taken, the capture time
+// of their image, so the tree sorts by date in any file browser. Access
+// times are kept. Returns the modification time each file had before,
+// zero for those that could not be changed.
+func (s *Sorter) touch(files []Move, taken time.Time) []time.Time {
+	before := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file.Dst)
+		if err == nil {
+			err = os.Chtimes(file.Dst, accessTime(info), taken)
+		}
+		if err != nil {
+			s.logger.Warn("cannot set modification time", "file", file.Dst, "reason", err)
+			continue
+		}
+		before[i] = info.ModTime()
+	}
+	return before
+}
@@ -0,0 +1,128 @@
+package sorter
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"pic-sorter/pkg/geocode"
+)
+
+// Defaults of Options.TripGap, Options.TripDistance and Options.EventGap
+const (
+	DefaultTripGap      = 24 * time.Hour
+	DefaultTripDistance = 300.0 // km
+	DefaultEventGap     = 4 * time.Hour
+)
+
+// Split moves into runs of photos taken close together, in capture time
+// order. A new run starts when more than gap passes between two photos
+// or when split reports true for them.
+func splitByTime(moves []*plannedMove, gap time.Duration, split func(prev, next *plannedMove) bool) [][]*plannedMove {
+	sort.SliceStable(moves, func(i, j int) bool { return moves[i].info.Time.Before(moves[j].info.Time) })
+
+	var runs [][]*plannedMove
+	for i, move := range moves {
+		if i == 0 {
+			runs = append(runs, []*plannedMove{move})
+			continue
+		}
+		prev := moves[i-1]
+		if move.info.Time.Sub(prev.info.Time) > gap || (split != nil && split(prev, move)) {
+			runs = append(runs, nil)
+		}
+		runs[len(runs)-1] = append(runs[len(runs)-1], move)
+	}
+	return runs
+}
+
+// Return the country most photos of a trip were taken in
+func mainCountry(trip []*plannedMove, placeholder string) string {
+	counts := make(map[string]int)
+	best := ""
+	for _, move := range trip {
+		country := move.location["country"]
+		if country == "" {
+			continue
+		}
+		counts[country]++
+		if counts[country] > counts[best] || (counts[country] == counts[best] && country < best) {
+			best = country
+		}
+	}
+	if best == "" {
+		return placeholder
+	}
+	return best
+}
+
+// Give every move a single trip folder, e.g. "2023-07_Italy_Trip".
+// Photos belong to the same trip while no more than TripGap passes
+// between consecutive shots and none is more than TripDistance km from
+// the one before. Trips that would get the same name are numbered.
+func clusterTrips(moves []plannedMove, opts Options) {
+	gap, distance := opts.TripGap, opts.TripDistance
+	if gap <= 0 {
+		gap = DefaultTripGap
+	}
+	if distance <= 0 {
+		distance = DefaultTripDistance
+	}
+	placeholder := opts.Placeholder
+	if placeholder == "" {
+		placeholder = geocode.DefaultPlaceholder
+	}
+
+	// Photos sorted by date for lack of GPS keep their date folders
+	var located []*plannedMove
+	for i := range moves {
+		if moves[i].location != nil {
+			located = append(located, &moves[i])
+		}
+	}
+	trips := splitByTime(located, gap, func(prev, next *plannedMove) bool {
+		return geocode.DistanceKm(prev.info.Lat, prev.info.Lon, next.info.Lat, next.info.Lon) > distance
+	})
+
+	seen := make(map[string]int)
+	for _, trip := range trips {
+		name := fmt.Sprintf("%s_%s_Trip", trip[0].info.Time.Format("2006-01"), mainCountry(trip, placeholder))
+		if seen[name]++; seen[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, seen[name])
+		}
+		for _, move := range trip {
+			move.levels = []string{name}
+		}
+	}
+}
+
+// Give every move a single event folder named after the dates it spans,
+// e.g. "2023-07-01" or "2023-07-01_to_2023-07-03". An event lasts while
+// no more than EventGap passes between consecutive shots; locations are
+// not needed. Events that would get the same name are numbered.
+func clusterEvents(moves []plannedMove, opts Options) {
+	gap := opts.EventGap
+	if gap <= 0 {
+		gap = DefaultEventGap
+	}
+
+	all := make([]*plannedMove, len(moves))
+	for i := range moves {
+		all[i] = &moves[i]
+	}
+
+	seen := make(map[string]int)
+	for _, event := range splitByTime(all, gap, nil) {
+		first, last := event[0].info.Time.Format("2006-01-02"), event[len(event)-1].info.Time.Format("2006-01-02")
+		name := first
+		if last != first {
+			name = first + "_to_" + last
+		}
+		if seen[name]++; seen[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, seen[name])
+		}
+		for _, move := range event {
+			move.levels = []string{name}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package sorter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Problems Verify reports
+const (
+	VerifyMissing   = "missing"   // in the manifest but gone from the tree
+	VerifyCorrupted = "corrupted" // content differs from the manifest
+	VerifyMisplaced = "misplaced" // the current layout rules put it in another folder
+)
+
+// A problem with one file of a sorted tree
+type VerifyIssue struct {
+	Path    string `json:"path"`
+	Problem string `json:"problem"`        // one of the Verify* problems
+	Want    string `json:"want,omitempty"` // VerifyMisplaced: the folder the file belongs in
+}
+
+// Result of Verify
+type VerifyReport struct {
+	Checked   int           `json:"checked"`   // files whose folder was checked
+	Unchecked int           `json:"unchecked"` // files whose folder could not be worked out, e.g. without GPS data
+	Verified  int           `json:"verified"`  // manifest files looked up
+	Issues    []VerifyIssue `json:"issues"`
+}
+
+// Count the issues with the given problem
+func (r VerifyReport) Count(problem string) int {
+	n := 0
+	for _, issue := range r.Issues {
+		if issue.Problem == problem {
+			n++
+		}
+	}
+	return n
+}
+
+// Print the issues and totals of a report
+func (r VerifyReport) Print(w io.Writer) {
+	for _, issue := range r.Issues {
+		if issue.Want != "" {
+			fmt.Fprintf(w, "%-9s %s (belongs in %s)\n", issue.Problem, issue.Path, issue.Want)
+		} else {
+			fmt.Fprintf(w, "%-9s %s\n", issue.Problem, issue.Path)
+		}
+	}
+	fmt.Fprintf(w, "Checked %d files (%d could not be placed) and %d manifest entries: %d misplaced, %d corrupted, %d missing\n",
+		r.Checked, r.Unchecked, r.Verified, r.Count(VerifyMisplaced), r.Count(VerifyCorrupted), r.Count(VerifyMissing))
+}
+
+// Write the report as JSON to path
+func (r VerifyReport) Write(path string) error {
+	if r.Issues == nil {
+		r.Issues = []VerifyIssue{}
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Read the checksums of the files a catalog or, if path ends in .jsonl,
+// a journal records, by absolute path
+func ReadManifest(path string) (map[string]string, error) {
+	sums := make(map[string]string)
+	if strings.EqualFold(filepath.Ext(path), ".jsonl") {
+		entries, err := ReadJournal(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			sums[entry.Dst] = entry.SHA256
+		}
+		return sums, nil
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	catalog, err := OpenCatalog(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range catalog.Query(CatalogQuery{}) {
+		sums[entry.Path] = entry.SHA256
+	}
+	return sums, nil
+}
+
+// Check the sorted tree under Options.DestRoot: every photo is read
+// again and planned the way Run would sort it, and must be in the folder
+// the plan gives it; names are not compared, as collisions rename files.
+// The files of manifest, if any, must be there with the recorded
+// checksum. Pic-sorter's own folder and the unsorted folder are skipped.
+func (s *Sorter) Verify(manifest map[string]string) (VerifyReport, error) {
+	opts := s.Options
+	layout, err := checkOptions(opts)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	s.logger = s.Logger
+	if s.logger == nil {
+		s.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	geocoder, _ := s.runGeocoder()
+
+	var report VerifyReport
+	var mu sync.Mutex
+	issue := func(path, problem, want string) {
+		mu.Lock()
+		defer mu.Unlock()
+		report.Issues = append(report.Issues, VerifyIssue{Path: path, Problem: problem, Want: want})
+	}
+
+	manifestPaths := make([]string, 0, len(manifest))
+	for path := range manifest {
+		manifestPaths = append(manifestPaths, path)
+	}
+	sort.Strings(manifestPaths)
+	parallel(manifestPaths, opts.Workers, nil, func(path string) {
+		sum, err := fileSHA256(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			issue(path, VerifyMissing, "")
+		case err != nil:
+			s.logger.Error("cannot read file", "file", path, "reason", err)
+			issue(path, VerifyCorrupted, "")
+		case manifest[path] != "" && sum != manifest[path]:
+			issue(path, VerifyCorrupted, "")
+		}
+		mu.Lock()
+		report.Verified++
+		mu.Unlock()
+	})
+
+	walk := opts
+	walk.Recursive = true
+	walk.Exclude = append(slices.Clip(opts.Exclude), stateDir, thumbsDir)
+	if opts.Unsorted != "" {
+		walk.Exclude = append(walk.Exclude, opts.Unsorted)
+	}
+	paths, _, err := findFiles(opts.DestRoot, walk)
+	if err != nil {
+		return report, err
+	}
+
+	var moves []plannedMove
+	parallel(groupImages(paths, opts), opts.Workers, nil, func(group []string) {
+		result := resolveGroup(group, geocoder, opts, layout, s.Track, s.Timezones)
+		var hash uint64
+		hashed := false
+		if opts.Bursts != "" && result.err == nil {
+			hash, hashed = groupHash(group)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if errors.Is(result.err, ErrFiltered) {
+			return
+		}
+		if result.err != nil {
+			s.logger.Warn("cannot work out the folder of file", "file", group[0], "reason", result.err)
+			report.Unchecked += len(group)
+			return
+		}
+		for _, imagePath := range group {
+			moves = append(moves, plannedMove{
+				imagePath: imagePath,
+				levels:    result.levels,
+				info:      result.info,
+				location:  result.location,
+				shot:      group[0],
+				hash:      hash,
+				hashed:    hashed,
+			})
+		}
+	})
+	planTree(moves, opts, layout)
+
+	for _, move := range moves {
+		report.Checked++
+		// Paths are absolute like those of the manifest
+		path, want := absPath(move.imagePath), absPath(filepath.Dir(destPath(opts, move.imagePath, move.levels)))
+		if filepath.Dir(path) != want {
+			issue(path, VerifyMisplaced, want)
+		}
+	}
+	sort.Slice(report.Issues, func(i, j int) bool { return report.Issues[i].Path < report.Issues[j].Path })
+	return report, nil
+}
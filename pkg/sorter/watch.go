@@ -0,0 +1,117 @@
+package sorter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"pic-sorter/pkg/geocode"
+)
+
+// Defaults of WatchOptions
+const (
+	DefaultWatchInterval = 10 * time.Second
+	DefaultWatchSettle   = 30 * time.Second
+)
+
+// Options of Sorter.Watch
+type WatchOptions struct {
+	Interval time.Duration // time between scans of the directory
+	Settle   time.Duration // how long the directory must be unchanged before sorting
+}
+
+// Size and modification time of a file seen by Watch
+type watchedFile struct {
+	size  int64
+	mod   time.Time
+	tried bool // handed to Run; not retried until it changes
+}
+
+// Keep only the paths for which keep returns true
+func filterPaths(paths []string, keep func(string) bool) []string {
+	var kept []string
+	for _, path := range paths {
+		if keep(path) {
+			kept = append(kept, path)
+		}
+	}
+	return kept
+}
+
+// Sort the files of directory as they appear, until ctx is done. The
+// directory is polled every Interval; once no file has been added or
+// changed for Settle, the new files are sorted with Run, so files still
+// being copied are never moved mid-write. Files that stay behind, such
+// as images without GPS, are only tried again once they change. report,
+// if not nil, is called with the result of every pass. Watch returns
+// when ctx is done, or with the error if a geocoder ban aborts a pass.
+func (s *Sorter) Watch(ctx context.Context, directory string, opts WatchOptions, report func(Summary, error)) error {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultWatchInterval
+	}
+	if opts.Settle < 0 {
+		opts.Settle = 0
+	}
+
+	seen := make(map[string]*watchedFile)
+	lastChange := time.Now()
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		paths, sidecars, err := findFiles(directory, s.Options)
+		if err != nil {
+			return err
+		}
+
+		current := make(map[string]bool, len(paths)+len(sidecars))
+		pending := false
+		for _, path := range append(paths, sidecars...) {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			current[path] = true
+			file, ok := seen[path]
+			if !ok || file.size != info.Size() || !file.mod.Equal(info.ModTime()) {
+				seen[path] = &watchedFile{size: info.Size(), mod: info.ModTime()}
+				lastChange = time.Now()
+				file = seen[path]
+			}
+			if !file.tried {
+				pending = true
+			}
+		}
+		for path := range seen {
+			if !current[path] {
+				delete(seen, path)
+			}
+		}
+
+		if pending && time.Since(lastChange) >= opts.Settle {
+			ready := s.Ready
+			s.Ready = func(path string) bool {
+				file, ok := seen[path]
+				return ok && !file.tried && (ready == nil || ready(path))
+			}
+			summary, err := s.Run(directory)
+			s.Ready = ready
+			for _, file := range seen {
+				file.tried = true
+			}
+			if report != nil {
+				report(summary, err)
+			}
+			if errors.Is(err, geocode.ErrBanned) {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
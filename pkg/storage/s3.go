@@ -0,0 +1,394 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default endpoint of AWS S3
+const DefaultS3Endpoint = "https://s3.amazonaws.com"
+
+// Default region of requests when none is configured
+const DefaultS3Region = "us-east-1"
+
+// Largest object a single PUT or server-side copy can write; bigger ones
+// would need a multipart upload, which this client does not do
+const MaxS3ObjectSize = 5 << 30
+
+// Connection settings shared by the buckets of one S3-compatible service
+type S3Options struct {
+	Endpoint     string // e.g. DefaultS3Endpoint or "http://localhost:9000" for MinIO
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string       // for temporary credentials
+	PathStyle    bool         // address buckets as endpoint/bucket rather than bucket.endpoint
+	Client       *http.Client // nil uses http.DefaultClient
+}
+
+// Read the S3 settings from the environment variables the AWS tools use:
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_REGION
+// (or AWS_DEFAULT_REGION) and AWS_ENDPOINT_URL. Custom endpoints, like
+// MinIO, are addressed path-style.
+func S3Config() S3Options {
+	opts := S3Options{
+		Endpoint:     os.Getenv("AWS_ENDPOINT_URL"),
+		Region:       os.Getenv("AWS_REGION"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if opts.Region == "" {
+		opts.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = DefaultS3Endpoint
+	} else {
+		opts.PathStyle = true
+	}
+	return opts
+}
+
+// Return the bucket of the given name
+func (o S3Options) Bucket(name string) (*S3, error) {
+	if o.AccessKey == "" || o.SecretKey == "" {
+		return nil, fmt.Errorf("S3 needs credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if o.Region == "" {
+		o.Region = DefaultS3Region
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(o.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", o.Endpoint)
+	}
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3{S3Options: o, BucketName: name, endpoint: endpoint, client: client}, nil
+}
+
+// A bucket of an S3-compatible service, accessed with Signature Version 4
+type S3 struct {
+	S3Options
+	BucketName string
+
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// Error returned by the S3 API
+type S3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3: HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("S3: %s: %s", e.Code, e.Message)
+}
+
+func (e *S3Error) Unwrap() error {
+	if e.Status == http.StatusNotFound {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// Hex SHA-256 of an empty payload
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Send a signed request for key (the bucket itself if "") and return the
+// response, or an *S3Error for a status of 300 or more
+func (b *S3) do(method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u := *b.endpoint
+	if b.PathStyle {
+		u.Path += "/" + b.BucketName
+	} else {
+		u.Host = b.BucketName + "." + u.Host
+	}
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payload := emptySHA256
+	if body != nil {
+		payload = "UNSIGNED-PAYLOAD"
+		req.ContentLength = size
+	}
+	b.sign(req, payload, time.Now().UTC())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, readS3Error(resp)
+	}
+	return resp, nil
+}
+
+// Parse the error document of a failed response
+func readS3Error(resp *http.Response) error {
+	s3Err := &S3Error{Status: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	xml.Unmarshal(data, s3Err)
+	return s3Err
+}
+
+// Add the Signature Version 4 headers to req
+func (b *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if b.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", b.SessionToken)
+	}
+
+	// Sign the host and every x-amz header
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + b.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+b.SecretKey), day)
+	for _, part := range []string{b.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Percent-encode s the way Signature Version 4 expects: everything but
+// unreserved characters, and '/' too if encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Encode query parameters sorted by name, as signing requires
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Response of ListObjectsV2
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (b *S3) List(prefix string) ([]Object, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := b.do(http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var page listResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: %w", b.URL(prefix), err)
+		}
+		for _, c := range page.Contents {
+			// Folder markers created by consoles hold no photo
+			if strings.HasSuffix(c.Key, "/") {
+				continue
+			}
+			objects = append(objects, Object{Key: c.Key, Size: c.Size, ModTime: c.LastModified, ETag: strings.Trim(c.ETag, `"`)})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (b *S3) Stat(key string) (Object, error) {
+	resp, err := b.do(http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Object{Key: key, Size: resp.ContentLength, ModTime: modTime, ETag: strings.Trim(resp.Header.Get("ETag"), `"`)}, nil
+}
+
+func (b *S3) OpenRange(key string, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	switch {
+	case length > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case length < 0 && offset > 0:
+		header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	case length == 0:
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	resp, err := b.do(http.MethodGet, key, nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Upload r in a single request, so size must be at most
+// MaxS3ObjectSize. An existing object is never replaced: the upload is
+// conditional on the key being free.
+func (b *S3) Put(key string, r io.Reader, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("uploading %s: unknown size", b.URL(key))
+	}
+	if size > MaxS3ObjectSize {
+		return tooLarge(b.URL(key), size)
+	}
+	header := http.Header{"If-None-Match": {"*"}}
+	resp, err := b.do(http.MethodPut, key, nil, header, r, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Copy an object of at most MaxS3ObjectSize within the bucket on the
+// server
+func (b *S3) Copy(srcKey, dstKey string) error {
+	header := http.Header{
+		"X-Amz-Copy-Source": {uriEncode("/"+b.BucketName+"/"+srcKey, false)},
+		"If-None-Match":     {"*"},
+	}
+	resp, err := b.do(http.MethodPut, dstKey, nil, header, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// A copy can fail after the 200 status has been sent
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		s3Err := &S3Error{Status: resp.StatusCode}
+		xml.Unmarshal(data, s3Err)
+		return s3Err
+	}
+	return nil
+}
+
+// Error for an object S3 cannot take in one request
+func tooLarge(url string, size int64) error {
+	return fmt.Errorf("uploading %s: %d bytes is over the %d GiB S3 allows in a single upload", url, size, MaxS3ObjectSize>>30)
+}
+
+func (b *S3) Delete(key string) error {
+	resp, err := b.do(http.MethodDelete, key, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *S3) URL(key string) string {
+	return "s3://" + b.BucketName + "/" + key
+}
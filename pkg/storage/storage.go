@@ -0,0 +1,218 @@
+// Package storage abstracts where photos are read from and sorted into:
+// a local directory or an S3-compatible bucket such as AWS S3 or MinIO.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A stored file
+type Object struct {
+	Key     string // '/' separated, relative to the storage root
+	Size    int64
+	ModTime time.Time
+	ETag    string // content tag of S3 objects; "" for local files
+}
+
+// Files addressed by key. Missing keys are reported as fs.ErrNotExist.
+type Storage interface {
+	List(prefix string) ([]Object, error) // every object below prefix, sorted by key
+	Stat(key string) (Object, error)
+	// Read length bytes from offset; a negative length reads to the end
+	OpenRange(key string, offset, length int64) (io.ReadCloser, error)
+	Put(key string, r io.Reader, size int64) error
+	Delete(key string) error
+	URL(key string) string // location of key for logs and journals
+}
+
+// Implemented by storages that can copy objects without downloading them
+type Copier interface {
+	Copy(srcKey, dstKey string) error
+}
+
+// Report whether location names a remote storage rather than a path
+func IsURL(location string) bool {
+	return strings.HasPrefix(location, "s3://")
+}
+
+// Open the storage holding location and return the key within it:
+// "s3://bucket/some/prefix" opens bucket with S3Config, anything else is
+// a local path.
+func Open(location string) (Storage, string, error) {
+	if rest, ok := strings.CutPrefix(location, "s3://"); ok {
+		bucket, key, _ := strings.Cut(rest, "/")
+		if bucket == "" {
+			return nil, "", fmt.Errorf("no bucket in %q", location)
+		}
+		s3, err := S3Config().Bucket(bucket)
+		if err != nil {
+			return nil, "", err
+		}
+		return s3, strings.Trim(key, "/"), nil
+	}
+	abs, err := filepath.Abs(location)
+	if err != nil {
+		return nil, "", err
+	}
+	return Local{}, filepath.ToSlash(abs), nil
+}
+
+// Copy an object between storages, on the server if both are the same
+// bucket. The copy must have the size of the source. Objects over
+// MaxS3ObjectSize are refused before anything is sent to S3.
+func Transfer(src Storage, srcKey string, dst Storage, dstKey string, size int64) error {
+	if _, ok := dst.(*S3); ok && size > MaxS3ObjectSize {
+		return tooLarge(dst.URL(dstKey), size)
+	}
+	if copier, ok := dst.(Copier); ok && Same(src, dst) {
+		if err := copier.Copy(srcKey, dstKey); err != nil {
+			return err
+		}
+	} else {
+		r, err := src.OpenRange(srcKey, 0, -1)
+		if err != nil {
+			return err
+		}
+		err = dst.Put(dstKey, r, size)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	copied, err := dst.Stat(dstKey)
+	if err != nil {
+		return err
+	}
+	if copied.Size != size {
+		dst.Delete(dstKey)
+		return fmt.Errorf("copy of %s has %d bytes, want %d", src.URL(srcKey), copied.Size, size)
+	}
+	return nil
+}
+
+// Report whether a and b address the same files
+func Same(a, b Storage) bool {
+	if a, ok := a.(*S3); ok {
+		b, ok := b.(*S3)
+		return ok && a.Endpoint == b.Endpoint && a.BucketName == b.BucketName
+	}
+	_, aLocal := a.(Local)
+	_, bLocal := b.(Local)
+	return aLocal && bLocal
+}
+
+// Local files; keys are absolute '/' separated paths
+type Local struct{}
+
+func (Local) List(prefix string) ([]Object, error) {
+	var objects []Object
+	root := filepath.FromSlash(prefix)
+	err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: filepath.ToSlash(p), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, err
+}
+
+func (Local) Stat(key string) (Object, error) {
+	info, err := os.Stat(filepath.FromSlash(key))
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (Local) OpenRange(key string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.FromSlash(key))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+// Write through a temporary file so a failed upload never leaves a torn
+// file; an existing file is never replaced
+func (Local) Put(key string, r io.Reader, size int64) error {
+	dst := filepath.FromSlash(key)
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed into place
+
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (Local) Delete(key string) error {
+	err := os.Remove(filepath.FromSlash(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (Local) URL(key string) string {
+	return filepath.FromSlash(key)
+}
+
+// Join key parts with '/', ignoring empty ones
+func Join(parts ...string) string {
+	var nonEmpty []string
+	for _, part := range parts {
+		if part = strings.Trim(part, "/"); part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	key := path.Join(nonEmpty...)
+	if len(parts) > 0 && strings.HasPrefix(parts[0], "/") {
+		key = "/" + key
+	}
+	return key
+}
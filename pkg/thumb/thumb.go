@@ -0,0 +1,134 @@
+// Package thumb makes small JPEG previews of images.
+package thumb
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"strings"
+
+	"pic-sorter/pkg/exifinfo"
+)
+
+// Default length of the longer side of a thumbnail, in pixels
+const DefaultSize = 256
+
+// JPEG quality of generated thumbnails
+const quality = 80
+
+// Create a JPEG thumbnail of an image whose longer side is at most size
+// pixels. Formats the standard library cannot decode, such as RAW and
+// HEIC, fall back to the preview embedded in their EXIF data.
+func Generate(imagePath string, size int) ([]byte, error) {
+	img, err := Decode(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(img, size)
+}
+
+// Encode a decoded image as a JPEG thumbnail whose longer side is at most
+// size pixels, for several sizes from one Decode
+func Encode(img image.Image, size int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, Scale(img, size), &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode an image, falling back to the preview in its EXIF data for
+// formats the standard library cannot decode
+func Decode(imagePath string) (image.Image, error) {
+	img, err := decodeFile(imagePath)
+	if err == nil {
+		return img, nil
+	}
+	data, thumbErr := exifinfo.Thumbnail(imagePath)
+	if thumbErr != nil {
+		return nil, err
+	}
+	img, _, err = image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+func decodeFile(imagePath string) (image.Image, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// Shrink img so that its longer side is at most size pixels, averaging
+// the source pixels that fall into each target pixel. Smaller images
+// are returned as they are.
+func Scale(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if size <= 0 || (w <= size && h <= size) {
+		return img
+	}
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	tw, th = max(tw, 1), max(th, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// Render an image as text for a true-colour terminal, width characters
+// wide. Each character shows two pixels stacked on top of each other.
+func Terminal(imagePath string, width int) (string, error) {
+	img, err := Decode(imagePath)
+	if err != nil {
+		return "", err
+	}
+
+	// Terminal cells are about twice as high as wide, which the two
+	// pixels per cell make up for
+	b := img.Bounds()
+	small := Scale(img, max(width, width*b.Dy()/max(b.Dx(), 1)))
+	sb := small.Bounds()
+
+	var out strings.Builder
+	for y := sb.Min.Y; y < sb.Max.Y; y += 2 {
+		for x := sb.Min.X; x < sb.Max.X; x++ {
+			tr, tg, tb, _ := small.At(x, y).RGBA()
+			br, bg, bb := tr, tg, tb
+			if y+1 < sb.Max.Y {
+				br, bg, bb, _ = small.At(x, y+1).RGBA()
+			}
+			fmt.Fprintf(&out, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", tr>>8, tg>>8, tb>>8, br>>8, bg>>8, bb>>8)
+		}
+		out.WriteString("\x1b[0m\n")
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,109 @@
+package xmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Signature that starts the APP1 segment of an XMP packet
+var xmpSignature = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// Largest XMP packet a single APP1 segment can hold
+const maxSegmentPacket = 0xffff - 2 - 29
+
+// A marker segment in the header of a JPEG file
+type segment struct {
+	marker     byte
+	start, end int // offsets of the whole segment, marker included
+}
+
+// Split the header of a JPEG file into its marker segments, up to but
+// not including the start of scan
+func jpegSegments(data []byte) ([]segment, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("not a JPEG file")
+	}
+	var segments []segment
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, errors.New("corrupt JPEG header")
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			pos++ // fill byte
+			continue
+		}
+		if marker == 0xda { // start of scan: the image data follows
+			return segments, nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("corrupt JPEG header")
+		}
+		segments = append(segments, segment{marker: marker, start: pos, end: end})
+		pos = end
+	}
+}
+
+// Write p into the XMP packet of a JPEG file, adding one if it has none.
+// Only header segments are rewritten; the compressed image data is copied
+// byte for byte, so the image is not re-encoded.
+func WriteJPEG(path string, p Place) error {
+	if p.empty() {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	segments, err := jpegSegments(data)
+	if err != nil {
+		return err
+	}
+
+	// Add to an existing packet, or insert a new one after the JFIF and
+	// Exif segments, which readers expect first
+	insert, replace := 2, -1
+	for i, seg := range segments {
+		body := data[seg.start+4 : seg.end]
+		if seg.marker == 0xe1 && bytes.HasPrefix(body, xmpSignature) {
+			replace = i
+			break
+		}
+		if seg.marker == 0xe0 || seg.marker == 0xe1 {
+			insert = seg.end
+		}
+	}
+
+	packet := Packet(p)
+	cut := segment{start: insert, end: insert}
+	if replace >= 0 {
+		cut = segments[replace]
+		if packet, err = Merge(data[cut.start+4+len(xmpSignature):cut.end], p); err != nil {
+			return err
+		}
+	}
+	if len(packet) > maxSegmentPacket {
+		return fmt.Errorf("XMP packet of %d bytes does not fit in a JPEG segment", len(packet))
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data) + len(packet) + 64)
+	out.Write(data[:cut.start])
+	out.Write([]byte{0xff, 0xe1})
+	binary.Write(&out, binary.BigEndian, uint16(2+len(xmpSignature)+len(packet)))
+	out.Write(xmpSignature)
+	out.Write(packet)
+	out.Write(data[cut.end:])
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, out.Bytes(), info.Mode().Perm())
+}
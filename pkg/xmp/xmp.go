@@ -0,0 +1,127 @@
+// Package xmp writes place names into XMP metadata, embedded in JPEG
+// files or as sidecar files, so photo managers can show where a photo
+// was taken.
+package xmp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Place names in the IPTC location fields of XMP
+type Place struct {
+	Country     string // photoshop:Country
+	CountryCode string // Iptc4xmpCore:CountryCode, ISO 3166 alpha-2 or alpha-3
+	State       string // photoshop:State
+	City        string // photoshop:City
+}
+
+func (p Place) empty() bool {
+	return p == Place{}
+}
+
+// Reported when the metadata already names a place; existing names are
+// never overwritten
+var ErrHasPlace = errors.New("metadata already has place names")
+
+// Properties whose presence means a packet already names a place
+var placeProperties = []string{"photoshop:Country", "photoshop:State", "photoshop:City", "Iptc4xmpCore:CountryCode"}
+
+// The rdf:Description holding the place names
+func description(p Place) string {
+	var b strings.Builder
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:photoshop=\"http://ns.adobe.com/photoshop/1.0/\"\n")
+	b.WriteString("    xmlns:Iptc4xmpCore=\"http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/\"")
+	for _, attr := range []struct{ name, value string }{
+		{"photoshop:Country", p.Country},
+		{"photoshop:State", p.State},
+		{"photoshop:City", p.City},
+		{"Iptc4xmpCore:CountryCode", strings.ToUpper(p.CountryCode)},
+	} {
+		if attr.value == "" {
+			continue
+		}
+		b.WriteString("\n    " + attr.name + "=\"")
+		xml.EscapeText(&b, []byte(attr.value))
+		b.WriteString("\"")
+	}
+	b.WriteString("/>\n")
+	return b.String()
+}
+
+// Return a complete XMP packet holding only p
+func Packet(p Place) []byte {
+	return []byte("<?xpacket begin=\"\uFEFF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n" +
+		"<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n" +
+		" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n" +
+		description(p) +
+		" </rdf:RDF>\n" +
+		"</x:xmpmeta>\n" +
+		"<?xpacket end=\"w\"?>")
+}
+
+// Add p to an existing XMP packet as a new rdf:Description, which
+// readers merge with the others. Fails with ErrHasPlace if the packet
+// already names a place.
+func Merge(packet []byte, p Place) ([]byte, error) {
+	for _, prop := range placeProperties {
+		if bytes.Contains(packet, []byte(prop)) {
+			return nil, ErrHasPlace
+		}
+	}
+	end := bytes.LastIndex(packet, []byte("</rdf:RDF>"))
+	if end < 0 {
+		return nil, errors.New("XMP packet has no rdf:RDF element")
+	}
+	merged := make([]byte, 0, len(packet)+512)
+	merged = append(merged, packet[:end]...)
+	merged = append(merged, description(p)...)
+	merged = append(merged, ' ')
+	return append(merged, packet[end:]...), nil
+}
+
+// Write p into the XMP sidecar at path, creating it if needed. created
+// reports whether the file is new.
+func WriteSidecar(path string, p Place) (created bool, err error) {
+	if p.empty() {
+		return false, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, writeFile(path, Packet(p), 0o644)
+	}
+	if err != nil {
+		return false, err
+	}
+	merged, err := Merge(data, p)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return false, writeFile(path, merged, info.Mode().Perm())
+}
+
+// Replace the contents of path through a temporary file, so a crash never
+// leaves it half written, keeping its modification time if it exists
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	info, statErr := os.Stat(path)
+	tmp := path + ".xmp-tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if statErr == nil {
+		os.Chtimes(path, info.ModTime(), info.ModTime())
+	}
+	return nil
+}
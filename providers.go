@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"pic-sorter/pkg/geocode"
+)
+
+// Flag values that configure the geocoding providers
+type providerConfig struct {
+	language    string
+	zoom        int    // Nominatim detail level
+	apiKey      string // overrides the provider's environment variable
+	params      url.Values
+	banPattern  string
+	geonamesDir string
+	client      *http.Client // used by the network providers
+	rate        float64      // requests per second to each network provider
+}
+
+// Environment variables holding the API key of each provider that needs one
+var apiKeyEnv = map[string]string{
+	"google":     "GOOGLE_MAPS_API_KEY",
+	"mapbox":     "MAPBOX_ACCESS_TOKEN",
+	"locationiq": "LOCATIONIQ_API_KEY",
+}
+
+// Provider names accepted by -provider
+const providerNames = "nominatim, photon, locationiq, mapbox, google or offline, or several separated by commas to fall back on"
+
+// Return the API key for a provider from the flag or its environment variable
+func (c providerConfig) key(name string) (string, error) {
+	if c.apiKey != "" {
+		return c.apiKey, nil
+	}
+	if key := os.Getenv(apiKeyEnv[name]); key != "" {
+		return key, nil
+	}
+	return "", fmt.Errorf("provider %s needs an API key: set %s or pass -api-key", name, apiKeyEnv[name])
+}
+
+// Apply the Nominatim-protocol flags to a Nominatim or LocationIQ geocoder
+func (c providerConfig) configureNominatim(n *geocode.Nominatim) error {
+	n.Client = c.client
+	n.Language = c.language
+	n.Zoom = c.zoom
+	if n.Params == nil {
+		n.Params = url.Values{}
+	}
+	for key, values := range c.params {
+		n.Params[key] = values
+	}
+
+	var err error
+	n.BanPattern, err = c.banRegexp()
+	return err
+}
+
+// Compile -ban-pattern; nil if it is empty
+func (c providerConfig) banRegexp() (*regexp.Regexp, error) {
+	if c.banPattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(c.banPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -ban-pattern: %w", err)
+	}
+	return re, nil
+}
+
+// Reports whether a provider speaks the Nominatim protocol and may ban
+// clients, see geocode.ErrBanned
+func bansClients(name string) bool {
+	return name == "nominatim" || name == "locationiq"
+}
+
+// Create the geocoder for a provider name
+func newProvider(name string, c providerConfig) (geocode.Geocoder, error) {
+	switch name {
+	case "nominatim":
+		n := geocode.NewNominatim()
+		return n, c.configureNominatim(n)
+
+	case "locationiq":
+		key, err := c.key(name)
+		if err != nil {
+			return nil, err
+		}
+		n := geocode.NewLocationIQ(key)
+		return n, c.configureNominatim(n)
+
+	case "photon":
+		p := geocode.NewPhoton()
+		p.Client = c.client
+		p.Language = c.language
+		return p, nil
+
+	case "mapbox":
+		key, err := c.key(name)
+		if err != nil {
+			return nil, err
+		}
+		m := geocode.NewMapbox(key)
+		m.Client = c.client
+		m.Language = c.language
+		return m, nil
+
+	case "google":
+		key, err := c.key(name)
+		if err != nil {
+			return nil, err
+		}
+		g := geocode.NewGoogle(key)
+		g.Client = c.client
+		g.Language = c.language
+		return g, nil
+
+	case "offline":
+		if c.geonamesDir == "" {
+			return nil, fmt.Errorf("provider offline needs -geonames-dir")
+		}
+		o, err := geocode.LoadGeoNames(c.geonamesDir)
+		if err != nil {
+			return nil, err
+		}
+		return o, nil
+	}
+
+	return nil, fmt.Errorf("unknown provider %q, want %s", name, providerNames)
+}
+
+// Split a -provider value such as "nominatim,photon,offline" into the
+// provider names of the chain
+func providerChain(spec string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("provider %s is listed twice in -provider", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("-provider is empty, want %s", providerNames)
+	}
+	return names, nil
+}
+
+// Name a chain of providers in cache file names, e.g. "nominatim+photon"
+func chainName(names []string) string {
+	return strings.Join(names, "+")
+}
+
+// Report whether a chain has a network provider, whose answers are worth
+// caching
+func onlineChain(names []string) bool {
+	for _, name := range names {
+		if name != "offline" {
+			return true
+		}
+	}
+	return false
+}
+
+// Create the geocoder for a chain of provider names, each network
+// provider rate limited on its own, retries of its client included.
+// Several providers are tried in order, falling back on the next when
+// one fails. The offline geocoder is also returned if it is in the chain.
+func newGeocoder(names []string, c providerConfig, logger *slog.Logger) (geocode.Geocoder, *geocode.Offline, error) {
+	failover := geocode.NewFailover()
+	failover.OnFailover = func(provider string, err error) {
+		logger.Warn("geocoding provider failed, trying the next", "provider", provider, "reason", err)
+	}
+	var offline *geocode.Offline
+	for _, name := range names {
+		var ban *regexp.Regexp
+		if bansClients(name) {
+			var err error
+			if ban, err = c.banRegexp(); err != nil {
+				return nil, nil, err
+			}
+		}
+		provider := c
+		provider.client = geocode.ProviderClient(c.client, c.rate, ban)
+		geocoder, err := newProvider(name, provider)
+		if err != nil {
+			return nil, nil, err
+		}
+		if o, ok := geocoder.(*geocode.Offline); ok {
+			offline = o
+		}
+		if len(names) == 1 {
+			return geocoder, offline, nil
+		}
+		failover.Add(name, geocoder)
+	}
+	return failover, offline, nil
+}
+
+// Build the User-Agent, appending the contact address if one is given
+func userAgent(agent, contact string) string {
+	if contact == "" {
+		return agent
+	}
+	return fmt.Sprintf("%s contact: %s", agent, contact)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pic-sorter/pkg/sorter"
+)
+
+// Review states of a destination folder
+const (
+	reviewPending  = "pending"
+	reviewApproved = "approved"
+	reviewRejected = "rejected"
+)
+
+// The planned moves into one destination folder
+type reviewGroup struct {
+	folder string // relative to the destination root
+	moves  []sorter.Move
+	status string
+}
+
+// Lets the user approve, reject and redirect the planned moves folder by
+// folder before any of them is carried out
+type reviewer struct {
+	in     *bufio.Reader
+	out    io.Writer
+	dest   string
+	groups []*reviewGroup
+}
+
+// Group moves by the destination folder under dest
+func groupMoves(moves []sorter.Move, dest string) []*reviewGroup {
+	byFolder := make(map[string]*reviewGroup)
+	var groups []*reviewGroup
+	for _, move := range moves {
+		folder := filepath.Dir(move.Dst)
+		if rel, err := filepath.Rel(absPath(dest), folder); err == nil {
+			folder = rel
+		}
+		group, ok := byFolder[folder]
+		if !ok {
+			group = &reviewGroup{folder: filepath.ToSlash(folder), status: reviewPending}
+			byFolder[folder] = group
+			groups = append(groups, group)
+		}
+		group.moves = append(group.moves, move)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].folder < groups[j].folder })
+	return groups
+}
+
+// Print the numbered folders with their file counts and states
+func (r *reviewer) list() {
+	width := len("Folder")
+	for _, group := range r.groups {
+		width = max(width, len(group.folder))
+	}
+	fmt.Fprintf(r.out, "\n%4s  %-*s  %5s  %s\n", "#", width, "Folder", "Files", "Status")
+	for i, group := range r.groups {
+		fmt.Fprintf(r.out, "%4d  %-*s  %5d  %s\n", i+1, width, group.folder, len(group.moves), group.status)
+	}
+}
+
+// Print the commands the review understands
+func (r *reviewer) help() {
+	fmt.Fprint(r.out, `Commands:
+  a N...      approve folders: numbers, ranges such as 2-5, or all
+  r N...      reject folders
+  m N FOLDER  move the files of folder N to FOLDER under the destination instead
+  s N         show the files of folder N
+  l           list the folders again
+  x           carry out the approved moves
+  q           quit without moving anything
+`)
+}
+
+// Parse folder numbers such as "1 3-5,7" or "all"
+func (r *reviewer) selection(args []string) ([]*reviewGroup, error) {
+	var groups []*reviewGroup
+	for _, arg := range strings.FieldsFunc(strings.Join(args, " "), func(c rune) bool { return c == ' ' || c == ',' }) {
+		if arg == "all" {
+			return r.groups, nil
+		}
+		from, to, isRange := strings.Cut(arg, "-")
+		first, err := strconv.Atoi(from)
+		last := first
+		if err == nil && isRange {
+			last, err = strconv.Atoi(to)
+		}
+		if err != nil || first < 1 || last > len(r.groups) || first > last {
+			return nil, fmt.Errorf("no folder %q, want numbers from 1 to %d", arg, len(r.groups))
+		}
+		groups = append(groups, r.groups[first-1:last]...)
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("name the folders by number, or all")
+	}
+	return groups, nil
+}
+
+// Send the files of a group to another folder under the destination
+func (r *reviewer) redirect(group *reviewGroup, levels []string) {
+	folder := filepath.Join(levels...)
+	for i, move := range group.moves {
+		group.moves[i].Dst = filepath.Join(absPath(r.dest), folder, filepath.Base(move.Dst))
+	}
+	group.folder = filepath.ToSlash(folder)
+}
+
+// Run the review until the user carries out or abandons it, and return
+// the approved moves, or false if nothing is to be moved
+func (r *reviewer) run() ([]sorter.Move, bool) {
+	r.list()
+	r.help()
+	for {
+		fmt.Fprint(r.out, "> ")
+		line, err := r.in.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(r.out)
+			return nil, false
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch command, args := fields[0], fields[1:]; command {
+		case "a", "r":
+			groups, err := r.selection(args)
+			if err != nil {
+				fmt.Fprintln(r.out, err)
+				continue
+			}
+			for _, group := range groups {
+				group.status = reviewApproved
+				if command == "r" {
+					group.status = reviewRejected
+				}
+			}
+			r.list()
+		case "m":
+			if len(args) < 2 {
+				fmt.Fprintln(r.out, "usage: m N FOLDER")
+				continue
+			}
+			groups, err := r.selection(args[:1])
+			levels := parseLevels(strings.Join(args[1:], " "))
+			if err != nil || len(groups) != 1 || len(levels) == 0 {
+				fmt.Fprintln(r.out, "usage: m N FOLDER")
+				continue
+			}
+			r.redirect(groups[0], levels)
+			r.list()
+		case "s":
+			groups, err := r.selection(args)
+			if err != nil {
+				fmt.Fprintln(r.out, err)
+				continue
+			}
+			for _, group := range groups {
+				fmt.Fprintf(r.out, "%s:\n", group.folder)
+				for _, move := range group.moves {
+					fmt.Fprintf(r.out, "  %s\n", move.Src)
+				}
+			}
+		case "l":
+			r.list()
+		case "x":
+			var moves []sorter.Move
+			for _, group := range r.groups {
+				if group.status == reviewApproved {
+					moves = append(moves, group.moves...)
+				}
+			}
+			if len(moves) == 0 {
+				fmt.Fprintln(r.out, "No folder is approved yet")
+				continue
+			}
+			return moves, true
+		case "q":
+			return nil, false
+		default:
+			r.help()
+		}
+	}
+}
+
+// Run the review command: plan a sort, or read a plan, review it folder
+// by folder and carry out the approved part
+func runReview(args []string) error {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	flags := registerSortFlags(fs)
+	planPath := fs.String("plan", "", "review this plan file instead of planning a sort")
+	var logs logFlags
+	logs.register(fs)
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+
+	if *flags.touchExifDate {
+		// A plan has no capture times to set
+		return fmt.Errorf("review cannot -touch-exif-date; sort with it instead")
+	}
+
+	logger, closeLog, err := logs.setup()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	var moves []sorter.Move
+	if *planPath != "" {
+		if moves, err = sorter.ReadPlan(*planPath); err != nil {
+			return err
+		}
+	} else {
+		*flags.dryRun = true
+		*flags.noProgress = true
+		session, err := flags.newSession(logger)
+		if err != nil {
+			return err
+		}
+		summary, err := session.sorter.Run(flags.src.dirs...)
+		session.close()
+		if err != nil {
+			return err
+		}
+		for _, move := range summary.Moves {
+			moves = append(moves, sorter.Move{Src: absPath(move.Src), Dst: absPath(move.Dst)})
+		}
+	}
+	if len(moves) == 0 {
+		fmt.Println("Nothing to sort")
+		return nil
+	}
+
+	review := &reviewer{in: bufio.NewReader(os.Stdin), out: os.Stdout, dest: *flags.dest, groups: groupMoves(moves, *flags.dest)}
+	approved, ok := review.run()
+	if !ok {
+		fmt.Println("Nothing moved")
+		return nil
+	}
+
+	// The planning run is a dry run, so the journal is opened only now
+	if *flags.journalPath == "" {
+		*flags.journalPath = sorter.DefaultJournalPath(*flags.dest, time.Now())
+	}
+	journal, err := sorter.CreateJournal(*flags.journalPath)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	summary, err := sorter.Apply(approved, *flags.mode, journal, logger)
+	if err != nil {
+		return err
+	}
+	summary.Print(os.Stdout)
+	if summary.Moved > 0 {
+		fmt.Printf("Journal written to %s; undo with: pic-sorter undo %s\n", journal.Path(), journal.Path())
+	}
+	if summary.Failed > 0 {
+		return &partialError{count: summary.Failed, what: "moves could not be applied"}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pic-sorter/pkg/sorter"
+	"pic-sorter/pkg/thumb"
+)
+
+//go:embed web/index.html
+var indexHTML []byte
+
+// Thumbnails kept in memory by the web UI before the cache starts over
+const maxCachedThumbs = 2048
+
+// A photo as the web UI sees it
+type webPhoto struct {
+	Path   string    `json:"path"` // relative to the sorted tree, '/' separated
+	Name   string    `json:"name"`
+	HasGPS bool      `json:"has_gps"`
+	Lat    float64   `json:"lat,omitempty"`
+	Lon    float64   `json:"lon,omitempty"`
+	Time   time.Time `json:"time"`
+	Place  string    `json:"place,omitempty"`
+	Camera string    `json:"camera,omitempty"`
+}
+
+// A subfolder of the one being browsed
+type webFolder struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"` // photos anywhere below it
+}
+
+// Serves the sorted tree from its catalog. The catalog is read again for
+// every listing so photos sorted while the server runs show up.
+type webServer struct {
+	root        string // absolute path of the sorted tree
+	catalogPath string
+	thumbSize   int
+	logger      *slog.Logger
+
+	mu     sync.Mutex
+	thumbs map[string][]byte // by relative path
+}
+
+// Load the photos of the catalog that are still in the tree, keyed by
+// their relative path
+func (w *webServer) photos() (map[string]sorter.CatalogEntry, error) {
+	catalog, err := sorter.OpenCatalog(w.catalogPath)
+	if err != nil {
+		return nil, err
+	}
+	return treePhotos(w.root, catalog.Query(sorter.CatalogQuery{})), nil
+}
+
+// Key the entries of photos still in the tree at root by their relative,
+// '/' separated path
+func treePhotos(root string, entries []sorter.CatalogEntry) map[string]sorter.CatalogEntry {
+	photos := make(map[string]sorter.CatalogEntry)
+	for _, entry := range entries {
+		rel, err := filepath.Rel(root, entry.Path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if _, err := os.Stat(entry.Path); err != nil {
+			continue
+		}
+		photos[filepath.ToSlash(rel)] = entry
+	}
+	return photos
+}
+
+// Convert a catalog entry to what the UI shows
+func toWebPhoto(rel string, entry sorter.CatalogEntry) webPhoto {
+	var place []string
+	for _, part := range []string{entry.Place, entry.City, entry.State, entry.Country} {
+		if part != "" {
+			place = append(place, part)
+		}
+	}
+	return webPhoto{
+		Path:   rel,
+		Name:   path.Base(rel),
+		HasGPS: entry.HasGPS,
+		Lat:    entry.Lat,
+		Lon:    entry.Lon,
+		Time:   entry.Time,
+		Place:  strings.Join(place, ", "),
+		Camera: strings.TrimSpace(entry.Make + " " + entry.Model),
+	}
+}
+
+// List a folder: its subfolders, the photos directly in it and all
+// photos below it for the map
+func (w *webServer) handleFolder(rw http.ResponseWriter, r *http.Request) {
+	folder := strings.Trim(path.Clean("/"+r.URL.Query().Get("path")), "/")
+	photos, err := w.photos()
+	if err != nil {
+		w.fail(rw, err)
+		return
+	}
+
+	var listing struct {
+		Path    string      `json:"path"`
+		Folders []webFolder `json:"folders"`
+		Photos  []webPhoto  `json:"photos"` // directly in the folder
+		Map     []webPhoto  `json:"map"`    // photos below it with coordinates
+	}
+	listing.Path = folder
+	listing.Folders, listing.Photos, listing.Map = []webFolder{}, []webPhoto{}, []webPhoto{}
+	counts := make(map[string]int)
+	prefix := folder + "/"
+	if folder == "" {
+		prefix = ""
+	}
+	for rel, entry := range photos {
+		if !strings.HasPrefix(rel, prefix) {
+			continue
+		}
+		photo := toWebPhoto(rel, entry)
+		if photo.HasGPS {
+			listing.Map = append(listing.Map, photo)
+		}
+		if sub, _, nested := strings.Cut(strings.TrimPrefix(rel, prefix), "/"); nested {
+			counts[sub]++
+		} else {
+			listing.Photos = append(listing.Photos, photo)
+		}
+	}
+	for name, count := range counts {
+		listing.Folders = append(listing.Folders, webFolder{Name: name, Count: count})
+	}
+	sort.Slice(listing.Folders, func(i, j int) bool { return listing.Folders[i].Name < listing.Folders[j].Name })
+	sort.Slice(listing.Photos, func(i, j int) bool { return listing.Photos[i].Time.Before(listing.Photos[j].Time) })
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(listing)
+}
+
+// Look up the catalog entry for a request path below prefix
+func (w *webServer) lookup(rw http.ResponseWriter, r *http.Request, prefix string) (string, sorter.CatalogEntry, bool) {
+	rel := strings.TrimPrefix(r.URL.Path, prefix)
+	photos, err := w.photos()
+	if err != nil {
+		w.fail(rw, err)
+		return "", sorter.CatalogEntry{}, false
+	}
+	// Only cataloged photos are served, never journals or other files
+	entry, ok := photos[rel]
+	if !ok {
+		http.NotFound(rw, r)
+	}
+	return rel, entry, ok
+}
+
+// Serve an original photo
+func (w *webServer) handleFile(rw http.ResponseWriter, r *http.Request) {
+	if _, entry, ok := w.lookup(rw, r, "/files/"); ok {
+		http.ServeFile(rw, r, entry.Path)
+	}
+}
+
+// Serve the thumbnail of a photo: the one sort -thumbs wrote, or one
+// generated on first use
+func (w *webServer) handleThumb(rw http.ResponseWriter, r *http.Request) {
+	rel, entry, ok := w.lookup(rw, r, "/thumbs/")
+	if !ok {
+		return
+	}
+
+	w.mu.Lock()
+	data, cached := w.thumbs[rel]
+	w.mu.Unlock()
+	if !cached {
+		data, cached = sortedThumb(w.root, entry.Path, w.thumbSize)
+	}
+	if !cached {
+		var err error
+		if data, err = thumb.Generate(entry.Path, w.thumbSize); err != nil {
+			w.logger.Debug("cannot make thumbnail", "file", entry.Path, "reason", err)
+			http.NotFound(rw, r)
+			return
+		}
+		w.mu.Lock()
+		if len(w.thumbs) >= maxCachedThumbs {
+			w.thumbs = make(map[string][]byte)
+		}
+		w.thumbs[rel] = data
+		w.mu.Unlock()
+	}
+	rw.Header().Set("Content-Type", "image/jpeg")
+	rw.Header().Set("Cache-Control", "max-age=3600")
+	rw.Write(data)
+}
+
+func (w *webServer) fail(rw http.ResponseWriter, err error) {
+	w.logger.Error("cannot read catalog", "reason", err)
+	http.Error(rw, err.Error(), http.StatusInternalServerError)
+}
+
+// Routes of the web UI
+func (w *webServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(rw, r)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Write(indexHTML)
+	})
+	mux.HandleFunc("/api/folder", w.handleFolder)
+	mux.HandleFunc("/files/", w.handleFile)
+	mux.HandleFunc("/thumbs/", w.handleThumb)
+	return mux
+}
+
+// Run the serve command
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	dest := fs.String("dest", sorter.DefaultDestRoot, "root directory of the sorted tree")
+	catalogPath := fs.String("catalog", "", "catalog file (default under -dest/.pic-sorter)")
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	thumbSize := fs.Int("thumb-size", thumb.DefaultSize, "longer side of thumbnails in pixels")
+	var logs logFlags
+	logs.register(fs)
+	if err := parseWithConfig(fs, args); err != nil {
+		return err
+	}
+
+	logger, closeLog, err := logs.setup()
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	if *catalogPath == "" {
+		*catalogPath = sorter.DefaultCatalogPath(*dest)
+	}
+	if _, err := os.Stat(*catalogPath); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no catalog at %s; sort some photos into %s first", *catalogPath, *dest)
+	}
+	root, err := filepath.Abs(*dest)
+	if err != nil {
+		return err
+	}
+
+	w := &webServer{
+		root:        root,
+		catalogPath: *catalogPath,
+		thumbSize:   *thumbSize,
+		logger:      logger,
+		thumbs:      make(map[string][]byte),
+	}
+	logger.Info("serving the sorted tree", "url", "http://"+*addr+"/", "dest", *dest)
+	return http.ListenAndServe(*addr, w.handler())
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"pic-sorter/pkg/geocode"
+	"pic-sorter/pkg/gpx"
+	"pic-sorter/pkg/sorter"
+	"pic-sorter/pkg/storage"
+)
+
+// Flags of the sort command, shared with watch
+type sortFlags struct {
+	src                            sourceList
+	dest                           *string
+	by, dateLayout, layout         *string
+	dateFallback                   *bool
+	placeholder                    *string
+	lang                           *string
+	transliterate                  *bool
+	countryFormat, cityFields      *string
+	keepSpaces, asciiNames         *bool
+	replacement                    *string
+	maxName, maxPath               *int
+	pairRaw                        *bool
+	pairLive                       *bool
+	rawExts, sidecarExts           *string
+	minPerLevel                    *int
+	granularity, unsorted          *string
+	quarantine                     *string
+	strict                         *bool
+	noSpaceCheck                   *bool
+	depth                          *int
+	tripGap                        *time.Duration
+	tripDistance                   *float64
+	eventGap                       *time.Duration
+	bursts                         *string
+	burstGap                       *time.Duration
+	burstDistance                  *int
+	mode, onDuplicate, onCollision *string
+	dryRun                         *bool
+	journalPath, planOut           *string
+	errorsReport                   *string
+	statePath                      *string
+	noState, force                 *bool
+	catalogPath                    *string
+	noCatalog                      *bool
+	progressJSON, noProgress       *bool
+	banPattern                     *string
+	workers, geocodeConcurrency    *int
+	recursive                      *bool
+	include, exclude               globList
+	geofences                      fenceList
+	after, before, countries       *string
+	boxes                          boxList
+	exts, minSize                  *string
+	skipScreenshots, localTime     *bool
+	cameraTZ                       *string
+	provider                       string
+	apiKey, geonamesDir            *string
+	agent, contact                 *string
+	rate                           *float64
+	retries                        *int
+	noCache                        *bool
+	cacheFile                      *string
+	cacheTTL                       *time.Duration
+	cachePrecision, batchPrecision *int
+	params                         queryParams
+	gpxPath                        *string
+	writeMetadata                  *bool
+	touchExifDate, autoRotate      *bool
+	thumbs                         *string
+	timeShift                      *time.Duration
+	cameraTimeShifts               cameraShifts
+	writeTimeShift                 *bool
+	maxFiles, maxGeocodeRequests   *int
+	maxDuration                    *time.Duration
+	interactive                    *bool
+	preHook, postHook              *string
+	hookTimeout                    *time.Duration
+	gpxOffset, gpxMaxGap           *time.Duration
+}
+
+// Register the sort flags on fs
+func registerSortFlags(fs *flag.FlagSet) *sortFlags {
+	f := &sortFlags{}
+	f.src = sourceList{dirs: []string{"images"}}
+	fs.Var(&f.src, "src", "directory containing the images to sort (repeatable: a file found in several is sorted from the first only)")
+	f.dest = fs.String("dest", sorter.DefaultDestRoot, "root directory of the sorted tree")
+	f.by = fs.String("by", sorter.ByLocation, "sort dimensions in folder order: location, date, trip, event or camera, e.g. location,date")
+	f.dateLayout = fs.String("date-layout", sorter.DefaultDateLayout, "Go time layout of date folders, '/' separating levels")
+	f.dateFallback = fs.Bool("date-fallback", false, "sort images without GPS by date instead of skipping them")
+	f.layout = fs.String("layout", "", "destination path template, e.g. '{{.Country}}/{{.Year}}/{{.City}}'; overrides -by")
+	f.placeholder = fs.String("placeholder", geocode.DefaultPlaceholder, "folder name used for missing location fields")
+	f.lang = fs.String("lang", geocode.DefaultLanguage, "language of place names asked from the provider, e.g. en or de, or local for the names used on the spot")
+	f.transliterate = fs.Bool("transliterate", false, "write place names in Latin letters without accents, e.g. Munchen for München")
+	f.countryFormat = fs.String("country-format", "", "spell countries as name, official, iso2 or iso3 instead of the provider's name")
+	fs.Var(&f.geofences, "geofence", "named place photos taken inside go to instead of location folders, as name=lat,lon,radius or name=lat,lon;lat,lon;lat,lon (repeatable)")
+	f.cityFields = fs.String("city-fields", "", "comma-separated address fields tried in order for the city, e.g. city,town,village")
+	f.keepSpaces = fs.Bool("keep-spaces", false, "keep spaces in folder names instead of replacing them")
+	f.asciiNames = fs.Bool("ascii", false, "make folder names plain ASCII: transliterate and replace what is left")
+	f.replacement = fs.String("replacement", "_", "replaces spaces and characters file systems reserve in folder names")
+	f.maxName = fs.Int("max-name", sorter.DefaultMaxName, "longest folder name in bytes")
+	f.maxPath = fs.Int("max-path", 0, "longest destination path in bytes, shortening folder names to fit, e.g. 260 for Windows (0 for no limit)")
+	f.pairRaw = fs.Bool("pair-raw", false, "move RAW+JPEG pairs with the same base name together")
+	f.pairLive = fs.Bool("pair-live", true, "move the still and the video of each Live Photo together, matched by base name or content identifier")
+	f.rawExts = fs.String("raw-exts", sorter.DefaultRawExts, "comma-separated RAW extensions sorted alongside images")
+	f.sidecarExts = fs.String("sidecar-exts", sorter.DefaultSidecarExts, "comma-separated extensions of sidecar files moved with their image (empty disables)")
+	f.granularity = fs.String("granularity", strings.Join(geocode.Fields, ","), "location fields used as folder levels, outermost first, e.g. country,city")
+	f.unsorted = fs.String("unsorted", "", "move images that cannot be sorted into no-gps, corrupt-exif and geocode-failed folders under this folder of -dest, e.g. "+sorter.DefaultUnsortedDir)
+	f.quarantine = fs.String("quarantine", "", "move damaged images, e.g. with truncated data or malformed EXIF, to this folder with a file saying why")
+	f.strict = fs.Bool("strict", false, "stop at the first damaged image")
+	f.noSpaceCheck = fs.Bool("no-space-check", false, "place files without first checking that -dest has room for them")
+	f.depth = fs.Int("depth", 0, "keep only the first N location levels (0 keeps all)")
+	f.minPerLevel = fs.Int("limit-depth-by-count", 0, "only create a location level holding at least N photos (0 disables)")
+	f.tripGap = fs.Duration("trip-gap", sorter.DefaultTripGap, "with -by trip, start a new trip after a pause this long")
+	f.tripDistance = fs.Float64("trip-distance", sorter.DefaultTripDistance, "with -by trip, start a new trip after a jump this many km")
+	f.eventGap = fs.Duration("event-gap", sorter.DefaultEventGap, "with -by event, start a new event after a pause this long")
+	f.bursts = fs.String("bursts", "", "group bursts and near-duplicate shots: folder (into a subfolder each) or tag (in the catalog only)")
+	f.burstGap = fs.Duration("burst-gap", sorter.DefaultBurstGap, "with -bursts, longest pause between two shots of a burst")
+	f.burstDistance = fs.Int("burst-distance", sorter.DefaultBurstDistance, "with -bursts, most perceptual hash bits two shots of a burst may differ in (0-64)")
+	f.mode = fs.String("mode", sorter.ModeMove, "how files are placed: move, or copy, link (symlinks) or hardlink to keep the originals")
+	f.onDuplicate = fs.String("on-duplicate", sorter.DuplicateSkip, "images whose content is already sorted: skip, keep-both, replace or trash")
+	f.onCollision = fs.String("on-collision", sorter.CollisionSuffix, "images whose name is taken by a different file: suffix, hash, skip or fail")
+	f.writeMetadata = fs.Bool("write-metadata", false, "write the resolved place names into the XMP metadata of sorted images")
+	f.touchExifDate = fs.Bool("touch-exif-date", false, "set the modification time of sorted files to their capture time")
+	f.timeShift = fs.Duration("time-shift", 0, "add this to capture times from the metadata, e.g. +2h or -1h30m for a camera clock set wrong")
+	f.cameraTimeShifts = cameraShifts{}
+	fs.Var(f.cameraTimeShifts, "camera-time-shift", "time shift of one camera as name=shift, e.g. 'Canon EOS R5=+2h', used instead of -time-shift (repeatable)")
+	f.writeTimeShift = fs.Bool("write-time-shift", false, "write shifted times into the EXIF data of sorted JPEGs")
+	f.autoRotate = fs.Bool("auto-rotate", false, "with -mode copy, turn copied JPEGs upright per their EXIF orientation and reset it")
+	f.thumbs = fs.String("thumbs", "", "write JPEG thumbnails of these comma-separated sizes in pixels into -dest/.thumbs, e.g. 256,1024")
+	f.dryRun = fs.Bool("dry-run", false, "geocode and print the planned moves without touching any file")
+	f.journalPath = fs.String("journal", "", "journal file recording the moves for undo (default under -dest/.pic-sorter)")
+	f.statePath = fs.String("state", "", "file recording processed files so reruns skip them (default under -dest/.pic-sorter)")
+	f.noState = fs.Bool("no-state", false, "do not read or write the state of processed files")
+	f.force = fs.Bool("force", false, "process files again even if the state has them unchanged")
+	f.catalogPath = fs.String("catalog", "", "catalog of sorted photos searched by the query command (default under -dest/.pic-sorter)")
+	f.noCatalog = fs.Bool("no-catalog", false, "do not record sorted photos in the catalog")
+	f.preHook = fs.String("pre-hook", "", "shell command run before each image is placed; failing leaves the image in place")
+	f.postHook = fs.String("post-hook", "", "shell command run after each image is placed, e.g. to notify another program")
+	f.hookTimeout = fs.Duration("hook-timeout", sorter.DefaultHookTimeout, "longest a hook command may run")
+	f.interactive = fs.Bool("interactive", false, "ask where images without GPS data should go instead of skipping them")
+	f.planOut = fs.String("plan-out", "", "write the source to destination mapping to this .json or .csv file")
+	f.errorsReport = fs.String("errors-report", "", "write the files that could not be sorted to this JSON file")
+	f.progressJSON = fs.Bool("progress-json", false, "emit JSON progress events on stdout, one per line")
+	f.noProgress = fs.Bool("no-progress", false, "do not draw a progress bar when stderr is a terminal")
+	f.banPattern = fs.String("ban-pattern", geocode.DefaultBanPattern, "regexp matched against 403/429 responses to abort the run (empty disables)")
+	f.workers = fs.Int("workers", sorter.DefaultWorkers, "files decoded and moved in parallel")
+	f.geocodeConcurrency = fs.Int("max-concurrent-geocode", 1, "geocode requests in flight at once; keep 1 for the public Nominatim")
+	f.recursive = fs.Bool("recursive", false, "also sort images in subdirectories of -src")
+	fs.Var(&f.include, "include", "only sort files matching this glob (repeatable)")
+	fs.Var(&f.exclude, "exclude", "skip files and directories matching this glob (repeatable)")
+	f.exts = fs.String("ext", "", "only sort files with these comma-separated extensions, e.g. jpg,heic,dng")
+	f.minSize = fs.String("min-size", "", "skip files smaller than this, e.g. 100KB")
+	f.after = fs.String("after", "", "only sort photos taken on or after this date, as YYYY-MM-DD")
+	f.before = fs.String("before", "", "only sort photos taken on or before this date, as YYYY-MM-DD")
+	f.countries = fs.String("country", "", "only sort photos taken in these comma-separated countries, by name or ISO code")
+	fs.Var(&f.boxes, "bbox", "only sort photos taken inside this south,west,north,east box (repeatable)")
+	f.skipScreenshots = fs.Bool("skip-screenshots", false, "leave screenshots in place, detected from EXIF, file name or screen-sized PNGs")
+	fs.StringVar(&f.provider, "provider", "nominatim", "geocoding provider: "+providerNames)
+	fs.StringVar(&f.provider, "geocoder", "nominatim", "alias of -provider")
+	f.apiKey = fs.String("api-key", "", "API key of the provider (default from its environment variable)")
+	f.localTime = fs.Bool("local-time", false, "date photos in the local time of their GPS position; needs -geonames-dir")
+	f.cameraTZ = fs.String("camera-tz", "", "with -local-time, the time zone the camera clock was set to, e.g. Europe/Berlin")
+	f.geonamesDir = fs.String("geonames-dir", "", "directory with a GeoNames dump for -provider offline")
+	f.agent = fs.String("user-agent", geocode.DefaultUserAgent, "User-Agent sent with geocoding requests")
+	f.contact = fs.String("contact", "", "contact e-mail appended to the User-Agent, as Nominatim's usage policy asks")
+	f.rate = fs.Float64("rate", 1, "geocode requests per second (0 disables the limit)")
+	f.retries = fs.Int("retries", geocode.DefaultRetries, "retries with exponential backoff after 429 or 5xx responses")
+	f.noCache = fs.Bool("no-cache", false, "do not read or write the persistent geocode cache")
+	f.cacheFile = fs.String("cache-file", "", "file holding the persistent geocode cache (default per provider in the user cache directory)")
+	f.cacheTTL = fs.Duration("cache-ttl", 180*24*time.Hour, "look up cached locations again after this long (0 keeps them forever)")
+	f.batchPrecision = fs.Int("geohash-precision", 0, "geocode once per geohash cell of this length, e.g. 5 for about 5 km; 0 looks up every photo")
+	f.cachePrecision = fs.Int("cache-precision", geocode.DefaultCachePrecision, "geohash length of cache keys; nearby photos in the same cell share a lookup")
+	f.maxFiles = fs.Int("max-files", 0, "sort at most this many images per run, leaving the rest for the next (0 means all)")
+	f.maxGeocodeRequests = fs.Int("max-geocode-requests", 0, "stop the run after this many geocode requests that miss the cache (0 means no limit)")
+	f.maxDuration = fs.Duration("max-duration", 0, "start no new image after the run has taken this long, e.g. 30m (0 means no limit)")
+	f.gpxPath = fs.String("gpx", "", "GPX file or directory of tracks used to position images without GPS data")
+	f.gpxOffset = fs.Duration("gpx-offset", 0, "added to capture times before matching them to -gpx, e.g. -1h for a camera an hour ahead")
+	f.gpxMaxGap = fs.Duration("gpx-max-gap", gpx.DefaultMaxGap, "longest gap between track points, or to the nearest one, that -gpx bridges")
+	f.params = queryParams{}
+	fs.Var(f.params, "param", "extra Nominatim/LocationIQ query parameter as key=value (repeatable)")
+	return f
+}
+
+// A Sorter built from the sort flags, with the resources it holds
+type sortSession struct {
+	sorter *sorter.Sorter
+	cache  *geocode.Cache // nil if caching is off
+	out    *os.File       // where summaries go
+	logger *slog.Logger
+}
+
+// Return the address fields chosen by -city-fields, nil for none
+func (f *sortFlags) cityFieldList() []string {
+	var fields []string
+	for _, field := range strings.Split(*f.cityFields, ",") {
+		if field = strings.ToLower(strings.TrimSpace(field)); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// Return the countries chosen by -country, nil for any
+func (f *sortFlags) countryList() []string {
+	var countries []string
+	for _, country := range strings.Split(*f.countries, ",") {
+		if country = strings.TrimSpace(country); country != "" {
+			countries = append(countries, country)
+		}
+	}
+	return countries
+}
+
+// Return the selection of -after and -before in local time; both days
+// are included, so the end is the midnight after -before
+func (f *sortFlags) dateRange() (after, before time.Time, err error) {
+	if *f.after != "" {
+		if after, err = time.ParseInLocation("2006-01-02", *f.after, time.Local); err != nil {
+			return after, before, fmt.Errorf("-after: want a date as YYYY-MM-DD, got %q", *f.after)
+		}
+	}
+	if *f.before != "" {
+		if before, err = time.ParseInLocation("2006-01-02", *f.before, time.Local); err != nil {
+			return after, before, fmt.Errorf("-before: want a date as YYYY-MM-DD, got %q", *f.before)
+		}
+		before = before.AddDate(0, 0, 1)
+	}
+	if !after.IsZero() && !before.IsZero() && !after.Before(before) {
+		return after, before, fmt.Errorf("-after %s is later than -before %s", *f.after, *f.before)
+	}
+	return after, before, nil
+}
+
+// Return the location fields chosen by -granularity and -depth
+func (f *sortFlags) levels() ([]string, error) {
+	return locationLevels(*f.granularity, *f.depth)
+}
+
+// Return the comma-separated location fields of granularity, the first
+// depth of them if depth is set
+func locationLevels(granularity string, depth int) ([]string, error) {
+	var levels []string
+	for _, field := range strings.Split(granularity, ",") {
+		if field = strings.ToLower(strings.TrimSpace(field)); field != "" {
+			levels = append(levels, field)
+		}
+	}
+	if len(levels) == 0 {
+		return nil, fmt.Errorf("-granularity names no location fields")
+	}
+	if depth < 0 {
+		return nil, fmt.Errorf("-depth must not be negative")
+	}
+	if depth > 0 && depth < len(levels) {
+		levels = levels[:depth]
+	}
+	return levels, nil
+}
+
+// Return the default cache file of a provider asked for names in lang
+// at zoom
+func defaultCacheFile(provider, lang string, zoom int) string {
+	// Answers in other languages must not mix with the English ones
+	name := provider
+	if lang != geocode.DefaultLanguage {
+		name += "-" + lang
+	}
+	// Nor detailed answers with coarse ones
+	if zoom != geocode.DefaultZoom {
+		name += fmt.Sprintf("-z%d", zoom)
+	}
+	return geocode.DefaultCachePath(name)
+}
+
+// Return the time zone finder and camera zone for -local-time and
+// -camera-tz. The offline geocoder answers both places and zones, so it is
+// reused when it is in the provider chain.
+func (f *sortFlags) timezones(offline *geocode.Offline) (geocode.TimezoneFinder, *time.Location, error) {
+	if !*f.localTime {
+		if *f.cameraTZ != "" {
+			return nil, nil, fmt.Errorf("-camera-tz needs -local-time")
+		}
+		return nil, nil, nil
+	}
+	var cameraZone *time.Location
+	if *f.cameraTZ != "" {
+		var err error
+		if cameraZone, err = time.LoadLocation(*f.cameraTZ); err != nil {
+			return nil, nil, fmt.Errorf("-camera-tz: %w", err)
+		}
+	}
+	if offline != nil {
+		return offline, cameraZone, nil
+	}
+	if *f.geonamesDir == "" {
+		return nil, nil, fmt.Errorf("-local-time needs a GeoNames dump: pass -geonames-dir")
+	}
+	offline, err := geocode.LoadGeoNames(*f.geonamesDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return offline, cameraZone, nil
+}
+
+// Build the geocoder, cache and Sorter the flags describe
+func (f *sortFlags) newSession(logger *slog.Logger) (*sortSession, error) {
+	if err := sorter.CheckDimensions(*f.by); err != nil {
+		return nil, err
+	}
+
+	levels, err := f.levels()
+	if err != nil {
+		return nil, err
+	}
+	var minSize int64
+	if *f.minSize != "" {
+		if minSize, err = sorter.ParseSize(*f.minSize); err != nil {
+			return nil, fmt.Errorf("-min-size: %w", err)
+		}
+	}
+	after, before, err := f.dateRange()
+	if err != nil {
+		return nil, err
+	}
+	thumbSizes, err := sorter.ParseThumbSizes(*f.thumbs)
+	if err != nil {
+		return nil, fmt.Errorf("-thumbs: %w", err)
+	}
+	zoom := geocode.ZoomFor(levels)
+
+	providers, err := providerChain(f.provider)
+	if err != nil {
+		return nil, err
+	}
+	geocoder, offline, err := newGeocoder(providers, providerConfig{
+		zoom:        zoom,
+		language:    *f.lang,
+		apiKey:      *f.apiKey,
+		params:      url.Values(f.params),
+		banPattern:  *f.banPattern,
+		geonamesDir: *f.geonamesDir,
+		client:      geocode.NewHTTPClient(userAgent(*f.agent, *f.contact), *f.retries),
+		rate:        *f.rate,
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	zones, cameraZone, err := f.timezones(offline)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &sortSession{logger: logger}
+
+	// Offline lookups are cheap, and keeping them out of the cache file
+	// avoids mixing answers from different datasets
+	if !*f.noCache && onlineChain(providers) {
+		if *f.cacheFile == "" {
+			*f.cacheFile = defaultCacheFile(chainName(providers), *f.lang, zoom)
+		}
+		session.cache, err = geocode.NewCache(geocoder, geocode.CacheOptions{
+			Path:      *f.cacheFile,
+			TTL:       *f.cacheTTL,
+			Precision: *f.cachePrecision,
+		})
+		if err != nil {
+			return nil, err
+		}
+		geocoder = session.cache
+	}
+
+	s := sorter.New(geocoder, sorter.Options{
+		DestRoot:      *f.dest,
+		By:            *f.by,
+		DateLayout:    *f.dateLayout,
+		CameraZone:    cameraZone,
+		DateFallback:  *f.dateFallback,
+		Unsorted:      *f.unsorted,
+		Quarantine:    *f.quarantine,
+		Strict:        *f.strict,
+		NoSpaceCheck:  *f.noSpaceCheck,
+		Layout:        *f.layout,
+		Placeholder:   *f.placeholder,
+		Transliterate: *f.transliterate,
+		CountryFormat: *f.countryFormat,
+		CityFields:    f.cityFieldList(),
+		Geofences:     f.geofences,
+		Sanitize: sorter.SanitizeOptions{
+			Replacement: *f.replacement,
+			KeepSpaces:  *f.keepSpaces,
+			ASCII:       *f.asciiNames,
+			MaxName:     *f.maxName,
+			MaxPath:     *f.maxPath,
+		},
+		PairRaw:       *f.pairRaw,
+		PairLive:      *f.pairLive,
+		RawExts:       sorter.ParseExts(*f.rawExts),
+		SidecarExts:   sorter.ParseExts(*f.sidecarExts),
+		Levels:        levels,
+		MinPerLevel:   *f.minPerLevel,
+		TripGap:       *f.tripGap,
+		TripDistance:  *f.tripDistance,
+		EventGap:      *f.eventGap,
+		Bursts:        *f.bursts,
+		BurstGap:      *f.burstGap,
+		BurstDistance: *f.burstDistance,
+		DryRun:        *f.dryRun,
+		Mode:          *f.mode,
+		OnDuplicate:   *f.onDuplicate,
+		OnCollision:   *f.onCollision,
+		Force:         *f.force,
+		WriteMetadata: *f.writeMetadata,
+		TouchExifDate: *f.touchExifDate,
+		AutoRotate:    *f.autoRotate,
+		ThumbSizes:    thumbSizes,
+
+		TimeShift:        *f.timeShift,
+		CameraTimeShifts: f.cameraTimeShifts,
+		WriteTimeShift:   *f.writeTimeShift,
+
+		Workers:            *f.workers,
+		GeocodeConcurrency: *f.geocodeConcurrency,
+		BatchPrecision:     *f.batchPrecision,
+		MaxFiles:           *f.maxFiles,
+		MaxGeocodeRequests: *f.maxGeocodeRequests,
+		MaxDuration:        *f.maxDuration,
+
+		Recursive: *f.recursive,
+		Include:   f.include,
+		Exclude:   f.exclude,
+		Exts:      sorter.ParseExts(*f.exts),
+		MinSize:   minSize,
+
+		After:     after,
+		Before:    before,
+		Areas:     f.boxes,
+		Countries: f.countryList(),
+
+		SkipScreenshots: *f.skipScreenshots,
+	})
+	s.Logger = logger
+	s.Timezones = zones
+	session.sorter = s
+
+	if *f.gpxPath != "" {
+		if s.Track, err = gpx.Load(*f.gpxPath); err != nil {
+			return nil, err
+		}
+		s.Track.Offset, s.Track.MaxGap = *f.gpxOffset, *f.gpxMaxGap
+	}
+
+	// The summary goes to stdout unless that carries the events
+	session.out = os.Stdout
+	if *f.progressJSON {
+		s.Events = os.Stdout
+		session.out = os.Stderr
+	} else if !*f.noProgress && !*f.interactive && isTerminal(os.Stderr) {
+		s.Progress = os.Stderr
+	}
+	if *f.preHook != "" {
+		s.PreMove = sorter.CommandHook(*f.preHook, *f.hookTimeout)
+	}
+	if *f.postHook != "" {
+		s.PostMove = sorter.CommandHook(*f.postHook, *f.hookTimeout)
+	}
+	if *f.interactive {
+		s.Unresolved = newPrompter(os.Stdin, os.Stderr, *f.dest, isTerminal(os.Stderr)).ask
+	}
+
+	// Neither is kept for a bucket
+	remote := f.src.remote() || storage.IsURL(*f.dest)
+	if !*f.noState && !remote {
+		if *f.statePath == "" {
+			*f.statePath = sorter.DefaultStatePath(*f.dest)
+		}
+		if s.State, err = sorter.OpenState(*f.statePath); err != nil {
+			return nil, err
+		}
+	}
+
+	if !*f.noCatalog && !*f.dryRun && !remote {
+		if *f.catalogPath == "" {
+			*f.catalogPath = sorter.DefaultCatalogPath(*f.dest)
+		}
+		if s.Catalog, err = sorter.OpenCatalog(*f.catalogPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return session, nil
+}
+
+// Open the journal of the run unless this is a dry run
+func (f *sortFlags) openJournal(session *sortSession) error {
+	if *f.dryRun {
+		return nil
+	}
+	if *f.journalPath == "" {
+		// The journal of a sort into a bucket stays on this machine
+		root := *f.dest
+		if storage.IsURL(root) {
+			root = "."
+		}
+		*f.journalPath = sorter.DefaultJournalPath(root, time.Now())
+	}
+	var err error
+	session.sorter.Journal, err = sorter.CreateJournal(*f.journalPath)
+	return err
+}
+
+// Save the geocode cache, the state and the catalog, if there are any
+func (s *sortSession) save() {
+	if s.cache != nil {
+		if err := s.cache.Save(); err != nil {
+			s.logger.Error("cannot save geocode cache", "reason", err)
+		}
+	}
+	if s.sorter.State != nil {
+		if err := s.sorter.State.Save(); err != nil {
+			s.logger.Error("cannot save state", "reason", err)
+		}
+	}
+	if s.sorter.Catalog != nil {
+		if err := s.sorter.Catalog.Save(); err != nil {
+			s.logger.Error("cannot save catalog", "reason", err)
+		}
+	}
+}
+
+// Save the cache, state and catalog and close the journal
+func (s *sortSession) close() {
+	s.save()
+	if s.sorter.Journal != nil {
+		s.sorter.Journal.Close()
+	}
+}